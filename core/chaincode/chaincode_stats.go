@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+)
+
+// ChaincodeStats holds the aggregate transaction context counts of the
+// transactions invoking a chaincode.
+type ChaincodeStats struct {
+	// Active is the number of contexts in the registry
+	Active int
+	// Completed is the number of contexts removed from the registry
+	Completed uint64
+	// TotalDuration is the sum of the lifetimes of the completed contexts
+	TotalDuration time.Duration
+}
+
+// ChaincodeStats returns the transaction context counts of each chaincode
+// with contexts created by the registry, keyed by the chaincode name found in
+// the proposal. Contexts whose proposal does not name a chaincode are counted
+// under the empty name.
+func (c *TransactionContexts) ChaincodeStats() map[string]ChaincodeStats {
+	c.chaincodesMutex.Lock()
+	defer c.chaincodesMutex.Unlock()
+	stats := make(map[string]ChaincodeStats, len(c.chaincodeStats))
+	for name, s := range c.chaincodeStats {
+		stats[name] = *s
+	}
+	return stats
+}
+
+// chaincodeStarted counts a context added to the registry.
+func (c *TransactionContexts) chaincodeStarted(txctx *TransactionContext) {
+	c.chaincodesMutex.Lock()
+	defer c.chaincodesMutex.Unlock()
+	if c.chaincodeStats == nil {
+		c.chaincodeStats = map[string]*ChaincodeStats{}
+	}
+	s := c.chaincodeStats[txctx.chaincodeName]
+	if s == nil {
+		s = &ChaincodeStats{}
+		c.chaincodeStats[txctx.chaincodeName] = s
+	}
+	s.Active++
+}
+
+// chaincodeFinished counts a context removed from the registry.
+func (c *TransactionContexts) chaincodeFinished(txctx *TransactionContext) {
+	c.chaincodesMutex.Lock()
+	defer c.chaincodesMutex.Unlock()
+	s := c.chaincodeStats[txctx.chaincodeName]
+	if s == nil {
+		return
+	}
+	s.Active--
+	s.Completed++
+	s.TotalDuration += c.now().Sub(txctx.created)
+}
+
+// getChaincodeName returns the name of the chaincode invoked by the proposal
+// or an empty string when the proposal does not name a chaincode.
+func getChaincodeName(prop *pb.Proposal) string {
+	if prop == nil {
+		return ""
+	}
+	cis, err := utils.GetChaincodeInvocationSpec(prop)
+	if err != nil {
+		return ""
+	}
+	return cis.GetChaincodeSpec().GetChaincodeId().GetName()
+}
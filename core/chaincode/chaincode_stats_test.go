@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode_test
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("ChaincodeStats", func() {
+	var (
+		txContexts *chaincode.TransactionContexts
+		now        time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1000, 0)
+		txContexts = chaincode.NewTransactionContexts(0, 0)
+		chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+	})
+
+	create := func(txID, chaincodeName string) *chaincode.TransactionContext {
+		spec := &pb.ChaincodeSpec{ChaincodeId: &pb.ChaincodeID{Name: chaincodeName}}
+		signedProp, prop := utils.MockSignedEndorserProposalOrPanic("chainID", spec, []byte("creator"), []byte("msg"))
+		txContext, err := txContexts.Create(context.Background(), "chainID", txID, signedProp, prop)
+		Expect(err).NotTo(HaveOccurred())
+		return txContext
+	}
+
+	It("records the chaincode named by the proposal on the context", func() {
+		txContext := create("transactionID", "mycc")
+		Expect(txContext.ChaincodeName()).To(Equal("mycc"))
+	})
+
+	It("counts the active contexts of each chaincode", func() {
+		create("transactionID1", "mycc")
+		create("transactionID2", "mycc")
+		create("transactionID3", "othercc")
+
+		Expect(txContexts.ChaincodeStats()).To(Equal(map[string]chaincode.ChaincodeStats{
+			"mycc":    {Active: 2},
+			"othercc": {Active: 1},
+		}))
+	})
+
+	It("aggregates the durations of completed contexts", func() {
+		create("transactionID1", "mycc")
+		create("transactionID2", "mycc")
+		create("transactionID3", "othercc")
+
+		now = now.Add(2 * time.Second)
+		txContexts.Delete("chainID", "transactionID1")
+		now = now.Add(3 * time.Second)
+		txContexts.Delete("chainID", "transactionID2")
+		txContexts.Delete("chainID", "transactionID3")
+
+		Expect(txContexts.ChaincodeStats()).To(Equal(map[string]chaincode.ChaincodeStats{
+			"mycc":    {Completed: 2, TotalDuration: 7 * time.Second},
+			"othercc": {Completed: 1, TotalDuration: 5 * time.Second},
+		}))
+	})
+
+	It("counts contexts without a chaincode under the empty name", func() {
+		_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(txContexts.ChaincodeStats()).To(Equal(map[string]chaincode.ChaincodeStats{"": {Active: 1}}))
+	})
+
+	It("returns a copy of the counts", func() {
+		create("transactionID", "mycc")
+		stats := txContexts.ChaincodeStats()
+
+		txContexts.Delete("chainID", "transactionID")
+		Expect(stats["mycc"]).To(Equal(chaincode.ChaincodeStats{Active: 1}))
+	})
+
+	It("moves the counts of transferred contexts to the destination registry", func() {
+		create("transactionID", "mycc")
+		destination := chaincode.NewTransactionContexts(0, 0)
+		Expect(txContexts.Transfer("chainID", "transactionID", destination)).To(Succeed())
+
+		Expect(txContexts.ChaincodeStats()["mycc"].Active).To(Equal(0))
+		Expect(destination.ChaincodeStats()).To(Equal(map[string]chaincode.ChaincodeStats{"mycc": {Active: 1}}))
+	})
+})
@@ -78,6 +78,11 @@ type chaincodeStream interface {
 	ccintf.ChaincodeStream
 }
 
+//go:generate counterfeiter -o mock/transaction_context_metrics.go --fake-name TransactionContextMetrics . transactionContextMetrics
+type transactionContextMetrics interface {
+	chaincode.TransactionContextMetrics
+}
+
 //go:generate counterfeiter -o mock/transaction_registry.go --fake-name TransactionRegistry . transactionRegistry
 type transactionRegistry interface {
 	chaincode.TransactionRegistry
@@ -139,3 +144,8 @@ type queryResponseBuilder interface {
 type registry interface {
 	chaincode.Registry
 }
+
+//go:generate counterfeiter -o fake/span.go --fake-name Span . span
+type span interface {
+	chaincode.Span
+}
@@ -34,14 +34,19 @@ type Launcher interface {
 
 // ChaincodeSupport responsible for providing interfacing with chaincodes from the Peer.
 type ChaincodeSupport struct {
-	Keepalive       time.Duration
-	ExecuteTimeout  time.Duration
-	UserRunsCC      bool
-	Runtime         Runtime
-	ACLProvider     ACLProvider
-	HandlerRegistry *HandlerRegistry
-	Launcher        Launcher
-	sccp            sysccprovider.SystemChaincodeProvider
+	Keepalive                 time.Duration
+	ExecuteTimeout            time.Duration
+	MaxTransactionContexts    int
+	MaxQueryIterators         int
+	MaxTransactionDuration    time.Duration
+	CompressQueryResults      bool
+	QueryCompressionThreshold int
+	UserRunsCC                bool
+	Runtime                   Runtime
+	ACLProvider               ACLProvider
+	HandlerRegistry           *HandlerRegistry
+	Launcher                  Launcher
+	sccp                      sysccprovider.SystemChaincodeProvider
 }
 
 // NewChaincodeSupport creates a new ChaincodeSupport instance.
@@ -57,12 +62,17 @@ func NewChaincodeSupport(
 	sccp sysccprovider.SystemChaincodeProvider,
 ) *ChaincodeSupport {
 	cs := &ChaincodeSupport{
-		UserRunsCC:      userRunsCC,
-		Keepalive:       config.Keepalive,
-		ExecuteTimeout:  config.ExecuteTimeout,
-		HandlerRegistry: NewHandlerRegistry(userRunsCC),
-		ACLProvider:     aclProvider,
-		sccp:            sccp,
+		UserRunsCC:                userRunsCC,
+		Keepalive:                 config.Keepalive,
+		ExecuteTimeout:            config.ExecuteTimeout,
+		MaxTransactionContexts:    config.MaxTransactionContexts,
+		MaxQueryIterators:         config.MaxQueryIterators,
+		MaxTransactionDuration:    config.MaxTransactionDuration,
+		CompressQueryResults:      config.CompressQueryResults,
+		QueryCompressionThreshold: config.QueryCompressionThreshold,
+		HandlerRegistry:           NewHandlerRegistry(userRunsCC),
+		ACLProvider:               aclProvider,
+		sccp:                      sccp,
 	}
 
 	// Keep TestQueries working
@@ -135,18 +145,28 @@ func (cs *ChaincodeSupport) HandleChaincodeStream(ctxt context.Context, stream c
 	deadline, ok := ctxt.Deadline()
 	chaincodeLogger.Debugf("Current context deadline = %s, ok = %v", deadline, ok)
 
+	txContexts := NewTransactionContexts(cs.MaxTransactionContexts, cs.MaxQueryIterators)
+	txContexts.MaxTransactionDuration = cs.MaxTransactionDuration
+	txContexts.AllowEmptyChainID = true
+
+	queryResponseBuilder := &QueryResponseGenerator{
+		MaxResultLimit:       100,
+		CompressResults:      cs.CompressQueryResults,
+		CompressionThreshold: cs.QueryCompressionThreshold,
+	}
+
 	handler := &Handler{
 		Invoker:                    cs,
 		DefinitionGetter:           &Lifecycle{Executor: cs},
 		Keepalive:                  cs.Keepalive,
 		Registry:                   cs.HandlerRegistry,
 		ACLProvider:                cs.ACLProvider,
-		TXContexts:                 NewTransactionContexts(),
+		TXContexts:                 txContexts,
 		ActiveTransactions:         NewActiveTransactions(),
 		SystemCCProvider:           cs.sccp,
 		SystemCCVersion:            util.GetSysCCVersion(),
 		InstantiationPolicyChecker: CheckInstantiationPolicyFunc(ccprovider.CheckInstantiationPolicy),
-		QueryResponseBuilder:       &QueryResponseGenerator{MaxResultLimit: 100},
+		QueryResponseBuilder:       queryResponseBuilder,
 		UUIDGenerator:              UUIDGeneratorFunc(util.GenerateUUID),
 		LedgerGetter:               peer.Default,
 	}
@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// checkpointJSON is the serialized progress of a transaction context.
+// Proposals, ledger state, and open iterators cannot be persisted and are not
+// included.
+type checkpointJSON struct {
+	ChainID   string            `json:"chain_id"`
+	TxID      string            `json:"tx_id"`
+	BytesRead int64             `json:"bytes_read"`
+	Bookmarks map[string]string `json:"bookmarks,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// Export checkpoints the progress of the transaction context associated with
+// the specified chain and transaction ID so that it can be restored with
+// Import, possibly after a restart. The checkpoint holds the bookmarks of its
+// queries, the number of bytes read, and its labels. An error is returned when
+// the context does not exist.
+func (c *TransactionContexts) Export(chainID, txID string) ([]byte, error) {
+	txctx := c.Get(chainID, txID)
+	if txctx == nil {
+		return nil, errors.Errorf("txid: %s(%s) does not exist", txID, chainID)
+	}
+
+	cp := checkpointJSON{
+		ChainID:   txctx.ChainID,
+		TxID:      txctx.TxID,
+		BytesRead: txctx.BytesRead(),
+		Bookmarks: txctx.copyBookmarks(),
+		Labels:    txctx.copyLabels(),
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal checkpoint of txid: %s(%s)", txID, chainID)
+	}
+	return b, nil
+}
+
+// Import restores a transaction context from a checkpoint created by Export.
+// The restored context has no open query iterators; queries are resumed from
+// the restored bookmarks. Because the ledger state of the original context
+// cannot be persisted, the transaction simulator and history query executor
+// of the restored context are taken from ctx as they are by Create. An error
+// is returned when the checkpoint is malformed or the context cannot be
+// created.
+func (c *TransactionContexts) Import(ctx context.Context, data []byte) error {
+	cp := checkpointJSON{}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return errors.Wrap(err, "failed to unmarshal transaction context checkpoint")
+	}
+
+	ctxID := contextID(cp.ChainID, cp.TxID)
+	shard := c.shard(ctxID)
+	if c.lookup(shard, ctxID) != nil {
+		return &ErrContextExists{ChainID: cp.ChainID, TxID: cp.TxID}
+	}
+
+	txctx, err := c.build(ctx, cp.ChainID, cp.TxID, nil, nil)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&txctx.bytesRead, cp.BytesRead)
+	for queryID, bookmark := range cp.Bookmarks {
+		txctx.SetBookmark(queryID, bookmark)
+	}
+	for key, value := range cp.Labels {
+		txctx.SetLabel(key, value)
+	}
+
+	existing, err := c.store(shard, ctxID, txctx)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return &ErrContextExists{ChainID: cp.ChainID, TxID: cp.TxID}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode_test
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/chaincode/mock"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Checkpoint", func() {
+	var (
+		txContexts *chaincode.TransactionContexts
+		restored   *chaincode.TransactionContexts
+		txContext  *chaincode.TransactionContext
+	)
+
+	BeforeEach(func() {
+		txContexts = chaincode.NewTransactionContexts(0, 0)
+		restored = chaincode.NewTransactionContexts(0, 0)
+
+		var err error
+		txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("round trips the progress of a context into another registry", func() {
+		queryResult := &queryresult.KV{Key: "key"}
+		resultsIterator := &mock.ResultsIterator{}
+		resultsIterator.NextReturnsOnCall(0, queryResult, nil)
+		Expect(txContext.RegisterIterator("query-id", resultsIterator)).To(Succeed())
+		responseGenerator := &chaincode.QueryResponseGenerator{MaxResultLimit: 10}
+		_, err := responseGenerator.BuildQueryResponse(txContext, resultsIterator, "query-id")
+		Expect(err).NotTo(HaveOccurred())
+
+		txContext.SetBookmark("query-id", "bookmark-1")
+		txContext.SetBookmark("other-query-id", "bookmark-2")
+		txContext.SetLabel("workload", "batch")
+
+		data, err := txContexts.Export("chainID", "transactionID")
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeTxSimulator := &mock.TxSimulator{}
+		ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+		Expect(restored.Import(ctx, data)).To(Succeed())
+
+		restoredContext := restored.Get("chainID", "transactionID")
+		Expect(restoredContext).NotTo(BeNil())
+		Expect(restoredContext).NotTo(BeIdenticalTo(txContext))
+		Expect(restoredContext.BytesRead()).To(Equal(int64(proto.Size(queryResult))))
+		Expect(restoredContext.GetBookmark("query-id")).To(Equal("bookmark-1"))
+		Expect(restoredContext.GetBookmark("other-query-id")).To(Equal("bookmark-2"))
+		value, ok := restoredContext.Label("workload")
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal("batch"))
+		Expect(restoredContext.TXSimulator).To(BeIdenticalTo(fakeTxSimulator))
+		Expect(restoredContext.GetIterator("query-id")).To(BeNil())
+	})
+
+	It("restores a context without progress", func() {
+		data, err := txContexts.Export("chainID", "transactionID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored.Import(context.Background(), data)).To(Succeed())
+
+		restoredContext := restored.Get("chainID", "transactionID")
+		Expect(restoredContext).NotTo(BeNil())
+		Expect(restoredContext.BytesRead()).To(BeZero())
+		Expect(restoredContext.GetBookmark("query-id")).To(BeEmpty())
+		Expect(restoredContext.Clone().Labels).To(BeNil())
+	})
+
+	Context("when the context does not exist", func() {
+		It("returns an error from Export", func() {
+			_, err := txContexts.Export("chainID", "missing-transactionID")
+			Expect(err).To(MatchError("txid: missing-transactionID(chainID) does not exist"))
+		})
+	})
+
+	Context("when the checkpoint is malformed", func() {
+		It("returns an error from Import", func() {
+			err := restored.Import(context.Background(), []byte("goo"))
+			Expect(err).To(MatchError(ContainSubstring("failed to unmarshal transaction context checkpoint")))
+			Expect(restored.Count()).To(Equal(0))
+		})
+	})
+
+	Context("when the context already exists in the registry", func() {
+		It("returns an error from Import", func() {
+			data, err := txContexts.Export("chainID", "transactionID")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = txContexts.Import(context.Background(), data)
+			Expect(err).To(MatchError("txid: transactionID(chainID) exists"))
+			Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+		})
+	})
+})
@@ -22,13 +22,18 @@ const (
 )
 
 type Config struct {
-	TLSEnabled     bool
-	Keepalive      time.Duration
-	ExecuteTimeout time.Duration
-	StartupTimeout time.Duration
-	LogFormat      string
-	LogLevel       string
-	ShimLogLevel   string
+	TLSEnabled                bool
+	Keepalive                 time.Duration
+	ExecuteTimeout            time.Duration
+	StartupTimeout            time.Duration
+	MaxTransactionContexts    int
+	MaxQueryIterators         int
+	MaxTransactionDuration    time.Duration
+	CompressQueryResults      bool
+	QueryCompressionThreshold int
+	LogFormat                 string
+	LogLevel                  string
+	ShimLogLevel              string
 }
 
 func GlobalConfig() *Config {
@@ -54,6 +59,23 @@ func (c *Config) load() {
 	if c.StartupTimeout < minimumStartupTimeout {
 		c.StartupTimeout = minimumStartupTimeout
 	}
+	c.MaxTransactionContexts = viper.GetInt("chaincode.maxTransactionContexts")
+	if c.MaxTransactionContexts < 0 {
+		c.MaxTransactionContexts = 0
+	}
+	c.MaxQueryIterators = viper.GetInt("chaincode.maxQueryIterators")
+	if c.MaxQueryIterators < 0 {
+		c.MaxQueryIterators = 0
+	}
+	c.MaxTransactionDuration = viper.GetDuration("chaincode.maxTransactionDuration")
+	if c.MaxTransactionDuration < 0 {
+		c.MaxTransactionDuration = 0
+	}
+	c.CompressQueryResults = viper.GetBool("chaincode.compressQueryResults")
+	c.QueryCompressionThreshold = viper.GetInt("chaincode.queryCompressionThreshold")
+	if c.QueryCompressionThreshold < 0 {
+		c.QueryCompressionThreshold = 0
+	}
 
 	c.LogFormat = viper.GetString("chaincode.logging.format")
 	c.LogLevel = getLogLevelFromViper("chaincode.logging.level")
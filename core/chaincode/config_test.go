@@ -33,6 +33,11 @@ var _ = Describe("Config", func() {
 			viper.Set("chaincode.keepalive", "50")
 			viper.Set("chaincode.executetimeout", "20h")
 			viper.Set("chaincode.startuptimeout", "30h")
+			viper.Set("chaincode.maxTransactionContexts", "1000")
+			viper.Set("chaincode.maxQueryIterators", "10")
+			viper.Set("chaincode.maxTransactionDuration", "5m")
+			viper.Set("chaincode.compressQueryResults", "true")
+			viper.Set("chaincode.queryCompressionThreshold", "4096")
 			viper.Set("chaincode.logging.format", "test-chaincode-logging-format")
 			viper.Set("chaincode.logging.level", "WARNING")
 			viper.Set("chaincode.logging.shim", "WARNING")
@@ -42,6 +47,11 @@ var _ = Describe("Config", func() {
 			Expect(config.Keepalive).To(Equal(50 * time.Second))
 			Expect(config.ExecuteTimeout).To(Equal(20 * time.Hour))
 			Expect(config.StartupTimeout).To(Equal(30 * time.Hour))
+			Expect(config.MaxTransactionContexts).To(Equal(1000))
+			Expect(config.MaxQueryIterators).To(Equal(10))
+			Expect(config.MaxTransactionDuration).To(Equal(5 * time.Minute))
+			Expect(config.CompressQueryResults).To(BeTrue())
+			Expect(config.QueryCompressionThreshold).To(Equal(4096))
 			Expect(config.LogFormat).To(Equal("test-chaincode-logging-format"))
 			Expect(config.LogLevel).To(Equal("WARNING"))
 			Expect(config.ShimLogLevel).To(Equal("WARNING"))
@@ -80,6 +90,17 @@ var _ = Describe("Config", func() {
 			})
 		})
 
+		Context("when the query compression threshold is negative", func() {
+			BeforeEach(func() {
+				viper.Set("chaincode.queryCompressionThreshold", "-1")
+			})
+
+			It("falls back to compressing every batch", func() {
+				config := chaincode.GlobalConfig()
+				Expect(config.QueryCompressionThreshold).To(Equal(0))
+			})
+		})
+
 		Context("when an invalid log level is configured", func() {
 			BeforeEach(func() {
 				viper.Set("chaincode.logging.level", "foo")
@@ -113,13 +134,18 @@ func capture() (restore func()) {
 	viper.SetEnvPrefix("CORE")
 	viper.AutomaticEnv()
 	config := map[string]string{
-		"peer.tls.enabled":         viper.GetString("peer.tls.enabled"),
-		"chaincode.keepalive":      viper.GetString("chaincode.keepalive"),
-		"chaincode.executetimeout": viper.GetString("chaincode.executetimeout"),
-		"chaincode.startuptimeout": viper.GetString("chaincode.startuptimeout"),
-		"chaincode.logging.format": viper.GetString("chaincode.logging.format"),
-		"chaincode.logging.level":  viper.GetString("chaincode.logging.level"),
-		"chaincode.logging.shim":   viper.GetString("chaincode.logging.shim"),
+		"peer.tls.enabled":                    viper.GetString("peer.tls.enabled"),
+		"chaincode.keepalive":                 viper.GetString("chaincode.keepalive"),
+		"chaincode.executetimeout":            viper.GetString("chaincode.executetimeout"),
+		"chaincode.startuptimeout":            viper.GetString("chaincode.startuptimeout"),
+		"chaincode.maxTransactionContexts":    viper.GetString("chaincode.maxTransactionContexts"),
+		"chaincode.maxQueryIterators":         viper.GetString("chaincode.maxQueryIterators"),
+		"chaincode.maxTransactionDuration":    viper.GetString("chaincode.maxTransactionDuration"),
+		"chaincode.compressQueryResults":      viper.GetString("chaincode.compressQueryResults"),
+		"chaincode.queryCompressionThreshold": viper.GetString("chaincode.queryCompressionThreshold"),
+		"chaincode.logging.format":            viper.GetString("chaincode.logging.format"),
+		"chaincode.logging.level":             viper.GetString("chaincode.logging.level"),
+		"chaincode.logging.shim":              viper.GetString("chaincode.logging.shim"),
 	}
 
 	return func() {
@@ -0,0 +1,152 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+// ContextEventType identifies a change to the transaction context registry.
+type ContextEventType int
+
+const (
+	// ContextCreatedEvent is published when a context is added to a registry.
+	ContextCreatedEvent ContextEventType = iota
+	// ContextDeletedEvent is published when a context is removed from a
+	// registry.
+	ContextDeletedEvent
+)
+
+func (t ContextEventType) String() string {
+	switch t {
+	case ContextCreatedEvent:
+		return "created"
+	case ContextDeletedEvent:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// ContextEvent describes the creation or deletion of a transaction context.
+type ContextEvent struct {
+	Type    ContextEventType
+	ChainID string
+	TxID    string
+}
+
+// Subscribe returns a channel on which an event is published each time a
+// transaction context is added to or removed from the registry, and a function
+// that ends the subscription and closes the channel. Events are never allowed
+// to block the registry: they are published after the registry locks have been
+// released, the channel is buffered to EventBufferSize events, and events
+// published while it is full are dropped. The events of a context that is
+// removed while it is being created may be delivered out of order.
+func (c *TransactionContexts) Subscribe() (<-chan ContextEvent, func()) {
+	size := c.EventBufferSize
+	if size < 1 {
+		size = 1
+	}
+	events := make(chan ContextEvent, size)
+
+	c.subscribersMutex.Lock()
+	if c.subscribers == nil {
+		c.subscribers = map[chan ContextEvent]struct{}{}
+	}
+	c.subscribers[events] = struct{}{}
+	c.subscribersMutex.Unlock()
+
+	unsubscribe := func() {
+		c.subscribersMutex.Lock()
+		defer c.subscribersMutex.Unlock()
+		if _, ok := c.subscribers[events]; ok {
+			delete(c.subscribers, events)
+			close(events)
+		}
+	}
+	return events, unsubscribe
+}
+
+// publish delivers an event to every subscriber without blocking.
+func (c *TransactionContexts) publish(eventType ContextEventType, txctx *TransactionContext) {
+	c.subscribersMutex.RLock()
+	defer c.subscribersMutex.RUnlock()
+
+	event := ContextEvent{Type: eventType, ChainID: txctx.ChainID, TxID: txctx.TxID}
+	for events := range c.subscribers {
+		select {
+		case events <- event:
+		default:
+			chaincodeLogger.Debugf("dropped %s event for txid: %s(%s) on slow subscriber", eventType, txctx.TxID, txctx.ChainID)
+		}
+	}
+}
+
+// EvictReason describes why a transaction context was removed from a
+// registry.
+type EvictReason int
+
+const (
+	// EvictDeleted is reported when a context is deleted by its owner.
+	EvictDeleted EvictReason = iota
+	// EvictTimeout is reported when a context is removed for exceeding the
+	// maximum transaction duration or for being older than a Reap cutoff.
+	EvictTimeout
+	// EvictOverLimit is reported when a context is evicted to bring the
+	// registry under a limit.
+	EvictOverLimit
+	// EvictPurged is reported when a context is removed because its chain or
+	// the registry is shut down or purged.
+	EvictPurged
+	// EvictQuarantined is reported when a context is moved to the quarantine
+	// for post-mortem inspection.
+	EvictQuarantined
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictDeleted:
+		return "deleted"
+	case EvictTimeout:
+		return "timeout"
+	case EvictOverLimit:
+		return "over-limit"
+	case EvictPurged:
+		return "purged"
+	case EvictQuarantined:
+		return "quarantined"
+	default:
+		return "unknown"
+	}
+}
+
+// OnEvict registers a callback that is called with the chain ID, transaction
+// ID, and reason each time a context is removed from the registry. Contexts
+// transferred to another registry are not reported. Callbacks are called in
+// registration order after the registry locks have been released, so they may
+// call back into the registry. A panicking callback is recovered and logged.
+func (c *TransactionContexts) OnEvict(callback func(chainID, txID string, reason EvictReason)) {
+	c.evictCallbacksMutex.Lock()
+	c.evictCallbacks = append(c.evictCallbacks, callback)
+	c.evictCallbacksMutex.Unlock()
+}
+
+// notifyEvicted calls the registered evict callbacks for a removed context.
+func (c *TransactionContexts) notifyEvicted(txctx *TransactionContext, reason EvictReason) {
+	c.evictCallbacksMutex.RLock()
+	callbacks := c.evictCallbacks
+	c.evictCallbacksMutex.RUnlock()
+
+	for _, callback := range callbacks {
+		c.runEvictCallback(callback, txctx, reason)
+	}
+}
+
+func (c *TransactionContexts) runEvictCallback(callback func(chainID, txID string, reason EvictReason), txctx *TransactionContext, reason EvictReason) {
+	defer func() {
+		if r := recover(); r != nil {
+			chaincodeLogger.Errorf("txid: %s(%s): recovered from panic in evict callback: %v", txctx.TxID, txctx.ChainID, r)
+		}
+	}()
+	callback(txctx.ChainID, txctx.TxID, reason)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("ContextEvents", func() {
+	var txContexts *chaincode.TransactionContexts
+
+	BeforeEach(func() {
+		txContexts = chaincode.NewTransactionContexts(0, 0)
+		txContexts.EventBufferSize = 10
+	})
+
+	It("publishes create and delete events to subscribers", func() {
+		events, unsubscribe := txContexts.Subscribe()
+		defer unsubscribe()
+
+		_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		txContexts.Delete("chainID", "transactionID")
+
+		Expect(events).To(Receive(Equal(chaincode.ContextEvent{Type: chaincode.ContextCreatedEvent, ChainID: "chainID", TxID: "transactionID"})))
+		Expect(events).To(Receive(Equal(chaincode.ContextEvent{Type: chaincode.ContextDeletedEvent, ChainID: "chainID", TxID: "transactionID"})))
+		Expect(events).NotTo(Receive())
+	})
+
+	It("fans out events to every subscriber", func() {
+		events1, unsubscribe1 := txContexts.Subscribe()
+		defer unsubscribe1()
+		events2, unsubscribe2 := txContexts.Subscribe()
+		defer unsubscribe2()
+
+		_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		expected := chaincode.ContextEvent{Type: chaincode.ContextCreatedEvent, ChainID: "chainID", TxID: "transactionID"}
+		Expect(events1).To(Receive(Equal(expected)))
+		Expect(events2).To(Receive(Equal(expected)))
+	})
+
+	It("closes the channel when unsubscribed", func() {
+		events, unsubscribe := txContexts.Subscribe()
+		unsubscribe()
+		Expect(events).To(BeClosed())
+
+		unsubscribe()
+		_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("when a subscriber is slow", func() {
+		It("drops events instead of stalling the registry", func() {
+			txContexts.EventBufferSize = 2
+			slow, unsubscribeSlow := txContexts.Subscribe()
+			defer unsubscribeSlow()
+			txContexts.EventBufferSize = 10
+			fast, unsubscribeFast := txContexts.Subscribe()
+			defer unsubscribeFast()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 5; i++ {
+					txContexts.Create(context.Background(), "chainID", fmt.Sprintf("transactionID%d", i), nil, nil)
+				}
+			}()
+			Eventually(done).Should(BeClosed())
+
+			Expect(slow).To(HaveLen(2))
+			Expect(fast).To(HaveLen(5))
+			Expect(txContexts.Count()).To(Equal(5))
+		})
+	})
+})
+
+var _ = Describe("OnEvict", func() {
+	type eviction struct {
+		chainID string
+		txID    string
+		reason  chaincode.EvictReason
+	}
+
+	var (
+		txContexts *chaincode.TransactionContexts
+		mutex      sync.Mutex
+		evictions  []eviction
+	)
+
+	recorded := func() []eviction {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]eviction(nil), evictions...)
+	}
+
+	BeforeEach(func() {
+		evictions = nil
+		txContexts = chaincode.NewTransactionContexts(0, 0)
+		txContexts.OnEvict(func(chainID, txID string, reason chaincode.EvictReason) {
+			mutex.Lock()
+			evictions = append(evictions, eviction{chainID: chainID, txID: txID, reason: reason})
+			mutex.Unlock()
+		})
+
+		_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("reports contexts removed by Delete", func() {
+		txContexts.Delete("chainID", "transactionID")
+		Expect(recorded()).To(ConsistOf(eviction{chainID: "chainID", txID: "transactionID", reason: chaincode.EvictDeleted}))
+
+		txContexts.Delete("chainID", "transactionID")
+		Expect(recorded()).To(HaveLen(1))
+	})
+
+	It("reports contexts that exceed the maximum transaction duration", func() {
+		txContexts.MaxTransactionDuration = 10 * time.Millisecond
+		_, err := txContexts.Create(context.Background(), "chainID", "slow-transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(recorded).Should(ConsistOf(eviction{chainID: "chainID", txID: "slow-transactionID", reason: chaincode.EvictTimeout}))
+	})
+
+	It("reports contexts removed by Reap as timed out", func() {
+		Eventually(func() int { return txContexts.Reap(0) }).Should(Equal(1))
+		Expect(recorded()).To(ConsistOf(eviction{chainID: "chainID", txID: "transactionID", reason: chaincode.EvictTimeout}))
+	})
+
+	It("reports contexts removed to satisfy a limit", func() {
+		Expect(txContexts.Evict(0)).To(Equal(1))
+		Expect(recorded()).To(ConsistOf(eviction{chainID: "chainID", txID: "transactionID", reason: chaincode.EvictOverLimit}))
+	})
+
+	It("reports contexts removed by Purge", func() {
+		txContexts.Purge()
+		Expect(recorded()).To(ConsistOf(eviction{chainID: "chainID", txID: "transactionID", reason: chaincode.EvictPurged}))
+	})
+
+	It("does not report transferred contexts", func() {
+		Expect(txContexts.Transfer("chainID", "transactionID", chaincode.NewTransactionContexts(0, 0))).To(Succeed())
+		Expect(recorded()).To(BeEmpty())
+	})
+
+	It("allows callbacks to call back into the registry while all contexts are removed", func() {
+		_, err := txContexts.Create(context.Background(), "otherChainID", "transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		var counts []int
+		txContexts.OnEvict(func(chainID, txID string, reason chaincode.EvictReason) {
+			counts = append(counts, txContexts.Count())
+			Expect(txContexts.Get(chainID, txID)).To(BeNil())
+		})
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			txContexts.Purge()
+		}()
+		Eventually(done).Should(BeClosed())
+		Expect(counts).To(Equal([]int{0, 0}))
+	})
+
+	It("allows callbacks to create contexts", func() {
+		txContexts.OnEvict(func(chainID, txID string, reason chaincode.EvictReason) {
+			_, err := txContexts.Create(context.Background(), chainID, "retry-"+txID, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		txContexts.Delete("chainID", "transactionID")
+		Expect(txContexts.Get("chainID", "retry-transactionID")).NotTo(BeNil())
+	})
+
+	It("recovers from panicking callbacks", func() {
+		txContexts.OnEvict(func(string, string, chaincode.EvictReason) { panic("boom") })
+
+		Expect(func() { txContexts.Delete("chainID", "transactionID") }).NotTo(Panic())
+		Expect(recorded()).To(HaveLen(1))
+		Expect(txContexts.Count()).To(Equal(0))
+	})
+
+	It("names each reason", func() {
+		Expect(chaincode.EvictDeleted.String()).To(Equal("deleted"))
+		Expect(chaincode.EvictTimeout.String()).To(Equal("timeout"))
+		Expect(chaincode.EvictOverLimit.String()).To(Equal("over-limit"))
+		Expect(chaincode.EvictPurged.String()).To(Equal("purged"))
+		Expect(chaincode.EvictQuarantined.String()).To(Equal("quarantined"))
+		Expect(chaincode.EvictReason(42).String()).To(Equal("unknown"))
+	})
+})
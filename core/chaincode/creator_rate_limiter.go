@@ -0,0 +1,115 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/pkg/errors"
+)
+
+// ErrRateLimited is returned when a creator identity has exceeded the rate at
+// which it may create transaction contexts.
+var ErrRateLimited = errors.New("transaction context creation rate exceeded")
+
+// CreatorRateLimiter limits the rate of transaction context creation for each
+// proposal creator identity with a token bucket per identity. Identities are
+// keyed by MSP ID and identity bytes; creators whose identity is unknown or
+// cannot be parsed share a single bucket. Buckets that have refilled to the
+// burst size are discarded.
+type CreatorRateLimiter struct {
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[creatorKey]*tokenBucket
+	pruned  time.Time
+	now     func() time.Time
+}
+
+// creatorKey identifies the bucket of a creator. The zero value is the bucket
+// shared by unknown creators.
+type creatorKey struct {
+	mspID string
+	id    string
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewCreatorRateLimiter creates a limiter that allows each identity to create
+// rate contexts per second with bursts of at most burst contexts.
+func NewCreatorRateLimiter(rate float64, burst int) *CreatorRateLimiter {
+	return &CreatorRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[creatorKey]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// Allow consumes a token from the bucket of the creator identity and returns
+// false when the bucket is empty.
+func (r *CreatorRateLimiter) Allow(creator []byte) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	r.prune(now)
+
+	key := keyOf(creator)
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: r.burst, last: now}
+		r.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * r.rate
+	if bucket.tokens > r.burst {
+		bucket.tokens = r.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// prune discards the buckets that have refilled to the burst size since they
+// were last used; such a bucket is indistinguishable from a new one. Buckets
+// are scanned at most once per refill period.
+func (r *CreatorRateLimiter) prune(now time.Time) {
+	if r.rate <= 0 {
+		return
+	}
+	refill := time.Duration(r.burst / r.rate * float64(time.Second))
+	if now.Sub(r.pruned) < refill {
+		return
+	}
+	r.pruned = now
+
+	for key, bucket := range r.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*r.rate >= r.burst {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// keyOf returns the bucket key of a serialized creator identity.
+func keyOf(creator []byte) creatorKey {
+	sid := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(creator, sid); err != nil {
+		return creatorKey{}
+	}
+	return creatorKey{mspID: sid.Mspid, id: string(sid.IdBytes)}
+}
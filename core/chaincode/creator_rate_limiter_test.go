@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CreatorRateLimiter", func() {
+	var (
+		limiter *chaincode.CreatorRateLimiter
+		now     time.Time
+	)
+
+	identity := func(mspID, id string) []byte {
+		return utils.MarshalOrPanic(&msp.SerializedIdentity{Mspid: mspID, IdBytes: []byte(id)})
+	}
+
+	BeforeEach(func() {
+		limiter = chaincode.NewCreatorRateLimiter(1, 2)
+		now = time.Unix(1000, 0)
+		chaincode.SetCreatorRateLimiterClock(limiter, func() time.Time { return now })
+	})
+
+	It("allows bursts up to the configured size", func() {
+		Expect(limiter.Allow(identity("msp", "creator"))).To(BeTrue())
+		Expect(limiter.Allow(identity("msp", "creator"))).To(BeTrue())
+		Expect(limiter.Allow(identity("msp", "creator"))).To(BeFalse())
+	})
+
+	It("refills tokens at the configured rate", func() {
+		limiter.Allow(identity("msp", "creator"))
+		limiter.Allow(identity("msp", "creator"))
+		Expect(limiter.Allow(identity("msp", "creator"))).To(BeFalse())
+
+		now = now.Add(time.Second)
+		Expect(limiter.Allow(identity("msp", "creator"))).To(BeTrue())
+		Expect(limiter.Allow(identity("msp", "creator"))).To(BeFalse())
+
+		now = now.Add(time.Hour)
+		Expect(limiter.Allow(identity("msp", "creator"))).To(BeTrue())
+		Expect(limiter.Allow(identity("msp", "creator"))).To(BeTrue())
+		Expect(limiter.Allow(identity("msp", "creator"))).To(BeFalse())
+	})
+
+	It("limits each creator independently", func() {
+		limiter.Allow(identity("msp", "creator-1"))
+		limiter.Allow(identity("msp", "creator-1"))
+		Expect(limiter.Allow(identity("msp", "creator-1"))).To(BeFalse())
+		Expect(limiter.Allow(identity("msp", "creator-2"))).To(BeTrue())
+		Expect(limiter.Allow(identity("other-msp", "creator-1"))).To(BeTrue())
+	})
+
+	It("places unknown creators in a shared bucket", func() {
+		Expect(limiter.Allow(nil)).To(BeTrue())
+		Expect(limiter.Allow([]byte{})).To(BeTrue())
+		Expect(limiter.Allow(nil)).To(BeFalse())
+	})
+
+	It("places unparseable creators in the shared bucket", func() {
+		Expect(limiter.Allow([]byte("garbage-1"))).To(BeTrue())
+		Expect(limiter.Allow([]byte("garbage-2"))).To(BeTrue())
+		Expect(limiter.Allow(nil)).To(BeFalse())
+	})
+
+	It("discards buckets that have refilled", func() {
+		for i := 0; i < 10; i++ {
+			limiter.Allow(identity("msp", fmt.Sprintf("creator-%d", i)))
+		}
+		Expect(chaincode.CreatorRateLimiterBuckets(limiter)).To(Equal(10))
+
+		now = now.Add(time.Second)
+		limiter.Allow(identity("msp", "creator-0"))
+		Expect(chaincode.CreatorRateLimiterBuckets(limiter)).To(Equal(10))
+
+		now = now.Add(2 * time.Second)
+		limiter.Allow(identity("msp", "creator-1"))
+		Expect(chaincode.CreatorRateLimiterBuckets(limiter)).To(Equal(1))
+	})
+})
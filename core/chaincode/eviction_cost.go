@@ -0,0 +1,28 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+// CostWeights weighs the resources held by a transaction context to score its
+// cost for eviction. The cost of a context is the weighted sum of the bytes of
+// query results it has read, its open query iterators, and the keys it has
+// written. Written keys are only counted when the registry tracks read-write
+// set statistics.
+type CostWeights struct {
+	BytesRead     float64
+	OpenIterators float64
+	WriteSetSize  float64
+}
+
+// cost returns the resource cost of the transaction context.
+func (t *TransactionContext) cost(w CostWeights) float64 {
+	t.queryMutex.Lock()
+	iterators := len(t.queryIteratorMap)
+	t.queryMutex.Unlock()
+	_, writes := t.RWSetStats()
+
+	return w.BytesRead*float64(t.BytesRead()) + w.OpenIterators*float64(iterators) + w.WriteSetSize*float64(writes)
+}
@@ -39,15 +39,27 @@ type ContextRegistry struct {
 	getReturnsOnCall map[int]struct {
 		result1 *chaincode_test.TransactionContext
 	}
-	DeleteStub        func(chainID, txID string)
+	DeleteStub        func(chainID, txID string) bool
 	deleteMutex       sync.RWMutex
 	deleteArgsForCall []struct {
 		chainID string
 		txID    string
 	}
-	CloseStub        func()
+	deleteReturns struct {
+		result1 bool
+	}
+	deleteReturnsOnCall map[int]struct {
+		result1 bool
+	}
+	CloseStub        func() error
 	closeMutex       sync.RWMutex
 	closeArgsForCall []struct{}
+	closeReturns     struct {
+		result1 error
+	}
+	closeReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -156,8 +168,9 @@ func (fake *ContextRegistry) GetReturnsOnCall(i int, result1 *chaincode_test.Tra
 	}{result1}
 }
 
-func (fake *ContextRegistry) Delete(chainID string, txID string) {
+func (fake *ContextRegistry) Delete(chainID string, txID string) bool {
 	fake.deleteMutex.Lock()
+	ret, specificReturn := fake.deleteReturnsOnCall[len(fake.deleteArgsForCall)]
 	fake.deleteArgsForCall = append(fake.deleteArgsForCall, struct {
 		chainID string
 		txID    string
@@ -165,8 +178,12 @@ func (fake *ContextRegistry) Delete(chainID string, txID string) {
 	fake.recordInvocation("Delete", []interface{}{chainID, txID})
 	fake.deleteMutex.Unlock()
 	if fake.DeleteStub != nil {
-		fake.DeleteStub(chainID, txID)
+		return fake.DeleteStub(chainID, txID)
+	}
+	if specificReturn {
+		return ret.result1
 	}
+	return fake.deleteReturns.result1
 }
 
 func (fake *ContextRegistry) DeleteCallCount() int {
@@ -181,14 +198,38 @@ func (fake *ContextRegistry) DeleteArgsForCall(i int) (string, string) {
 	return fake.deleteArgsForCall[i].chainID, fake.deleteArgsForCall[i].txID
 }
 
-func (fake *ContextRegistry) Close() {
+func (fake *ContextRegistry) DeleteReturns(result1 bool) {
+	fake.DeleteStub = nil
+	fake.deleteReturns = struct {
+		result1 bool
+	}{result1}
+}
+
+func (fake *ContextRegistry) DeleteReturnsOnCall(i int, result1 bool) {
+	fake.DeleteStub = nil
+	if fake.deleteReturnsOnCall == nil {
+		fake.deleteReturnsOnCall = make(map[int]struct {
+			result1 bool
+		})
+	}
+	fake.deleteReturnsOnCall[i] = struct {
+		result1 bool
+	}{result1}
+}
+
+func (fake *ContextRegistry) Close() error {
 	fake.closeMutex.Lock()
+	ret, specificReturn := fake.closeReturnsOnCall[len(fake.closeArgsForCall)]
 	fake.closeArgsForCall = append(fake.closeArgsForCall, struct{}{})
 	fake.recordInvocation("Close", []interface{}{})
 	fake.closeMutex.Unlock()
 	if fake.CloseStub != nil {
-		fake.CloseStub()
+		return fake.CloseStub()
 	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.closeReturns.result1
 }
 
 func (fake *ContextRegistry) CloseCallCount() int {
@@ -197,6 +238,25 @@ func (fake *ContextRegistry) CloseCallCount() int {
 	return len(fake.closeArgsForCall)
 }
 
+func (fake *ContextRegistry) CloseReturns(result1 error) {
+	fake.CloseStub = nil
+	fake.closeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *ContextRegistry) CloseReturnsOnCall(i int, result1 error) {
+	fake.CloseStub = nil
+	if fake.closeReturnsOnCall == nil {
+		fake.closeReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.closeReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *ContextRegistry) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -63,8 +63,8 @@ type TransactionRegistry interface {
 type ContextRegistry interface {
 	Create(ctx context.Context, chainID, txID string, signedProp *pb.SignedProposal, proposal *pb.Proposal) (*TransactionContext, error)
 	Get(chainID, txID string) *TransactionContext
-	Delete(chainID, txID string)
-	Close()
+	Delete(chainID, txID string) bool
+	Close() error
 }
 
 // InstantiationPolicyChecker is used to evaluate instantiation policies.
@@ -498,14 +498,16 @@ func (h *Handler) Notify(msg *pb.ChaincodeMessage) {
 	}
 
 	chaincodeLogger.Debugf("[%s] notifying Txid:%s, channelID:%s", shorttxid(msg.Txid), msg.Txid, msg.ChannelId)
-	tctx.ResponseNotifier <- msg
+	if !tctx.Notify(msg) {
+		chaincodeLogger.Warningf("[%s] response notifier for Txid:%s, channelID:%s is full, dropping message %s", shorttxid(msg.Txid), msg.Txid, msg.ChannelId, msg.Type)
+	}
 	tctx.CloseQueryIterators()
 }
 
 // is this a txid for which there is a valid txsim
 func (h *Handler) isValidTxSim(channelID string, txid string, fmtStr string, args ...interface{}) (*TransactionContext, error) {
 	txContext := h.TXContexts.Get(channelID, txid)
-	if txContext == nil || txContext.TXSimulator == nil {
+	if txContext == nil || txContext.GetTxSimulator() == nil {
 		err := errors.Errorf(fmtStr, args...)
 		chaincodeLogger.Errorf("%+v", err)
 		return nil, err
@@ -543,12 +545,12 @@ func (h *Handler) HandleGetState(msg *pb.ChaincodeMessage, txContext *Transactio
 
 	var res []byte
 	if isCollectionSet(getState.Collection) {
-		res, err = txContext.TXSimulator.GetPrivateData(chaincodeName, getState.Collection, getState.Key)
+		res, err = txContext.GetTxSimulator().GetPrivateData(chaincodeName, getState.Collection, getState.Key)
 	} else {
-		res, err = txContext.TXSimulator.GetState(chaincodeName, getState.Key)
+		res, err = txContext.GetTxSimulator().GetState(chaincodeName, getState.Key)
 	}
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, txContext.WrapErr(err, "get state")
 	}
 	if res == nil {
 		chaincodeLogger.Debugf("[%s] No state associated with key: %s. Sending %s with an empty payload", shorttxid(msg.Txid), key, pb.ChaincodeMessage_RESPONSE)
@@ -565,21 +567,22 @@ func (h *Handler) HandleGetStateByRange(msg *pb.ChaincodeMessage, txContext *Tra
 	if err != nil {
 		return nil, errors.Wrap(err, "unmarshal failed")
 	}
+	if err := txContext.checkQuerySource(QueryTypeRange); err != nil {
+		return nil, err
+	}
 
-	iterID := h.UUIDGenerator.New()
+	clientID := h.UUIDGenerator.New()
 	chaincodeName := h.ChaincodeName()
 
-	var rangeIter commonledger.ResultsIterator
-	if isCollectionSet(getStateByRange.Collection) {
-		rangeIter, err = txContext.TXSimulator.GetPrivateDataRangeScanIterator(chaincodeName, getStateByRange.Collection, getStateByRange.StartKey, getStateByRange.EndKey)
-	} else {
-		rangeIter, err = txContext.TXSimulator.GetStateRangeScanIterator(chaincodeName, getStateByRange.StartKey, getStateByRange.EndKey)
-	}
+	iterID, rangeIter, err := txContext.openClientIterator(clientID, QueryTypeRange, func() (commonledger.ResultsIterator, error) {
+		if isCollectionSet(getStateByRange.Collection) {
+			return txContext.GetTxSimulator().GetPrivateDataRangeScanIterator(chaincodeName, getStateByRange.Collection, getStateByRange.StartKey, getStateByRange.EndKey)
+		}
+		return txContext.GetTxSimulator().GetStateRangeScanIterator(chaincodeName, getStateByRange.StartKey, getStateByRange.EndKey)
+	})
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, txContext.WrapErr(err, "get state by range")
 	}
-
-	txContext.InitializeQueryContext(iterID, rangeIter)
 	payload, err := h.QueryResponseBuilder.BuildQueryResponse(txContext, rangeIter, iterID)
 	if err != nil {
 		txContext.CleanupQueryContext(iterID)
@@ -604,8 +607,11 @@ func (h *Handler) HandleQueryStateNext(msg *pb.ChaincodeMessage, txContext *Tran
 		return nil, errors.Wrap(err, "unmarshal failed")
 	}
 
-	queryIter := txContext.GetQueryIterator(queryStateNext.Id)
+	queryIter := txContext.GetIterator(queryStateNext.Id)
 	if queryIter == nil {
+		if txContext.iteratorIdle(queryStateNext.Id) {
+			return nil, errors.Wrapf(ErrIteratorIdle, "query iterator %s", queryStateNext.Id)
+		}
 		return nil, errors.New("query iterator not found")
 	}
 
@@ -632,7 +638,7 @@ func (h *Handler) HandleQueryStateClose(msg *pb.ChaincodeMessage, txContext *Tra
 		return nil, errors.Wrap(err, "unmarshal failed")
 	}
 
-	iter := txContext.GetQueryIterator(queryStateClose.Id)
+	iter := txContext.GetIterator(queryStateClose.Id)
 	if iter != nil {
 		txContext.CleanupQueryContext(queryStateClose.Id)
 	}
@@ -648,7 +654,7 @@ func (h *Handler) HandleQueryStateClose(msg *pb.ChaincodeMessage, txContext *Tra
 
 // Handles query to ledger to execute query state
 func (h *Handler) HandleGetQueryResult(msg *pb.ChaincodeMessage, txContext *TransactionContext) (*pb.ChaincodeMessage, error) {
-	iterID := h.UUIDGenerator.New()
+	clientID := h.UUIDGenerator.New()
 	chaincodeName := h.ChaincodeName()
 
 	getQueryResult := &pb.GetQueryResult{}
@@ -656,19 +662,20 @@ func (h *Handler) HandleGetQueryResult(msg *pb.ChaincodeMessage, txContext *Tran
 	if err != nil {
 		return nil, errors.Wrap(err, "unmarshal failed")
 	}
-
-	var executeIter commonledger.ResultsIterator
-	if isCollectionSet(getQueryResult.Collection) {
-		executeIter, err = txContext.TXSimulator.ExecuteQueryOnPrivateData(chaincodeName, getQueryResult.Collection, getQueryResult.Query)
-	} else {
-		executeIter, err = txContext.TXSimulator.ExecuteQuery(chaincodeName, getQueryResult.Query)
+	if err := txContext.checkQuerySource(QueryTypeRich); err != nil {
+		return nil, err
 	}
+
+	iterID, executeIter, err := txContext.openClientIterator(clientID, QueryTypeRich, func() (commonledger.ResultsIterator, error) {
+		if isCollectionSet(getQueryResult.Collection) {
+			return txContext.GetTxSimulator().ExecuteQueryOnPrivateData(chaincodeName, getQueryResult.Collection, getQueryResult.Query)
+		}
+		return txContext.GetTxSimulator().ExecuteQuery(chaincodeName, getQueryResult.Query)
+	})
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, txContext.WrapErr(err, "get query result")
 	}
 
-	txContext.InitializeQueryContext(iterID, executeIter)
-
 	payload, err := h.QueryResponseBuilder.BuildQueryResponse(txContext, executeIter, iterID)
 	if err != nil {
 		txContext.CleanupQueryContext(iterID)
@@ -687,7 +694,7 @@ func (h *Handler) HandleGetQueryResult(msg *pb.ChaincodeMessage, txContext *Tran
 
 // Handles query to ledger history db
 func (h *Handler) HandleGetHistoryForKey(msg *pb.ChaincodeMessage, txContext *TransactionContext) (*pb.ChaincodeMessage, error) {
-	iterID := h.UUIDGenerator.New()
+	clientID := h.UUIDGenerator.New()
 	chaincodeName := h.ChaincodeName()
 
 	getHistoryForKey := &pb.GetHistoryForKey{}
@@ -696,12 +703,19 @@ func (h *Handler) HandleGetHistoryForKey(msg *pb.ChaincodeMessage, txContext *Tr
 		return nil, errors.Wrap(err, "unmarshal failed")
 	}
 
-	historyIter, err := txContext.HistoryQueryExecutor.GetHistoryForKey(chaincodeName, getHistoryForKey.Key)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	if err := txContext.checkQuerySource(QueryTypeHistory); err != nil {
+		return nil, err
+	}
+	if !txContext.SupportsHistory() {
+		return nil, errors.New("history database not available")
 	}
 
-	txContext.InitializeQueryContext(iterID, historyIter)
+	iterID, historyIter, err := txContext.openClientIterator(clientID, QueryTypeHistory, func() (commonledger.ResultsIterator, error) {
+		return txContext.GetHistoryQueryExecutor().GetHistoryForKey(chaincodeName, getHistoryForKey.Key)
+	})
+	if err != nil {
+		return nil, txContext.WrapErr(err, "get history for key")
+	}
 	payload, err := h.QueryResponseBuilder.BuildQueryResponse(txContext, historyIter, iterID)
 	if err != nil {
 		txContext.CleanupQueryContext(iterID)
@@ -758,6 +772,10 @@ func (h *Handler) getTxContextForInvoke(channelID string, txid string, payload [
 }
 
 func (h *Handler) HandlePutState(msg *pb.ChaincodeMessage, txContext *TransactionContext) (*pb.ChaincodeMessage, error) {
+	if txContext.ReadOnly() {
+		return nil, errors.New("state writes are not permitted on a read-only transaction")
+	}
+
 	putState := &pb.PutState{}
 	err := proto.Unmarshal(msg.Payload, putState)
 	if err != nil {
@@ -766,18 +784,22 @@ func (h *Handler) HandlePutState(msg *pb.ChaincodeMessage, txContext *Transactio
 
 	chaincodeName := h.ChaincodeName()
 	if isCollectionSet(putState.Collection) {
-		err = txContext.TXSimulator.SetPrivateData(chaincodeName, putState.Collection, putState.Key, putState.Value)
+		err = txContext.GetTxSimulator().SetPrivateData(chaincodeName, putState.Collection, putState.Key, putState.Value)
 	} else {
-		err = txContext.TXSimulator.SetState(chaincodeName, putState.Key, putState.Value)
+		err = txContext.GetTxSimulator().SetState(chaincodeName, putState.Key, putState.Value)
 	}
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, txContext.WrapErr(err, "put state")
 	}
 
 	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Txid: msg.Txid, ChannelId: msg.ChannelId}, nil
 }
 
 func (h *Handler) HandleDelState(msg *pb.ChaincodeMessage, txContext *TransactionContext) (*pb.ChaincodeMessage, error) {
+	if txContext.ReadOnly() {
+		return nil, errors.New("state writes are not permitted on a read-only transaction")
+	}
+
 	delState := &pb.DelState{}
 	err := proto.Unmarshal(msg.Payload, delState)
 	if err != nil {
@@ -786,12 +808,12 @@ func (h *Handler) HandleDelState(msg *pb.ChaincodeMessage, txContext *Transactio
 
 	chaincodeName := h.ChaincodeName()
 	if isCollectionSet(delState.Collection) {
-		err = txContext.TXSimulator.DeletePrivateData(chaincodeName, delState.Collection, delState.Key)
+		err = txContext.GetTxSimulator().DeletePrivateData(chaincodeName, delState.Collection, delState.Key)
 	} else {
-		err = txContext.TXSimulator.DeleteState(chaincodeName, delState.Key)
+		err = txContext.GetTxSimulator().DeleteState(chaincodeName, delState.Key)
 	}
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, txContext.WrapErr(err, "delete state")
 	}
 
 	// Send response msg back to chaincode.
@@ -835,8 +857,8 @@ func (h *Handler) HandleInvokeChaincode(msg *pb.ChaincodeMessage, txContext *Tra
 	// Set up a new context for the called chaincode if on a different channel
 	// We grab the called channel's ledger simulator to hold the new state
 	ctxt := context.Background()
-	txsim := txContext.TXSimulator
-	historyQueryExecutor := txContext.HistoryQueryExecutor
+	txsim := txContext.GetTxSimulator()
+	historyQueryExecutor := txContext.GetHistoryQueryExecutor()
 	if targetInstance.ChainID != txContext.ChainID {
 		lgr := h.LedgerGetter.GetLedger(targetInstance.ChainID)
 		if lgr == nil {
@@ -900,11 +922,27 @@ func (h *Handler) HandleInvokeChaincode(msg *pb.ChaincodeMessage, txContext *Tra
 	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: res, Txid: msg.Txid, ChannelId: msg.ChannelId}, nil
 }
 
+// isAdmissionError returns true when the transaction context registry refused
+// to admit a context, in which case the failure is reported to the chaincode
+// as an error message.
+func isAdmissionError(err error) bool {
+	switch errors.Cause(err) {
+	case ErrTooManyContexts, ErrChannelQuotaExceeded, ErrRateLimited, ErrRegistryPaused, ErrRegistryClosed:
+		return true
+	default:
+		return false
+	}
+}
+
 func (h *Handler) Execute(ctxt context.Context, cccid *ccprovider.CCContext, msg *pb.ChaincodeMessage, timeout time.Duration) (*pb.ChaincodeMessage, error) {
 	chaincodeLogger.Debugf("Entry")
 	defer chaincodeLogger.Debugf("Exit")
 
 	txctx, err := h.TXContexts.Create(ctxt, msg.ChannelId, msg.Txid, cccid.SignedProposal, cccid.Proposal)
+	if isAdmissionError(err) {
+		chaincodeLogger.Warningf("[%s] %s", shorttxid(msg.Txid), err)
+		return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: []byte(err.Error()), Txid: msg.Txid, ChannelId: msg.ChannelId}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -916,16 +954,20 @@ func (h *Handler) Execute(ctxt context.Context, cccid *ccprovider.CCContext, msg
 
 	h.serialSendAsync(msg, true)
 
-	var ccresp *pb.ChaincodeMessage
-	select {
-	case ccresp = <-txctx.ResponseNotifier:
-		// response is sent to user or calling chaincode. ChaincodeMessage_ERROR
-		// are typically treated as error
-	case <-time.After(timeout):
-		err = errors.New("timeout expired while executing transaction")
+	waitCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// response is sent to user or calling chaincode. ChaincodeMessage_ERROR
+	// are typically treated as error
+	ccresp, err := txctx.WaitForResponse(waitCtx)
+	if err != nil {
+		return nil, errors.New("timeout expired while executing transaction")
+	}
+	if txctx.Err() == ErrTransactionTimeout {
+		return nil, errors.Wrapf(ErrTransactionTimeout, "txid: %s(%s)", msg.Txid, msg.ChannelId)
 	}
 
-	return ccresp, err
+	return ccresp, nil
 }
 
 func (h *Handler) setChaincodeProposal(signedProp *pb.SignedProposal, prop *pb.Proposal, msg *pb.ChaincodeMessage) error {
@@ -941,7 +983,7 @@ func (h *Handler) setChaincodeProposal(signedProp *pb.SignedProposal, prop *pb.P
 }
 
 func (h *Handler) State() State { return h.state }
-func (h *Handler) Close()       { h.TXContexts.Close() }
+func (h *Handler) Close() error { return h.TXContexts.Close() }
 
 type State int
 
@@ -7,6 +7,8 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode
 
 import (
+	"time"
+
 	"github.com/hyperledger/fabric/core/common/sysccprovider"
 	"github.com/hyperledger/fabric/core/container/ccintf"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -25,3 +27,33 @@ func SetHandlerChatStream(h *Handler, chatStream ccintf.ChaincodeStream) {
 func SetHandlerCCInstance(h *Handler, ccInstance *sysccprovider.ChaincodeInstance) {
 	h.ccInstance = ccInstance
 }
+
+func SetTransactionContextsClock(c *TransactionContexts, now func() time.Time) {
+	c.now = now
+}
+
+func SetCreatorRateLimiterClock(r *CreatorRateLimiter, now func() time.Time) {
+	r.now = now
+}
+
+func CreatorRateLimiterBuckets(r *CreatorRateLimiter) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.buckets)
+}
+
+func NewTransactionContextsWithShards(maxContexts, maxQueryIterators, shardCount int) *TransactionContexts {
+	return newTransactionContexts(maxContexts, maxQueryIterators, shardCount, 0)
+}
+
+func DeletePendingQueryResult(t *TransactionContext, queryID string) {
+	t.queryMutex.Lock()
+	delete(t.pendingQueryResults, queryID)
+	t.queryMutex.Unlock()
+}
+
+func AddPendingQueryResult(t *TransactionContext, queryID string) {
+	t.queryMutex.Lock()
+	t.pendingQueryResults[queryID] = &PendingQueryResult{}
+	t.queryMutex.Unlock()
+}
@@ -156,7 +156,9 @@ func (r *HandlerRegistry) Deregister(cname string) error {
 		return errors.Errorf("could not find handler: %s", cname)
 	}
 
-	handler.Close()
+	if err := handler.Close(); err != nil {
+		chaincodeLogger.Warningf("failed to close transaction contexts of handler %s: %s", cname, err)
+	}
 
 	chaincodeLogger.Debugf("deregistered handler with key: %s", cname)
 	return nil
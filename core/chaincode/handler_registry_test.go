@@ -216,13 +216,13 @@ var _ = Describe("HandlerRegistry", func() {
 
 		BeforeEach(func() {
 			fakeResultsIterator = &mock.ResultsIterator{}
-			transactionContexts := chaincode.NewTransactionContexts()
+			transactionContexts := chaincode.NewTransactionContexts(0, 0)
 
 			txContext, err := transactionContexts.Create(context.Background(), "chain-id", "transaction-id", nil, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			handler.TXContexts = transactionContexts
-			txContext.InitializeQueryContext("query-id", fakeResultsIterator)
+			txContext.RegisterIterator("query-id", fakeResultsIterator)
 
 			_, err = hr.Launching("chaincode-name")
 			Expect(err).NotTo(HaveOccurred())
@@ -244,5 +244,19 @@ var _ = Describe("HandlerRegistry", func() {
 
 			Expect(fakeResultsIterator.CloseCallCount()).To(Equal(1))
 		})
+
+		Context("when a query iterator fails to close", func() {
+			BeforeEach(func() {
+				fakeResultsIterator.CloseStub = func() { panic("close-failed") }
+			})
+
+			It("still deregisters the handler", func() {
+				err := hr.Deregister("chaincode-name")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeResultsIterator.CloseCallCount()).To(Equal(1))
+				Expect(hr.HasLaunched("chaincode-name")).To(BeFalse())
+			})
+		})
 	})
 })
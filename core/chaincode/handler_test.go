@@ -413,6 +413,22 @@ var _ = Describe("Handler", func() {
 			}))
 		})
 
+		Context("when the transaction context is read-only", func() {
+			BeforeEach(func() {
+				ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+				ctx = context.WithValue(ctx, chaincode.ReadOnlyKey, true)
+				var err error
+				txContext, err = chaincode.NewTransactionContexts(0, 0).Create(ctx, "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error without writing state", func() {
+				_, err := handler.HandlePutState(incomingMessage, txContext)
+				Expect(err).To(MatchError("state writes are not permitted on a read-only transaction"))
+				Expect(fakeTxSimulator.SetStateCallCount()).To(Equal(0))
+			})
+		})
+
 		Context("when unmarshaling the request fails", func() {
 			BeforeEach(func() {
 				incomingMessage.Payload = []byte("this-is-a-bogus-payload")
@@ -443,7 +459,7 @@ var _ = Describe("Handler", func() {
 
 				It("returns an error", func() {
 					_, err := handler.HandlePutState(incomingMessage, txContext)
-					Expect(err).To(MatchError("king-kong"))
+					Expect(err).To(MatchError("txid: (channel-id): put state failed: king-kong"))
 				})
 			})
 		})
@@ -475,7 +491,7 @@ var _ = Describe("Handler", func() {
 
 				It("returns an error", func() {
 					_, err := handler.HandlePutState(incomingMessage, txContext)
-					Expect(err).To(MatchError("godzilla"))
+					Expect(err).To(MatchError("txid: (channel-id): put state failed: godzilla"))
 				})
 			})
 		})
@@ -510,6 +526,22 @@ var _ = Describe("Handler", func() {
 			}))
 		})
 
+		Context("when the transaction context is read-only", func() {
+			BeforeEach(func() {
+				ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+				ctx = context.WithValue(ctx, chaincode.ReadOnlyKey, true)
+				var err error
+				txContext, err = chaincode.NewTransactionContexts(0, 0).Create(ctx, "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error without writing state", func() {
+				_, err := handler.HandleDelState(incomingMessage, txContext)
+				Expect(err).To(MatchError("state writes are not permitted on a read-only transaction"))
+				Expect(fakeTxSimulator.DeleteStateCallCount()).To(Equal(0))
+			})
+		})
+
 		Context("when unmarshalling the request fails", func() {
 			BeforeEach(func() {
 				incomingMessage.Payload = []byte("this-is-a-bogus-payload")
@@ -539,7 +571,7 @@ var _ = Describe("Handler", func() {
 
 				It("return an error", func() {
 					_, err := handler.HandleDelState(incomingMessage, txContext)
-					Expect(err).To(MatchError("orange"))
+					Expect(err).To(MatchError("txid: (channel-id): delete state failed: orange"))
 				})
 			})
 		})
@@ -570,7 +602,7 @@ var _ = Describe("Handler", func() {
 
 				It("returns an error", func() {
 					_, err := handler.HandleDelState(incomingMessage, txContext)
-					Expect(err).To(MatchError("mango"))
+					Expect(err).To(MatchError("txid: (channel-id): delete state failed: mango"))
 				})
 			})
 		})
@@ -644,7 +676,7 @@ var _ = Describe("Handler", func() {
 
 				It("returns the error from GetPrivateData", func() {
 					_, err := handler.HandleGetState(incomingMessage, txContext)
-					Expect(err).To(MatchError("french fries"))
+					Expect(err).To(MatchError("txid: (channel-id): get state failed: french fries"))
 				})
 			})
 
@@ -683,7 +715,7 @@ var _ = Describe("Handler", func() {
 
 				It("returns the error from GetState", func() {
 					_, err := handler.HandleGetState(incomingMessage, txContext)
-					Expect(err).To(MatchError("tomato"))
+					Expect(err).To(MatchError("txid: (channel-id): get state failed: tomato"))
 				})
 			})
 
@@ -746,14 +778,73 @@ var _ = Describe("Handler", func() {
 			}
 		})
 
+		Context("when the registry is strict about query sources", func() {
+			var txContexts *chaincode.TransactionContexts
+
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(0, 0)
+				txContexts.StrictQuerySources = true
+			})
+
+			It("rejects the query when the context has no simulator", func() {
+				txContext, err := txContexts.Create(context.Background(), "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = handler.HandleGetStateByRange(incomingMessage, txContext)
+				Expect(err).To(MatchError("txid: tx-id(channel-id): range query requires a transaction simulator: query source not available"))
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrQuerySourceUnavailable))
+				Expect(fakeQueryResponseBuilder.BuildQueryResponseCallCount()).To(Equal(0))
+			})
+
+			It("notes when the simulator of a query-only context is absent by design", func() {
+				ctx := context.WithValue(context.Background(), chaincode.QueryOnlyKey, true)
+				txContext, err := txContexts.Create(ctx, "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = handler.HandleGetStateByRange(incomingMessage, txContext)
+				Expect(err).To(MatchError("txid: tx-id(channel-id): range query requires a transaction simulator, which query-only contexts do not carry: query source not available"))
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrQuerySourceUnavailable))
+				Expect(fakeQueryResponseBuilder.BuildQueryResponseCallCount()).To(Equal(0))
+			})
+
+			It("runs the query when the context has a simulator", func() {
+				ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+				txContext, err := txContexts.Create(ctx, "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				resp, err := handler.HandleGetStateByRange(incomingMessage, txContext)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp).To(Equal(expectedResponse))
+			})
+		})
+
 		It("initializes a query context", func() {
 			_, err := handler.HandleGetStateByRange(incomingMessage, txContext)
 			Expect(err).NotTo(HaveOccurred())
 
 			pqr := txContext.GetPendingQueryResult("generated-query-id")
 			Expect(pqr).To(Equal(&chaincode.PendingQueryResult{}))
-			iter := txContext.GetQueryIterator("generated-query-id")
+			iter := txContext.GetIterator("generated-query-id")
 			Expect(iter).To(Equal(fakeIterator))
+			Expect(txContext.QueryType("generated-query-id")).To(Equal(chaincode.QueryTypeRange))
+		})
+
+		It("registers each query under a unique ID", func() {
+			secondIterator := &mock.ResultsIterator{}
+			fakeTxSimulator.GetStateRangeScanIteratorReturnsOnCall(1, secondIterator, nil)
+
+			_, err := handler.HandleGetStateByRange(incomingMessage, txContext)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = handler.HandleGetStateByRange(incomingMessage, txContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeQueryResponseBuilder.BuildQueryResponseCallCount()).To(Equal(2))
+			_, _, firstID := fakeQueryResponseBuilder.BuildQueryResponseArgsForCall(0)
+			_, _, secondID := fakeQueryResponseBuilder.BuildQueryResponseArgsForCall(1)
+			Expect(firstID).NotTo(Equal(secondID))
+			Expect(txContext.GetIterator(firstID)).To(Equal(fakeIterator))
+			Expect(txContext.GetIterator(secondID)).To(Equal(secondIterator))
+			Expect(fakeIterator.CloseCallCount()).To(Equal(0))
 		})
 
 		It("returns the response message", func() {
@@ -781,7 +872,7 @@ var _ = Describe("Handler", func() {
 
 				It("returns the error from GetStateRangeScanIterator", func() {
 					_, err := handler.HandleGetStateByRange(incomingMessage, txContext)
-					Expect(err).To(MatchError("tomato"))
+					Expect(err).To(MatchError("txid: (channel-id): get state by range failed: tomato"))
 				})
 			})
 
@@ -821,7 +912,7 @@ var _ = Describe("Handler", func() {
 
 				It("returns the error from GetPrivateDataRangeScanIterator", func() {
 					_, err := handler.HandleGetStateByRange(incomingMessage, txContext)
-					Expect(err).To(MatchError("french fries"))
+					Expect(err).To(MatchError("txid: (channel-id): get state by range failed: french fries"))
 				})
 			})
 		})
@@ -837,6 +928,28 @@ var _ = Describe("Handler", func() {
 			})
 		})
 
+		Context("when the maximum number of query iterators is open", func() {
+			BeforeEach(func() {
+				var err error
+				ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+				txContext, err = chaincode.NewTransactionContexts(0, 1).Create(ctx, "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				err = txContext.RegisterIterator("existing-query-id", &mock.ResultsIterator{})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error", func() {
+				_, err := handler.HandleGetStateByRange(incomingMessage, txContext)
+				Expect(err).To(MatchError("txid: tx-id(channel-id): get state by range failed: too many open query iterators, close some before opening more"))
+			})
+
+			It("closes the new iterator", func() {
+				handler.HandleGetStateByRange(incomingMessage, txContext)
+				Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+				Expect(txContext.GetIterator("generated-query-id")).To(BeNil())
+			})
+		})
+
 		Context("when building the query response fails", func() {
 			BeforeEach(func() {
 				fakeQueryResponseBuilder.BuildQueryResponseReturns(nil, errors.New("garbanzo"))
@@ -852,7 +965,7 @@ var _ = Describe("Handler", func() {
 
 				pqr := txContext.GetPendingQueryResult("generated-query-id")
 				Expect(pqr).To(BeNil())
-				iter := txContext.GetQueryIterator("generated-query-id")
+				iter := txContext.GetIterator("generated-query-id")
 				Expect(iter).To(BeNil())
 			})
 		})
@@ -872,7 +985,7 @@ var _ = Describe("Handler", func() {
 
 				pqr := txContext.GetPendingQueryResult("generated-query-id")
 				Expect(pqr).To(BeNil())
-				iter := txContext.GetQueryIterator("generated-query-id")
+				iter := txContext.GetIterator("generated-query-id")
 				Expect(iter).To(BeNil())
 			})
 		})
@@ -894,7 +1007,7 @@ var _ = Describe("Handler", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			fakeIterator = &mock.ResultsIterator{}
-			txContext.InitializeQueryContext("query-state-next-id", fakeIterator)
+			txContext.RegisterIterator("query-state-next-id", fakeIterator)
 
 			incomingMessage = &pb.ChaincodeMessage{
 				Type:      pb.ChaincodeMessage_GET_STATE,
@@ -958,6 +1071,64 @@ var _ = Describe("Handler", func() {
 			})
 		})
 
+		Context("when the query iterator was closed for being idle", func() {
+			var txContexts *chaincode.TransactionContexts
+
+			BeforeEach(func() {
+				now := time.Unix(1000, 0)
+				txContexts = chaincode.NewTransactionContexts(0, 0)
+				chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+
+				var err error
+				txContext, err = txContexts.Create(context.Background(), "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				txContext.RegisterIterator("query-state-next-id", fakeIterator)
+
+				now = now.Add(time.Minute)
+				Expect(txContexts.ReapIdleIterators(30 * time.Second)).To(Equal(1))
+			})
+
+			It("returns an idle iterator error", func() {
+				_, err := handler.HandleQueryStateNext(incomingMessage, txContext)
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrIteratorIdle))
+				Expect(err).To(MatchError("query iterator query-state-next-id: query iterator was closed after being idle"))
+			})
+
+			It("forgets the idle iterator once it is cleaned up", func() {
+				txContext.CleanupQueryContext("query-state-next-id")
+				_, err := handler.HandleQueryStateNext(incomingMessage, txContext)
+				Expect(err).To(MatchError("query iterator not found"))
+			})
+
+			It("forgets the idle iterator once the query contexts are closed", func() {
+				txContexts.DeleteAndClose("channel-id", "tx-id")
+				_, err := handler.HandleQueryStateNext(incomingMessage, txContext)
+				Expect(err).To(MatchError("query iterator not found"))
+			})
+
+			Context("when the iterator was registered under a client query ID", func() {
+				BeforeEach(func() {
+					now := time.Unix(1000, 0)
+					txContexts = chaincode.NewTransactionContexts(0, 0)
+					chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+
+					var err error
+					txContext, err = txContexts.Create(context.Background(), "channel-id", "tx-id", nil, nil)
+					Expect(err).NotTo(HaveOccurred())
+					_, err = txContext.RegisterClientIterator("query-state-next-id", fakeIterator)
+					Expect(err).NotTo(HaveOccurred())
+
+					now = now.Add(time.Minute)
+					Expect(txContexts.ReapIdleIterators(30 * time.Second)).To(Equal(1))
+				})
+
+				It("returns an idle iterator error", func() {
+					_, err := handler.HandleQueryStateNext(incomingMessage, txContext)
+					Expect(errors.Cause(err)).To(Equal(chaincode.ErrIteratorIdle))
+				})
+			})
+		})
+
 		Context("when building the query response fails", func() {
 			BeforeEach(func() {
 				fakeQueryResponseBuilder.BuildQueryResponseReturns(nil, errors.New("potato"))
@@ -973,7 +1144,7 @@ var _ = Describe("Handler", func() {
 
 				pqr := txContext.GetPendingQueryResult("generated-query-id")
 				Expect(pqr).To(BeNil())
-				iter := txContext.GetQueryIterator("generated-query-id")
+				iter := txContext.GetIterator("generated-query-id")
 				Expect(iter).To(BeNil())
 			})
 		})
@@ -993,7 +1164,7 @@ var _ = Describe("Handler", func() {
 
 				pqr := txContext.GetPendingQueryResult("generated-query-id")
 				Expect(pqr).To(BeNil())
-				iter := txContext.GetQueryIterator("generated-query-id")
+				iter := txContext.GetIterator("generated-query-id")
 				Expect(iter).To(BeNil())
 			})
 		})
@@ -1015,7 +1186,7 @@ var _ = Describe("Handler", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			fakeIterator = &mock.ResultsIterator{}
-			txContext.InitializeQueryContext("query-state-close-id", fakeIterator)
+			txContext.RegisterIterator("query-state-close-id", fakeIterator)
 
 			incomingMessage = &pb.ChaincodeMessage{
 				Type:      pb.ChaincodeMessage_GET_STATE,
@@ -1097,6 +1268,20 @@ var _ = Describe("Handler", func() {
 			fakeTxSimulator.ExecuteQueryReturns(fakeIterator, nil)
 		})
 
+		Context("when the registry is strict about query sources", func() {
+			It("rejects the query when the context has no simulator", func() {
+				txContexts := chaincode.NewTransactionContexts(0, 0)
+				txContexts.StrictQuerySources = true
+				ctx := context.WithValue(context.Background(), chaincode.HistoryQueryExecutorKey, fakeHistoryQueryExecutor)
+				txContext, err := txContexts.Create(ctx, "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = handler.HandleGetQueryResult(incomingMessage, txContext)
+				Expect(err).To(MatchError("txid: tx-id(channel-id): rich query requires a transaction simulator: query source not available"))
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrQuerySourceUnavailable))
+			})
+		})
+
 		Context("when collection is not set", func() {
 			It("calls ExecuteQuery on the transaction simulator", func() {
 				_, err := handler.HandleGetQueryResult(incomingMessage, txContext)
@@ -1106,6 +1291,7 @@ var _ = Describe("Handler", func() {
 				ccname, query := fakeTxSimulator.ExecuteQueryArgsForCall(0)
 				Expect(ccname).To(Equal("cc-instance-name"))
 				Expect(query).To(Equal("query-result"))
+				Expect(txContext.QueryType("generated-query-id")).To(Equal(chaincode.QueryTypeRich))
 			})
 
 			Context("and ExecuteQuery fails", func() {
@@ -1115,7 +1301,7 @@ var _ = Describe("Handler", func() {
 
 				It("returns the error", func() {
 					_, err := handler.HandleGetQueryResult(incomingMessage, txContext)
-					Expect(err).To(MatchError("mushrooms"))
+					Expect(err).To(MatchError("txid: (channel-id): get query result failed: mushrooms"))
 				})
 			})
 		})
@@ -1147,7 +1333,7 @@ var _ = Describe("Handler", func() {
 
 				pqr := txContext.GetPendingQueryResult("generated-query-id")
 				Expect(pqr).To(Equal(&chaincode.PendingQueryResult{}))
-				iter := txContext.GetQueryIterator("generated-query-id")
+				iter := txContext.GetIterator("generated-query-id")
 				Expect(iter).To(Equal(fakeIterator))
 			})
 
@@ -1158,7 +1344,7 @@ var _ = Describe("Handler", func() {
 
 				It("returns the error", func() {
 					_, err := handler.HandleGetQueryResult(incomingMessage, txContext)
-					Expect(err).To(MatchError("pizza"))
+					Expect(err).To(MatchError("txid: (channel-id): get query result failed: pizza"))
 				})
 			})
 		})
@@ -1171,7 +1357,7 @@ var _ = Describe("Handler", func() {
 			tctx, iter, iterID := fakeQueryResponseBuilder.BuildQueryResponseArgsForCall(0)
 			Expect(tctx).To(Equal(txContext))
 			Expect(iter).To(Equal(fakeIterator))
-			Expect(iterID).To(Equal("generated-query-id"))
+			Expect(iterID).To(Equal("generated-query-id#1"))
 		})
 
 		Context("when building the query response fails", func() {
@@ -1189,7 +1375,7 @@ var _ = Describe("Handler", func() {
 
 				pqr := txContext.GetPendingQueryResult("generated-query-id")
 				Expect(pqr).To(BeNil())
-				iter := txContext.GetQueryIterator("generated-query-id")
+				iter := txContext.GetIterator("generated-query-id")
 				Expect(iter).To(BeNil())
 			})
 		})
@@ -1220,7 +1406,7 @@ var _ = Describe("Handler", func() {
 
 				pqr := txContext.GetPendingQueryResult("generated-query-id")
 				Expect(pqr).To(BeNil())
-				iter := txContext.GetQueryIterator("generated-query-id")
+				iter := txContext.GetIterator("generated-query-id")
 				Expect(iter).To(BeNil())
 			})
 		})
@@ -1257,6 +1443,35 @@ var _ = Describe("Handler", func() {
 			fakeHistoryQueryExecutor.GetHistoryForKeyReturns(fakeIterator, nil)
 		})
 
+		Context("when the registry is strict about query sources", func() {
+			var txContexts *chaincode.TransactionContexts
+
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(0, 0)
+				txContexts.StrictQuerySources = true
+			})
+
+			It("rejects the query when the context has no history query executor", func() {
+				ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+				txContext, err := txContexts.Create(ctx, "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = handler.HandleGetHistoryForKey(incomingMessage, txContext)
+				Expect(err).To(MatchError("txid: tx-id(channel-id): history query requires a history query executor: query source not available"))
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrQuerySourceUnavailable))
+			})
+
+			It("does not require a simulator for history queries", func() {
+				ctx := context.WithValue(context.Background(), chaincode.HistoryQueryExecutorKey, fakeHistoryQueryExecutor)
+				txContext, err := txContexts.Create(ctx, "channel-id", "tx-id", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = handler.HandleGetHistoryForKey(incomingMessage, txContext)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeHistoryQueryExecutor.GetHistoryForKeyCallCount()).To(Equal(1))
+			})
+		})
+
 		It("calls GetHistoryForKey on the history query executor", func() {
 			_, err := handler.HandleGetHistoryForKey(incomingMessage, txContext)
 			Expect(err).NotTo(HaveOccurred())
@@ -1273,8 +1488,9 @@ var _ = Describe("Handler", func() {
 
 			pqr := txContext.GetPendingQueryResult("generated-query-id")
 			Expect(pqr).To(Equal(&chaincode.PendingQueryResult{}))
-			iter := txContext.GetQueryIterator("generated-query-id")
+			iter := txContext.GetIterator("generated-query-id")
 			Expect(iter).To(Equal(fakeIterator))
+			Expect(txContext.QueryType("generated-query-id")).To(Equal(chaincode.QueryTypeHistory))
 		})
 
 		It("builds a query response", func() {
@@ -1285,7 +1501,7 @@ var _ = Describe("Handler", func() {
 			tctx, iter, iterID := fakeQueryResponseBuilder.BuildQueryResponseArgsForCall(0)
 			Expect(tctx).To(Equal(txContext))
 			Expect(iter).To(Equal(fakeIterator))
-			Expect(iterID).To(Equal("generated-query-id"))
+			Expect(iterID).To(Equal("generated-query-id#1"))
 		})
 
 		Context("when unmarshalling the request fails", func() {
@@ -1299,6 +1515,17 @@ var _ = Describe("Handler", func() {
 			})
 		})
 
+		Context("when the transaction context does not have a history query executor", func() {
+			BeforeEach(func() {
+				txContext.HistoryQueryExecutor = nil
+			})
+
+			It("returns an error", func() {
+				_, err := handler.HandleGetHistoryForKey(incomingMessage, txContext)
+				Expect(err).To(MatchError("history database not available"))
+			})
+		})
+
 		Context("when the history query executor fails", func() {
 			BeforeEach(func() {
 				fakeHistoryQueryExecutor.GetHistoryForKeyReturns(nil, errors.New("pepperoni"))
@@ -1306,7 +1533,7 @@ var _ = Describe("Handler", func() {
 
 			It("returns an error", func() {
 				_, err := handler.HandleGetHistoryForKey(incomingMessage, txContext)
-				Expect(err).To(MatchError("pepperoni"))
+				Expect(err).To(MatchError("txid: (channel-id): get history for key failed: pepperoni"))
 			})
 		})
 
@@ -1325,7 +1552,7 @@ var _ = Describe("Handler", func() {
 
 				pqr := txContext.GetPendingQueryResult("generated-query-id")
 				Expect(pqr).To(BeNil())
-				iter := txContext.GetQueryIterator("generated-query-id")
+				iter := txContext.GetIterator("generated-query-id")
 				Expect(iter).To(BeNil())
 			})
 		})
@@ -1345,7 +1572,7 @@ var _ = Describe("Handler", func() {
 
 				pqr := txContext.GetPendingQueryResult("generated-query-id")
 				Expect(pqr).To(BeNil())
-				iter := txContext.GetQueryIterator("generated-query-id")
+				iter := txContext.GetIterator("generated-query-id")
 				Expect(iter).To(BeNil())
 			})
 		})
@@ -1866,6 +2093,28 @@ var _ = Describe("Handler", func() {
 			})
 		})
 
+		DescribeTable("when the registry does not admit the transaction context",
+			func(admissionErr error) {
+				fakeContextRegistry.CreateReturns(nil, errors.Wrap(admissionErr, "txid: tx-id(channel-id)"))
+
+				resp, err := handler.Execute(context.Background(), cccid, incomingMessage, time.Second)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp).To(Equal(&pb.ChaincodeMessage{
+					Type:      pb.ChaincodeMessage_ERROR,
+					Payload:   []byte("txid: tx-id(channel-id): " + admissionErr.Error()),
+					Txid:      "tx-id",
+					ChannelId: "channel-id",
+				}))
+				Consistently(fakeChatStream.SendCallCount).Should(Equal(0))
+				Expect(fakeContextRegistry.DeleteCallCount()).To(Equal(0))
+			},
+			Entry("too many contexts", chaincode.ErrTooManyContexts),
+			Entry("channel quota exceeded", chaincode.ErrChannelQuotaExceeded),
+			Entry("rate limited", chaincode.ErrRateLimited),
+			Entry("registry paused", chaincode.ErrRegistryPaused),
+			Entry("registry closed", chaincode.ErrRegistryClosed),
+		)
+
 		Context("when execute times out", func() {
 			It("returns an error", func() {
 				errCh := make(chan error, 1)
@@ -2222,13 +2471,27 @@ var _ = Describe("Handler", func() {
 		})
 
 		It("should close query iterators on the transaction context", func() {
-			txContext.InitializeQueryContext("query-id", fakeIterator)
+			txContext.RegisterIterator("query-id", fakeIterator)
 			Expect(fakeIterator.CloseCallCount()).To(Equal(0))
 
 			handler.Notify(incomingMessage)
 			Eventually(fakeIterator.CloseCallCount).Should(Equal(1))
 		})
 
+		Context("when the response notifier is full", func() {
+			BeforeEach(func() {
+				responseNotifier <- &pb.ChaincodeMessage{}
+			})
+
+			It("does not block and still closes query iterators", func() {
+				txContext.RegisterIterator("query-id", fakeIterator)
+
+				handler.Notify(incomingMessage)
+				Expect(responseNotifier).To(HaveLen(1))
+				Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+			})
+		})
+
 		Context("when the transaction context cannot be found", func() {
 			BeforeEach(func() {
 				fakeContextRegistry.GetReturns(nil)
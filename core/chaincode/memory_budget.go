@@ -0,0 +1,130 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"sync/atomic"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+)
+
+// ErrMemoryBudgetExceeded is returned when the memory tracked for a
+// transaction exceeds the memory budget of its context.
+var ErrMemoryBudgetExceeded = errors.New("transaction exceeded memory budget")
+
+// memoryBudget limits the memory tracked for a transaction. The write-set size
+// is shared by a context and its children because they share a simulator.
+type memoryBudget struct {
+	limit      int64
+	writeBytes int64
+}
+
+// MemoryUsage returns the memory tracked for the transaction: the bytes of
+// query results returned to the chaincode, the bytes of query results still
+// buffered, and the size of the keys and values written through the
+// simulator. Writes are only tracked when a memory budget is configured.
+func (t *TransactionContext) MemoryUsage() int64 {
+	usage := t.BytesRead() + t.pendingBytes()
+	if t.budget != nil {
+		usage += atomic.LoadInt64(&t.budget.writeBytes)
+	}
+	return usage
+}
+
+func (t *TransactionContext) pendingBytes() int64 {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	var n int64
+	for _, pending := range t.pendingQueryResults {
+		if pending != nil {
+			n += pending.Bytes()
+		}
+	}
+	return n
+}
+
+// checkMemoryBudget returns an error when the memory tracked for the
+// transaction exceeds its budget.
+func (t *TransactionContext) checkMemoryBudget() error {
+	if t.budget == nil || t.budget.limit <= 0 {
+		return nil
+	}
+	if usage := t.MemoryUsage(); usage > t.budget.limit {
+		return errors.Wrapf(ErrMemoryBudgetExceeded, "txid: %s(%s): using %d bytes", t.TxID, t.ChainID, usage)
+	}
+	return nil
+}
+
+// budgetSimulator is a ledger.TxSimulator that accounts for the size of the
+// keys and values written through it and rejects writes that would exceed the
+// memory budget of its transaction context.
+type budgetSimulator struct {
+	ledger.TxSimulator
+	txctx *TransactionContext
+}
+
+// write accounts for n bytes of write set. When the budget is exceeded the
+// bytes are released again and an error is returned.
+func (s *budgetSimulator) write(n int) error {
+	atomic.AddInt64(&s.txctx.budget.writeBytes, int64(n))
+	if err := s.txctx.checkMemoryBudget(); err != nil {
+		atomic.AddInt64(&s.txctx.budget.writeBytes, -int64(n))
+		return err
+	}
+	return nil
+}
+
+func kvsSize(kvs map[string][]byte) int {
+	n := 0
+	for k, v := range kvs {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+func (s *budgetSimulator) SetState(namespace, key string, value []byte) error {
+	if err := s.write(len(key) + len(value)); err != nil {
+		return err
+	}
+	return s.TxSimulator.SetState(namespace, key, value)
+}
+
+func (s *budgetSimulator) DeleteState(namespace, key string) error {
+	if err := s.write(len(key)); err != nil {
+		return err
+	}
+	return s.TxSimulator.DeleteState(namespace, key)
+}
+
+func (s *budgetSimulator) SetStateMultipleKeys(namespace string, kvs map[string][]byte) error {
+	if err := s.write(kvsSize(kvs)); err != nil {
+		return err
+	}
+	return s.TxSimulator.SetStateMultipleKeys(namespace, kvs)
+}
+
+func (s *budgetSimulator) SetPrivateData(namespace, collection, key string, value []byte) error {
+	if err := s.write(len(key) + len(value)); err != nil {
+		return err
+	}
+	return s.TxSimulator.SetPrivateData(namespace, collection, key, value)
+}
+
+func (s *budgetSimulator) SetPrivateDataMultipleKeys(namespace, collection string, kvs map[string][]byte) error {
+	if err := s.write(kvsSize(kvs)); err != nil {
+		return err
+	}
+	return s.TxSimulator.SetPrivateDataMultipleKeys(namespace, collection, kvs)
+}
+
+func (s *budgetSimulator) DeletePrivateData(namespace, collection, key string) error {
+	if err := s.write(len(key)); err != nil {
+		return err
+	}
+	return s.TxSimulator.DeletePrivateData(namespace, collection, key)
+}
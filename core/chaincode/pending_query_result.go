@@ -7,18 +7,30 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode
 
 import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+
 	"github.com/golang/protobuf/proto"
 	commonledger "github.com/hyperledger/fabric/common/ledger"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/pkg/errors"
 )
 
+// PendingQueryResult buffers the query results of an iterator until they are
+// returned to the chaincode. It is safe for concurrent use.
 type PendingQueryResult struct {
+	mutex sync.Mutex
 	batch []*pb.QueryResultBytes
+	bytes int64
 }
 
 func (p *PendingQueryResult) Cut() []*pb.QueryResultBytes {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	batch := p.batch
 	p.batch = nil
+	p.bytes = 0
 	return batch
 }
 
@@ -28,10 +40,51 @@ func (p *PendingQueryResult) Add(queryResult commonledger.QueryResult) error {
 		chaincodeLogger.Errorf("failed to marshal query result: %s", err)
 		return err
 	}
+	p.mutex.Lock()
 	p.batch = append(p.batch, &pb.QueryResultBytes{ResultBytes: queryResultBytes})
+	p.bytes += int64(len(queryResultBytes))
+	p.mutex.Unlock()
 	return nil
 }
 
 func (p *PendingQueryResult) Size() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	return len(p.batch)
 }
+
+// Bytes returns the total size of the buffered query results.
+func (p *PendingQueryResult) Bytes() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.bytes
+}
+
+// batchSize returns the total size of a batch of query results.
+func batchSize(batch []*pb.QueryResultBytes) int {
+	n := 0
+	for _, result := range batch {
+		n += len(result.ResultBytes)
+	}
+	return n
+}
+
+// compressBatch gzip compresses a batch of query results into a single
+// result holding the compressed QueryResponse for the batch, as expected by
+// the shim when a QueryResponse is marked as compressed.
+func compressBatch(batch []*pb.QueryResultBytes) ([]*pb.QueryResultBytes, error) {
+	b, err := proto.Marshal(&pb.QueryResponse{Results: batch})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal query results for compression")
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, errors.Wrap(err, "failed to compress query results")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to compress query results")
+	}
+	return []*pb.QueryResultBytes{{ResultBytes: buf.Bytes()}}, nil
+}
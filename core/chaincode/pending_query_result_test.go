@@ -25,6 +25,17 @@ var _ = Describe("PendingQueryResult", func() {
 		pqr = &chaincode.PendingQueryResult{}
 	})
 
+	Describe("Bytes", func() {
+		It("returns the size of the buffered results", func() {
+			Expect(pqr.Bytes()).To(BeZero())
+
+			kv := &queryresult.KV{Key: "key"}
+			Expect(pqr.Add(kv)).To(Succeed())
+			Expect(pqr.Add(kv)).To(Succeed())
+			Expect(pqr.Bytes()).To(Equal(int64(2 * proto.Size(kv))))
+		})
+	})
+
 	Describe("Size", func() {
 		It("returns the number of results in the batch", func() {
 			Expect(pqr.Size()).To(Equal(0))
@@ -82,6 +93,12 @@ var _ = Describe("PendingQueryResult", func() {
 				Expect(pqr.Size()).To(Equal(0))
 			})
 
+			It("resets the buffered bytes to 0", func() {
+				Expect(pqr.Bytes()).To(BeNumerically(">", 0))
+				pqr.Cut()
+				Expect(pqr.Bytes()).To(BeZero())
+			})
+
 			Context("when cutting an empty batch", func() {
 				It("returns a nil batch", func() {
 					pqr.Cut()
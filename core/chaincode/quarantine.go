@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultQuarantineSize is the number of quarantined contexts retained when
+// QuarantineSize is not set.
+const defaultQuarantineSize = 16
+
+// QuarantinedContext holds the metadata of a transaction context that was
+// quarantined for post-mortem inspection.
+type QuarantinedContext struct {
+	TransactionContextInfo
+	// Reason is the error that caused the context to be quarantined
+	Reason error
+	// Quarantined is the time at which the context was quarantined
+	Quarantined time.Time
+}
+
+// Quarantine removes the transaction context associated with the specified
+// chain and transaction ID from the registry for post-mortem inspection of a
+// failed transaction. The metadata of the context is captured along with
+// reason, its query iterators are closed, and it is removed as if deleted.
+// The registry retains the most recent QuarantineSize quarantined contexts;
+// older ones are discarded. An error is returned when the context does not
+// exist.
+func (c *TransactionContexts) Quarantine(chainID, txID string, reason error) error {
+	ctxID := contextID(chainID, txID)
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	txctx := shard.contexts[ctxID]
+	if txctx == nil {
+		shard.mutex.Unlock()
+		return errors.Errorf("txid: %s(%s) does not exist", txID, chainID)
+	}
+
+	quarantined := QuarantinedContext{
+		TransactionContextInfo: txctx.info(),
+		Reason:                 reason,
+		Quarantined:            c.now(),
+	}
+	chaincodeLogger.Warningf("quarantining transaction context txid: %s(%s): %s", txID, chainID, reason)
+	var removed removals
+	removed.add(whenReleased(txctx, txctx.CloseQueryIterators))
+	removed.add(c.remove(shard, ctxID, txctx, EvictQuarantined))
+	shard.mutex.Unlock()
+	removed.run()
+
+	size := c.QuarantineSize
+	if size < 1 {
+		size = defaultQuarantineSize
+	}
+	c.quarantineMutex.Lock()
+	defer c.quarantineMutex.Unlock()
+	c.quarantine = append(c.quarantine, quarantined)
+	if len(c.quarantine) > size {
+		c.quarantine = append([]QuarantinedContext(nil), c.quarantine[len(c.quarantine)-size:]...)
+	}
+	return nil
+}
+
+// QuarantinedContexts returns the quarantined contexts retained by the
+// registry, from the oldest to the most recently quarantined.
+func (c *TransactionContexts) QuarantinedContexts() []QuarantinedContext {
+	c.quarantineMutex.Lock()
+	defer c.quarantineMutex.Unlock()
+	quarantined := make([]QuarantinedContext, len(c.quarantine))
+	copy(quarantined, c.quarantine)
+	return quarantined
+}
@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/chaincode/mock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Quarantine", func() {
+	var (
+		txContexts *chaincode.TransactionContexts
+		now        time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1000, 0)
+		txContexts = chaincode.NewTransactionContexts(0, 0)
+		chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+	})
+
+	It("captures the metadata of the context with the reason", func() {
+		txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		txContext.RegisterIterator("query-id", &mock.ResultsIterator{})
+		txContext.SetLabel("workload", "batch")
+
+		now = now.Add(time.Second)
+		reason := errors.New("unusual-failure")
+		Expect(txContexts.Quarantine("chainID", "transactionID", reason)).To(Succeed())
+
+		Expect(txContexts.QuarantinedContexts()).To(Equal([]chaincode.QuarantinedContext{{
+			TransactionContextInfo: chaincode.TransactionContextInfo{
+				ChainID:             "chainID",
+				TxID:                "transactionID",
+				Created:             time.Unix(1000, 0),
+				QueryIterators:      1,
+				PendingQueryResults: 1,
+				Labels:              map[string]string{"workload": "batch"},
+			},
+			Reason:      reason,
+			Quarantined: time.Unix(1001, 0),
+		}}))
+	})
+
+	It("closes the iterators and removes the context", func() {
+		var evicted []chaincode.EvictReason
+		txContexts.OnEvict(func(chainID, txID string, reason chaincode.EvictReason) {
+			evicted = append(evicted, reason)
+		})
+		txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		iter1, iter2 := &mock.ResultsIterator{}, &mock.ResultsIterator{}
+		txContext.RegisterIterator("query-id1", iter1)
+		txContext.RegisterIterator("query-id2", iter2)
+
+		Expect(txContexts.Quarantine("chainID", "transactionID", errors.New("boom"))).To(Succeed())
+
+		Expect(iter1.CloseCallCount()).To(Equal(1))
+		Expect(iter2.CloseCallCount()).To(Equal(1))
+		Expect(txContexts.Get("chainID", "transactionID")).To(BeNil())
+		Expect(txContexts.Count()).To(Equal(0))
+		Expect(evicted).To(Equal([]chaincode.EvictReason{chaincode.EvictQuarantined}))
+		Expect(txContext.Context().Err()).To(Equal(context.Canceled))
+	})
+
+	It("evicts the oldest quarantined contexts when the cap is reached", func() {
+		txContexts.QuarantineSize = 3
+		for i := 1; i <= 5; i++ {
+			txID := fmt.Sprintf("transactionID%d", i)
+			_, err := txContexts.Create(context.Background(), "chainID", txID, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContexts.Quarantine("chainID", txID, errors.New("boom"))).To(Succeed())
+		}
+
+		var txIDs []string
+		for _, q := range txContexts.QuarantinedContexts() {
+			txIDs = append(txIDs, q.TxID)
+		}
+		Expect(txIDs).To(Equal([]string{"transactionID3", "transactionID4", "transactionID5"}))
+	})
+
+	It("retains a default number of contexts when no size is set", func() {
+		for i := 0; i < 20; i++ {
+			txID := fmt.Sprintf("transactionID%d", i)
+			_, err := txContexts.Create(context.Background(), "chainID", txID, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContexts.Quarantine("chainID", txID, errors.New("boom"))).To(Succeed())
+		}
+
+		quarantined := txContexts.QuarantinedContexts()
+		Expect(quarantined).To(HaveLen(16))
+		Expect(quarantined[0].TxID).To(Equal("transactionID4"))
+	})
+
+	It("returns a copy of the quarantined contexts", func() {
+		_, err := txContexts.Create(context.Background(), "chainID", "transactionID1", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(txContexts.Quarantine("chainID", "transactionID1", errors.New("boom"))).To(Succeed())
+		quarantined := txContexts.QuarantinedContexts()
+
+		_, err = txContexts.Create(context.Background(), "chainID", "transactionID2", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(txContexts.Quarantine("chainID", "transactionID2", errors.New("boom"))).To(Succeed())
+		Expect(quarantined).To(HaveLen(1))
+	})
+
+	It("returns an error when the context does not exist", func() {
+		err := txContexts.Quarantine("chainID", "missing-transactionID", errors.New("boom"))
+		Expect(err).To(MatchError("txid: missing-transactionID(chainID) does not exist"))
+		Expect(txContexts.QuarantinedContexts()).To(BeEmpty())
+	})
+})
@@ -11,16 +11,56 @@ import (
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
+// BookmarkedIterator is implemented by result iterators that can report the
+// position from which a paginated query can be resumed.
+type BookmarkedIterator interface {
+	commonledger.ResultsIterator
+	GetBookmark() string
+}
+
 type QueryResponseGenerator struct {
 	MaxResultLimit int
+	// ReturnPartialResults causes the results buffered before an iterator
+	// fails to be returned with HasMore set instead of being discarded. The
+	// iterator error is then returned by the next request for the query so
+	// that the client can resume from the query's bookmark.
+	ReturnPartialResults bool
+	// CompressResults enables gzip compression of result batches of at
+	// least CompressionThreshold bytes. Compressed responses are marked so
+	// that the shim decompresses them.
+	CompressResults      bool
+	CompressionThreshold int
 }
 
 // NewQueryResponse takes an iterator and fetch state to construct QueryResponse
 func (q *QueryResponseGenerator) BuildQueryResponse(txContext *TransactionContext, iter commonledger.ResultsIterator, iterID string) (*pb.QueryResponse, error) {
+	span := txContext.StartChildSpan("BuildQueryResponse")
+	defer span.Finish()
+
+	txContext.touchIterator(iterID)
+	if err := txContext.takeIteratorError(iterID); err != nil {
+		txContext.CleanupQueryContext(iterID)
+		return nil, err
+	}
+
 	pendingQueryResults := txContext.GetPendingQueryResult(iterID)
 	for {
 		queryResult, err := iter.Next()
 		switch {
+		case err != nil && q.ReturnPartialResults && pendingQueryResults.Size() > 0:
+			chaincodeLogger.Errorf("Failed to get query result from iterator, returning partial results")
+			batch := pendingQueryResults.Cut()
+			txContext.queryBatch(len(batch))
+			if err := txContext.addBytesRead(batch); err != nil {
+				txContext.CleanupQueryContext(iterID)
+				return nil, err
+			}
+			if bi, ok := iter.(BookmarkedIterator); ok {
+				txContext.SetBookmark(iterID, bi.GetBookmark())
+			}
+			txContext.deferIteratorError(iterID, err)
+			return q.response(txContext, batch, true, iterID)
+
 		case err != nil:
 			chaincodeLogger.Errorf("Failed to get query result from iterator")
 			txContext.CleanupQueryContext(iterID)
@@ -29,23 +69,58 @@ func (q *QueryResponseGenerator) BuildQueryResponse(txContext *TransactionContex
 		case queryResult == nil:
 			// nil response from iterator indicates end of query results
 			batch := pendingQueryResults.Cut()
+			txContext.queryBatch(len(batch))
 			txContext.CleanupQueryContext(iterID)
-			return &pb.QueryResponse{Results: batch, HasMore: false, Id: iterID}, nil
+			if err := txContext.addBytesRead(batch); err != nil {
+				return nil, err
+			}
+			return q.response(txContext, batch, false, iterID)
 
 		case pendingQueryResults.Size() == q.MaxResultLimit:
 			// max number of results queued up, cut batch, then add current result to pending batch
 			batch := pendingQueryResults.Cut()
+			txContext.queryBatch(len(batch))
+			if err := txContext.addBytesRead(batch); err != nil {
+				txContext.CleanupQueryContext(iterID)
+				return nil, err
+			}
 			if err := pendingQueryResults.Add(queryResult); err != nil {
 				txContext.CleanupQueryContext(iterID)
 				return nil, err
 			}
-			return &pb.QueryResponse{Results: batch, HasMore: true, Id: iterID}, nil
+			if err := txContext.checkMemoryBudget(); err != nil {
+				txContext.CleanupQueryContext(iterID)
+				return nil, err
+			}
+			if bi, ok := iter.(BookmarkedIterator); ok {
+				txContext.SetBookmark(iterID, bi.GetBookmark())
+			}
+			return q.response(txContext, batch, true, iterID)
 
 		default:
 			if err := pendingQueryResults.Add(queryResult); err != nil {
 				txContext.CleanupQueryContext(iterID)
 				return nil, err
 			}
+			if err := txContext.checkMemoryBudget(); err != nil {
+				txContext.CleanupQueryContext(iterID)
+				return nil, err
+			}
 		}
 	}
 }
+
+// response builds the query response for a batch of results, compressing the
+// batch when it is large enough. The query context is cleaned up when the
+// batch cannot be compressed.
+func (q *QueryResponseGenerator) response(txContext *TransactionContext, batch []*pb.QueryResultBytes, hasMore bool, iterID string) (*pb.QueryResponse, error) {
+	if !q.CompressResults || len(batch) == 0 || batchSize(batch) < q.CompressionThreshold {
+		return &pb.QueryResponse{Results: batch, HasMore: hasMore, Id: iterID}, nil
+	}
+	compressed, err := compressBatch(batch)
+	if err != nil {
+		txContext.CleanupQueryContext(iterID)
+		return nil, err
+	}
+	return &pb.QueryResponse{Results: compressed, HasMore: hasMore, Id: iterID, Compressed: true}, nil
+}
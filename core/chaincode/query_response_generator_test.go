@@ -7,15 +7,22 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io/ioutil"
 	"math"
+	"sync"
 	"testing"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode"
 	"github.com/hyperledger/fabric/core/chaincode/mock"
 	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 )
 
 func TestBuildQueryResponse(t *testing.T) {
@@ -56,7 +63,7 @@ func TestBuildQueryResponse(t *testing.T) {
 			}
 
 			resultsIterator := &mock.ResultsIterator{}
-			transactionContext.InitializeQueryContext("query-id", resultsIterator)
+			transactionContext.RegisterIterator("query-id", resultsIterator)
 			for i := 0; i < tc.expectedResultCount; i++ {
 				resultsIterator.NextReturnsOnCall(i, queryResult, nil)
 			}
@@ -88,6 +95,216 @@ func TestBuildQueryResponse(t *testing.T) {
 	}
 }
 
+type bookmarkedIterator struct {
+	*mock.ResultsIterator
+}
+
+func (b *bookmarkedIterator) GetBookmark() string {
+	return fmt.Sprintf("bookmark-%d", b.NextCallCount())
+}
+
+func TestBuildQueryResponseBookmarks(t *testing.T) {
+	queryResult := &queryresult.KV{Key: "key-name"}
+
+	transactionContext := &chaincode.TransactionContext{TXSimulator: &mock.TxSimulator{}}
+	resultsIterator := &bookmarkedIterator{ResultsIterator: &mock.ResultsIterator{}}
+	resultsIterator.NextReturns(queryResult, nil)
+	resultsIterator.NextReturnsOnCall(7, nil, nil)
+	transactionContext.RegisterIterator("query-id", resultsIterator)
+	responseGenerator := &chaincode.QueryResponseGenerator{MaxResultLimit: 3}
+
+	resp, err := responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.NoError(t, err)
+	assert.True(t, resp.GetHasMore())
+	assert.Len(t, resp.GetResults(), 3)
+	assert.Equal(t, "bookmark-4", transactionContext.GetBookmark("query-id"))
+
+	resp, err = responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.NoError(t, err)
+	assert.True(t, resp.GetHasMore())
+	assert.Len(t, resp.GetResults(), 3)
+	assert.Equal(t, "bookmark-7", transactionContext.GetBookmark("query-id"))
+
+	resp, err = responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.NoError(t, err)
+	assert.False(t, resp.GetHasMore())
+	assert.Len(t, resp.GetResults(), 1)
+	assert.Empty(t, transactionContext.GetBookmark("query-id"))
+	assert.Equal(t, 1, resultsIterator.CloseCallCount())
+}
+
+func TestBuildQueryResponseBytesRead(t *testing.T) {
+	queryResult := &queryresult.KV{Key: "key-name"}
+	resultSize := int64(proto.Size(queryResult))
+
+	transactionContext := &chaincode.TransactionContext{TXSimulator: &mock.TxSimulator{}}
+	responseGenerator := &chaincode.QueryResponseGenerator{MaxResultLimit: 3}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		resultsIterator := &mock.ResultsIterator{}
+		resultsIterator.NextReturns(queryResult, nil)
+		resultsIterator.NextReturnsOnCall(5, nil, nil)
+		iterID := fmt.Sprintf("query-id-%d", i)
+		transactionContext.RegisterIterator(iterID, resultsIterator)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				resp, err := responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, iterID)
+				assert.NoError(t, err)
+				if err != nil || !resp.GetHasMore() {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10*5*resultSize, transactionContext.BytesRead())
+}
+
+func TestBuildQueryResponseMaxBytesRead(t *testing.T) {
+	queryResult := &queryresult.KV{Key: "key-name"}
+	resultSize := int64(proto.Size(queryResult))
+
+	txContexts := chaincode.NewTransactionContexts(0, 0)
+	txContexts.MaxBytesRead = 4 * resultSize
+	transactionContext, err := txContexts.Create(context.Background(), "chain-id", "tx-id", nil, nil)
+	assert.NoError(t, err)
+
+	resultsIterator := &mock.ResultsIterator{}
+	resultsIterator.NextReturns(queryResult, nil)
+	transactionContext.RegisterIterator("query-id", resultsIterator)
+	responseGenerator := &chaincode.QueryResponseGenerator{MaxResultLimit: 3}
+
+	resp, err := responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetResults(), 3)
+	assert.Equal(t, 3*resultSize, transactionContext.BytesRead())
+
+	resp, err = responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.EqualError(t, err, fmt.Sprintf("txid: tx-id(chain-id): read %d bytes: transaction exceeded maximum bytes read", 6*resultSize))
+	assert.Equal(t, chaincode.ErrMaxBytesReadExceeded, errors.Cause(err))
+	assert.Nil(t, resp)
+	assert.Equal(t, 1, resultsIterator.CloseCallCount())
+	assert.Nil(t, transactionContext.GetIterator("query-id"))
+}
+
+func TestBuildQueryResponseMemoryBudget(t *testing.T) {
+	queryResult := &queryresult.KV{Key: "key-name"}
+	resultSize := int64(proto.Size(queryResult))
+
+	txSimulator := &mock.TxSimulator{}
+	txContexts := chaincode.NewTransactionContexts(0, 0)
+	txContexts.MemoryBudget = 10 * resultSize
+	ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, txSimulator)
+	transactionContext, err := txContexts.Create(ctx, "chain-id", "tx-id", nil, nil)
+	assert.NoError(t, err)
+
+	value := make([]byte, 5*resultSize-int64(len("key")))
+	err = transactionContext.TXSimulator.SetState("namespace", "key", value)
+	assert.NoError(t, err)
+	assert.Equal(t, 5*resultSize, transactionContext.MemoryUsage())
+
+	resultsIterator := &mock.ResultsIterator{}
+	resultsIterator.NextReturns(queryResult, nil)
+	transactionContext.RegisterIterator("query-id", resultsIterator)
+	responseGenerator := &chaincode.QueryResponseGenerator{MaxResultLimit: 3}
+
+	resp, err := responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetResults(), 3)
+	assert.Equal(t, 9*resultSize, transactionContext.MemoryUsage())
+
+	err = transactionContext.TXSimulator.SetState("namespace", "key", value)
+	assert.EqualError(t, err, fmt.Sprintf("txid: tx-id(chain-id): using %d bytes: transaction exceeded memory budget", 14*resultSize))
+	assert.Equal(t, chaincode.ErrMemoryBudgetExceeded, errors.Cause(err))
+	assert.Equal(t, 1, txSimulator.SetStateCallCount())
+	assert.Equal(t, 9*resultSize, transactionContext.MemoryUsage())
+
+	resp, err = responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.EqualError(t, err, fmt.Sprintf("txid: tx-id(chain-id): using %d bytes: transaction exceeded memory budget", 11*resultSize))
+	assert.Equal(t, chaincode.ErrMemoryBudgetExceeded, errors.Cause(err))
+	assert.Nil(t, resp)
+	assert.Equal(t, 1, resultsIterator.CloseCallCount())
+	assert.Nil(t, transactionContext.GetIterator("query-id"))
+}
+
+func TestBuildQueryResponseNoMemoryBudget(t *testing.T) {
+	txSimulator := &mock.TxSimulator{}
+	txContexts := chaincode.NewTransactionContexts(0, 0)
+	ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, txSimulator)
+	transactionContext, err := txContexts.Create(ctx, "chain-id", "tx-id", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, txSimulator, transactionContext.TXSimulator)
+
+	err = transactionContext.TXSimulator.SetState("namespace", "key", make([]byte, 1024))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), transactionContext.MemoryUsage())
+}
+
+func TestBuildQueryResponseCompression(t *testing.T) {
+	queryResult := &queryresult.KV{Key: "key-name", Value: bytes.Repeat([]byte("v"), 100)}
+	resultSize := proto.Size(queryResult)
+
+	tests := []struct {
+		name           string
+		compress       bool
+		threshold      int
+		results        int
+		wantCompressed bool
+	}{
+		{"small batch", true, 5 * resultSize, 4, false},
+		{"large batch", true, 5 * resultSize, 5, true},
+		{"compression disabled", false, 5 * resultSize, 5, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			transactionContext := &chaincode.TransactionContext{TXSimulator: &mock.TxSimulator{}}
+			resultsIterator := &mock.ResultsIterator{}
+			for i := 0; i < tc.results; i++ {
+				resultsIterator.NextReturnsOnCall(i, queryResult, nil)
+			}
+			transactionContext.RegisterIterator("query-id", resultsIterator)
+			responseGenerator := &chaincode.QueryResponseGenerator{
+				MaxResultLimit:       100,
+				CompressResults:      tc.compress,
+				CompressionThreshold: tc.threshold,
+			}
+
+			resp, err := responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantCompressed, resp.Compressed)
+			assert.Equal(t, "query-id", resp.Id)
+			assert.False(t, resp.HasMore)
+
+			results := resp.Results
+			if resp.Compressed {
+				assert.Len(t, results, 1)
+				assert.True(t, len(results[0].ResultBytes) < tc.results*resultSize)
+				r, err := gzip.NewReader(bytes.NewReader(results[0].ResultBytes))
+				assert.NoError(t, err)
+				b, err := ioutil.ReadAll(r)
+				assert.NoError(t, err)
+				batch := &pb.QueryResponse{}
+				assert.NoError(t, proto.Unmarshal(b, batch))
+				results = batch.Results
+			}
+
+			assert.Len(t, results, tc.results)
+			for _, result := range results {
+				kv := &queryresult.KV{}
+				assert.NoError(t, proto.Unmarshal(result.ResultBytes, kv))
+				assert.True(t, proto.Equal(queryResult, kv))
+			}
+			assert.Equal(t, int64(tc.results*resultSize), transactionContext.BytesRead())
+		})
+	}
+}
+
 func TestBuildQueryResponseErrors(t *testing.T) {
 	validResult := &queryresult.KV{Key: "key-name"}
 	invalidResult := brokenProto{}
@@ -115,7 +332,7 @@ func TestBuildQueryResponseErrors(t *testing.T) {
 				resultsIterator.NextReturnsOnCall(tc.brokenResultOnNextCall, invalidResult, nil)
 			}
 
-			transactionContext.InitializeQueryContext("query-id", resultsIterator)
+			transactionContext.RegisterIterator("query-id", resultsIterator)
 			responseGenerator := &chaincode.QueryResponseGenerator{
 				MaxResultLimit: 3,
 			}
@@ -131,3 +348,41 @@ func TestBuildQueryResponseErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildQueryResponsePartialResults(t *testing.T) {
+	queryResult := &queryresult.KV{Key: "key-name"}
+
+	transactionContext := &chaincode.TransactionContext{TXSimulator: &mock.TxSimulator{}}
+	resultsIterator := &bookmarkedIterator{ResultsIterator: &mock.ResultsIterator{}}
+	resultsIterator.NextReturns(queryResult, nil)
+	resultsIterator.NextReturnsOnCall(2, nil, errors.New("next-failed"))
+	transactionContext.RegisterIterator("query-id", resultsIterator)
+	responseGenerator := &chaincode.QueryResponseGenerator{MaxResultLimit: 3, ReturnPartialResults: true}
+
+	resp, err := responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.NoError(t, err)
+	assert.True(t, resp.GetHasMore())
+	assert.Len(t, resp.GetResults(), 2)
+	assert.Equal(t, "bookmark-3", transactionContext.GetBookmark("query-id"))
+	assert.Equal(t, 0, resultsIterator.CloseCallCount())
+
+	resp, err = responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.EqualError(t, err, "next-failed")
+	assert.Nil(t, resp)
+	assert.Equal(t, 3, resultsIterator.NextCallCount())
+	assert.Equal(t, 1, resultsIterator.CloseCallCount())
+	assert.Nil(t, transactionContext.GetIterator("query-id"))
+}
+
+func TestBuildQueryResponsePartialResultsNothingBuffered(t *testing.T) {
+	transactionContext := &chaincode.TransactionContext{TXSimulator: &mock.TxSimulator{}}
+	resultsIterator := &mock.ResultsIterator{}
+	resultsIterator.NextReturns(nil, errors.New("next-failed"))
+	transactionContext.RegisterIterator("query-id", resultsIterator)
+	responseGenerator := &chaincode.QueryResponseGenerator{MaxResultLimit: 3, ReturnPartialResults: true}
+
+	resp, err := responseGenerator.BuildQueryResponse(transactionContext, resultsIterator, "query-id")
+	assert.EqualError(t, err, "next-failed")
+	assert.Nil(t, resp)
+	assert.Equal(t, 1, resultsIterator.CloseCallCount())
+}
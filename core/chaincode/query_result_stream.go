@@ -0,0 +1,73 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"github.com/golang/protobuf/proto"
+	commonledger "github.com/hyperledger/fabric/common/ledger"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"golang.org/x/net/context"
+)
+
+// QueryResultStream delivers the results of a query iterator through a
+// bounded channel. The iterator is advanced only when there is room in the
+// channel so a slow consumer applies backpressure to the ledger instead of
+// causing results to be buffered in memory.
+type QueryResultStream struct {
+	results chan *pb.QueryResultBytes
+	err     error
+}
+
+// Results returns the channel on which query results are delivered. The
+// channel is closed when the iterator is exhausted, when an error occurs, or
+// when the transaction context is removed from its registry.
+func (s *QueryResultStream) Results() <-chan *pb.QueryResultBytes {
+	return s.results
+}
+
+// Err returns the error that terminated the stream or nil if the iterator was
+// exhausted. It must only be called after the results channel is closed.
+func (s *QueryResultStream) Err() error {
+	return s.err
+}
+
+func (s *QueryResultStream) produce(ctx context.Context, txContext *TransactionContext, iter commonledger.ResultsIterator, queryID string) {
+	defer close(s.results)
+	defer txContext.CleanupQueryContext(queryID)
+
+	for {
+		txContext.touchIterator(queryID)
+		queryResult, err := iter.Next()
+		if err != nil {
+			chaincodeLogger.Errorf("Failed to get query result from iterator")
+			s.err = err
+			return
+		}
+		if queryResult == nil {
+			return
+		}
+
+		queryResultBytes, err := proto.Marshal(queryResult.(proto.Message))
+		if err != nil {
+			chaincodeLogger.Errorf("failed to marshal query result: %s", err)
+			s.err = err
+			return
+		}
+		result := &pb.QueryResultBytes{ResultBytes: queryResultBytes}
+		if err := txContext.addBytesRead([]*pb.QueryResultBytes{result}); err != nil {
+			s.err = err
+			return
+		}
+
+		select {
+		case s.results <- result:
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode_test
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	commonledger "github.com/hyperledger/fabric/common/ledger"
+	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/chaincode/mock"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("QueryResultStream", func() {
+	var (
+		resultsIterator *mock.ResultsIterator
+		txContext       *chaincode.TransactionContext
+		cancel          context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+
+		var err error
+		txContext, err = chaincode.NewTransactionContexts(0, 0).Create(ctx, "channel-id", "tx-id", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		resultsIterator = &mock.ResultsIterator{}
+		err = txContext.RegisterIterator("query-id", resultsIterator)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("delivers the results in order and cleans up the iterator", func() {
+		for i := 0; i < 3; i++ {
+			resultsIterator.NextReturnsOnCall(i, &queryresult.KV{Key: fmt.Sprintf("key-%d", i)}, nil)
+		}
+
+		stream, err := txContext.StreamQueryResults("query-id", 1)
+		Expect(err).NotTo(HaveOccurred())
+
+		var keys []string
+		for result := range stream.Results() {
+			var kv queryresult.KV
+			err := proto.Unmarshal(result.ResultBytes, &kv)
+			Expect(err).NotTo(HaveOccurred())
+			keys = append(keys, kv.Key)
+		}
+		Expect(keys).To(Equal([]string{"key-0", "key-1", "key-2"}))
+		Expect(stream.Err()).NotTo(HaveOccurred())
+		Expect(resultsIterator.CloseCallCount()).To(Equal(1))
+		Expect(txContext.GetIterator("query-id")).To(BeNil())
+	})
+
+	Context("when the consumer is slow", func() {
+		BeforeEach(func() {
+			resultsIterator.NextStub = func() (commonledger.QueryResult, error) {
+				return &queryresult.KV{Key: "key"}, nil
+			}
+		})
+
+		It("blocks the producer at the high water mark", func() {
+			stream, err := txContext.StreamQueryResults("query-id", 2)
+			Expect(err).NotTo(HaveOccurred())
+
+			// two buffered results and one waiting to be sent
+			Eventually(resultsIterator.NextCallCount).Should(Equal(3))
+			Consistently(resultsIterator.NextCallCount).Should(Equal(3))
+			Expect(stream.Results()).To(HaveLen(2))
+
+			Eventually(stream.Results()).Should(Receive())
+			Eventually(resultsIterator.NextCallCount).Should(Equal(4))
+			Consistently(resultsIterator.NextCallCount).Should(Equal(4))
+		})
+
+		It("stops when the transaction context is cancelled", func() {
+			stream, err := txContext.StreamQueryResults("query-id", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(resultsIterator.NextCallCount).Should(Equal(1))
+
+			cancel()
+			Eventually(stream.Results()).Should(BeClosed())
+			Expect(stream.Err()).To(Equal(context.Canceled))
+			Expect(resultsIterator.CloseCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when the iterator fails", func() {
+		BeforeEach(func() {
+			resultsIterator.NextReturns(nil, errors.New("next-failed"))
+		})
+
+		It("closes the stream with the error", func() {
+			stream, err := txContext.StreamQueryResults("query-id", 1)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(stream.Results()).Should(BeClosed())
+			Expect(stream.Err()).To(MatchError("next-failed"))
+		})
+	})
+
+	Context("when the query iterator does not exist", func() {
+		It("returns an error", func() {
+			_, err := txContext.StreamQueryResults("missing-query-id", 1)
+			Expect(err).To(MatchError("query iterator missing-query-id does not exist"))
+		})
+	})
+
+	Context("when the high water mark is negative", func() {
+		It("returns an error", func() {
+			_, err := txContext.StreamQueryResults("query-id", -1)
+			Expect(err).To(MatchError("invalid high water mark -1"))
+		})
+	})
+})
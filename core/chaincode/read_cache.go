@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric/core/ledger"
+)
+
+// cachingSimulator is a ledger.TxSimulator that serves repeated reads of the
+// same key from memory. A cached value is discarded when the key is written
+// or deleted through the simulator so that reads following a write are
+// always served by the underlying simulator. Range scans, rich queries, and
+// multiple key reads are not cached.
+type cachingSimulator struct {
+	ledger.TxSimulator
+
+	mutex  sync.Mutex
+	values map[string][]byte
+}
+
+func newCachingSimulator(txsim ledger.TxSimulator) *cachingSimulator {
+	return &cachingSimulator{TxSimulator: txsim, values: map[string][]byte{}}
+}
+
+func cacheKey(namespace, collection, key string) string {
+	return namespace + "\x00" + collection + "\x00" + key
+}
+
+func (s *cachingSimulator) read(k string, get func() ([]byte, error)) ([]byte, error) {
+	s.mutex.Lock()
+	value, ok := s.values[k]
+	s.mutex.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	value, err := get()
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	s.values[k] = value
+	s.mutex.Unlock()
+	return value, nil
+}
+
+func (s *cachingSimulator) invalidate(keys ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, k := range keys {
+		delete(s.values, k)
+	}
+}
+
+func (s *cachingSimulator) GetState(namespace, key string) ([]byte, error) {
+	return s.read(cacheKey(namespace, "", key), func() ([]byte, error) {
+		return s.TxSimulator.GetState(namespace, key)
+	})
+}
+
+func (s *cachingSimulator) GetPrivateData(namespace, collection, key string) ([]byte, error) {
+	return s.read(cacheKey(namespace, collection, key), func() ([]byte, error) {
+		return s.TxSimulator.GetPrivateData(namespace, collection, key)
+	})
+}
+
+func (s *cachingSimulator) SetState(namespace, key string, value []byte) error {
+	s.invalidate(cacheKey(namespace, "", key))
+	return s.TxSimulator.SetState(namespace, key, value)
+}
+
+func (s *cachingSimulator) DeleteState(namespace, key string) error {
+	s.invalidate(cacheKey(namespace, "", key))
+	return s.TxSimulator.DeleteState(namespace, key)
+}
+
+func (s *cachingSimulator) SetStateMultipleKeys(namespace string, kvs map[string][]byte) error {
+	for key := range kvs {
+		s.invalidate(cacheKey(namespace, "", key))
+	}
+	return s.TxSimulator.SetStateMultipleKeys(namespace, kvs)
+}
+
+func (s *cachingSimulator) SetPrivateData(namespace, collection, key string, value []byte) error {
+	s.invalidate(cacheKey(namespace, collection, key))
+	return s.TxSimulator.SetPrivateData(namespace, collection, key, value)
+}
+
+func (s *cachingSimulator) SetPrivateDataMultipleKeys(namespace, collection string, kvs map[string][]byte) error {
+	for key := range kvs {
+		s.invalidate(cacheKey(namespace, collection, key))
+	}
+	return s.TxSimulator.SetPrivateDataMultipleKeys(namespace, collection, kvs)
+}
+
+func (s *cachingSimulator) DeletePrivateData(namespace, collection, key string) error {
+	s.invalidate(cacheKey(namespace, collection, key))
+	return s.TxSimulator.DeletePrivateData(namespace, collection, key)
+}
+
+// ExecuteUpdate may modify any key, so the whole cache is discarded.
+func (s *cachingSimulator) ExecuteUpdate(query string) error {
+	s.mutex.Lock()
+	s.values = map[string][]byte{}
+	s.mutex.Unlock()
+	return s.TxSimulator.ExecuteUpdate(query)
+}
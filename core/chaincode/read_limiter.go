@@ -0,0 +1,53 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	commonledger "github.com/hyperledger/fabric/common/ledger"
+	"golang.org/x/net/context"
+)
+
+// readLimitedIterator is a results iterator that holds a slot of a semaphore
+// shared by the registry while it advances so that the number of concurrent
+// ledger reads across all transactions is bounded.
+type readLimitedIterator struct {
+	commonledger.ResultsIterator
+	slots chan struct{}
+	ctx   context.Context
+}
+
+// Next waits for a free read slot and advances the iterator. An error is
+// returned when the transaction is done before a slot is free.
+func (r *readLimitedIterator) Next() (commonledger.QueryResult, error) {
+	select {
+	case r.slots <- struct{}{}:
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	}
+	defer func() { <-r.slots }()
+	return r.ResultsIterator.Next()
+}
+
+// bookmarkedReadLimitedIterator preserves the bookmark of a limited
+// BookmarkedIterator.
+type bookmarkedReadLimitedIterator struct {
+	*readLimitedIterator
+	bookmarked BookmarkedIterator
+}
+
+func (b *bookmarkedReadLimitedIterator) GetBookmark() string {
+	return b.bookmarked.GetBookmark()
+}
+
+// limitReads wraps iter so that its reads are bounded by slots.
+func limitReads(iter commonledger.ResultsIterator, slots chan struct{}, ctx context.Context) commonledger.ResultsIterator {
+	limited := &readLimitedIterator{ResultsIterator: iter, slots: slots, ctx: ctx}
+	if bi, ok := iter.(BookmarkedIterator); ok {
+		return &bookmarkedReadLimitedIterator{readLimitedIterator: limited, bookmarked: bi}
+	}
+	return limited
+}
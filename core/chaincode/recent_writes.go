@@ -0,0 +1,66 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "time"
+
+// RecordCommittedWrites adds the keys written by a transaction committed on
+// the specified chain to the recent writes index of the registry. Keys are
+// opaque to the registry; callers should qualify them, for example with the
+// chaincode namespace, consistently with the keys passed to WouldConflict.
+// Keys older than RecentWritesWindow are discarded. Nothing is recorded when
+// RecentWritesWindow is zero.
+func (c *TransactionContexts) RecordCommittedWrites(chainID string, keys []string) {
+	if c.RecentWritesWindow <= 0 || len(keys) == 0 {
+		return
+	}
+
+	now := c.now()
+	cutoff := now.Add(-c.RecentWritesWindow)
+
+	c.recentWritesMutex.Lock()
+	defer c.recentWritesMutex.Unlock()
+	if c.recentWrites == nil {
+		c.recentWrites = map[string]map[string]time.Time{}
+	}
+	writes := c.recentWrites[chainID]
+	if writes == nil {
+		writes = map[string]time.Time{}
+		c.recentWrites[chainID] = writes
+	}
+	for key, written := range writes {
+		if written.Before(cutoff) {
+			delete(writes, key)
+		}
+	}
+	for _, key := range keys {
+		writes[key] = now
+	}
+}
+
+// WouldConflict reports whether any of the read keys was written by a
+// transaction committed on the specified chain within the last
+// RecentWritesWindow. A true result means that a transaction that read the
+// keys is likely to fail MVCC validation at commit. The result is advisory:
+// the index only knows of the writes reported to RecordCommittedWrites and
+// does not compare versions.
+func (c *TransactionContexts) WouldConflict(chainID string, readKeys []string) bool {
+	if c.RecentWritesWindow <= 0 {
+		return false
+	}
+	cutoff := c.now().Add(-c.RecentWritesWindow)
+
+	c.recentWritesMutex.Lock()
+	defer c.recentWritesMutex.Unlock()
+	writes := c.recentWrites[chainID]
+	for _, key := range readKeys {
+		if written, ok := writes[key]; ok && !written.Before(cutoff) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode_test
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RecentWrites", func() {
+	var (
+		txContexts *chaincode.TransactionContexts
+		now        time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1000, 0)
+		txContexts = chaincode.NewTransactionContexts(0, 0)
+		txContexts.RecentWritesWindow = time.Minute
+		chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+	})
+
+	It("reports a likely conflict when a read key was recently written", func() {
+		txContexts.RecordCommittedWrites("chainID", []string{"ns/key1", "ns/key2"})
+
+		Expect(txContexts.WouldConflict("chainID", []string{"ns/key2"})).To(BeTrue())
+		Expect(txContexts.WouldConflict("chainID", []string{"ns/key3", "ns/key1"})).To(BeTrue())
+	})
+
+	It("does not report a conflict for keys that were not written", func() {
+		txContexts.RecordCommittedWrites("chainID", []string{"ns/key1"})
+
+		Expect(txContexts.WouldConflict("chainID", []string{"ns/key2", "ns/key3"})).To(BeFalse())
+		Expect(txContexts.WouldConflict("chainID", nil)).To(BeFalse())
+	})
+
+	It("keeps the writes of each channel separate", func() {
+		txContexts.RecordCommittedWrites("chainID", []string{"ns/key1"})
+
+		Expect(txContexts.WouldConflict("other-chainID", []string{"ns/key1"})).To(BeFalse())
+	})
+
+	It("forgets writes older than the window", func() {
+		txContexts.RecordCommittedWrites("chainID", []string{"ns/key1"})
+		now = now.Add(30 * time.Second)
+		txContexts.RecordCommittedWrites("chainID", []string{"ns/key2"})
+
+		now = now.Add(30 * time.Second)
+		Expect(txContexts.WouldConflict("chainID", []string{"ns/key1"})).To(BeTrue())
+
+		now = now.Add(time.Second)
+		Expect(txContexts.WouldConflict("chainID", []string{"ns/key1"})).To(BeFalse())
+		Expect(txContexts.WouldConflict("chainID", []string{"ns/key2"})).To(BeTrue())
+	})
+
+	It("refreshes keys that are written again", func() {
+		txContexts.RecordCommittedWrites("chainID", []string{"ns/key1"})
+		now = now.Add(45 * time.Second)
+		txContexts.RecordCommittedWrites("chainID", []string{"ns/key1"})
+
+		now = now.Add(45 * time.Second)
+		Expect(txContexts.WouldConflict("chainID", []string{"ns/key1"})).To(BeTrue())
+	})
+
+	Context("when the window is zero", func() {
+		BeforeEach(func() {
+			txContexts.RecentWritesWindow = 0
+		})
+
+		It("records nothing and never reports a conflict", func() {
+			txContexts.RecordCommittedWrites("chainID", []string{"ns/key1"})
+			Expect(txContexts.WouldConflict("chainID", []string{"ns/key1"})).To(BeFalse())
+
+			txContexts.RecentWritesWindow = time.Minute
+			Expect(txContexts.WouldConflict("chainID", []string{"ns/key1"})).To(BeFalse())
+		})
+	})
+})
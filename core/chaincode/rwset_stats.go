@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"sync/atomic"
+
+	"github.com/hyperledger/fabric/core/ledger"
+)
+
+// rwsetStats counts the keys a transaction reads from and writes to its
+// simulator.
+type rwsetStats struct {
+	reads  int64
+	writes int64
+}
+
+func (s *rwsetStats) read(n int)  { atomic.AddInt64(&s.reads, int64(n)) }
+func (s *rwsetStats) write(n int) { atomic.AddInt64(&s.writes, int64(n)) }
+
+// statsSimulator is a ledger.TxSimulator that records the number of keys read
+// and written through it. Range scans and rich queries are not counted.
+type statsSimulator struct {
+	ledger.TxSimulator
+	stats *rwsetStats
+}
+
+func (s *statsSimulator) GetState(namespace, key string) ([]byte, error) {
+	s.stats.read(1)
+	return s.TxSimulator.GetState(namespace, key)
+}
+
+func (s *statsSimulator) GetStateMultipleKeys(namespace string, keys []string) ([][]byte, error) {
+	s.stats.read(len(keys))
+	return s.TxSimulator.GetStateMultipleKeys(namespace, keys)
+}
+
+func (s *statsSimulator) GetPrivateData(namespace, collection, key string) ([]byte, error) {
+	s.stats.read(1)
+	return s.TxSimulator.GetPrivateData(namespace, collection, key)
+}
+
+func (s *statsSimulator) GetPrivateDataMultipleKeys(namespace, collection string, keys []string) ([][]byte, error) {
+	s.stats.read(len(keys))
+	return s.TxSimulator.GetPrivateDataMultipleKeys(namespace, collection, keys)
+}
+
+func (s *statsSimulator) SetState(namespace, key string, value []byte) error {
+	s.stats.write(1)
+	return s.TxSimulator.SetState(namespace, key, value)
+}
+
+func (s *statsSimulator) DeleteState(namespace, key string) error {
+	s.stats.write(1)
+	return s.TxSimulator.DeleteState(namespace, key)
+}
+
+func (s *statsSimulator) SetStateMultipleKeys(namespace string, kvs map[string][]byte) error {
+	s.stats.write(len(kvs))
+	return s.TxSimulator.SetStateMultipleKeys(namespace, kvs)
+}
+
+func (s *statsSimulator) SetPrivateData(namespace, collection, key string, value []byte) error {
+	s.stats.write(1)
+	return s.TxSimulator.SetPrivateData(namespace, collection, key, value)
+}
+
+func (s *statsSimulator) SetPrivateDataMultipleKeys(namespace, collection string, kvs map[string][]byte) error {
+	s.stats.write(len(kvs))
+	return s.TxSimulator.SetPrivateDataMultipleKeys(namespace, collection, kvs)
+}
+
+func (s *statsSimulator) DeletePrivateData(namespace, collection, key string) error {
+	s.stats.write(1)
+	return s.TxSimulator.DeletePrivateData(namespace, collection, key)
+}
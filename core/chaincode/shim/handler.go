@@ -7,7 +7,10 @@ SPDX-License-Identifier: Apache-2.0
 package shim
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io/ioutil"
 	"sync"
 
 	"github.com/golang/protobuf/proto"
@@ -414,6 +417,9 @@ func (handler *Handler) handleGetStateByRange(collection, startKey, endKey strin
 			chaincodeLogger.Errorf("[%s] unmarshal error", shorttxid(responseMsg.Txid))
 			return nil, errors.Errorf("[%s] GetStateByRangeResponse unmarshall error", shorttxid(responseMsg.Txid))
 		}
+		if err = decompressQueryResponse(rangeQueryResponse); err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("[%s] GetStateByRangeResponse decompress error", shorttxid(responseMsg.Txid)))
+		}
 
 		return rangeQueryResponse, nil
 	}
@@ -462,6 +468,9 @@ func (handler *Handler) handleQueryStateNext(id, channelId, txid string) (*pb.Qu
 			chaincodeLogger.Errorf("[%s] unmarshall error", shorttxid(responseMsg.Txid))
 			return nil, errors.Errorf("[%s] unmarshal error", shorttxid(responseMsg.Txid))
 		}
+		if err = decompressQueryResponse(queryResponse); err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("[%s] decompress error", shorttxid(responseMsg.Txid)))
+		}
 
 		return queryResponse, nil
 	}
@@ -546,6 +555,9 @@ func (handler *Handler) handleGetQueryResult(collection string, query string, ch
 			chaincodeLogger.Errorf("[%s] unmarshall error", shorttxid(responseMsg.Txid))
 			return nil, errors.Errorf("[%s] unmarshal error", shorttxid(responseMsg.Txid))
 		}
+		if err = decompressQueryResponse(executeQueryResponse); err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("[%s] decompress error", shorttxid(responseMsg.Txid)))
+		}
 
 		return executeQueryResponse, nil
 	}
@@ -594,6 +606,9 @@ func (handler *Handler) handleGetHistoryForKey(key string, channelId string, txi
 			chaincodeLogger.Errorf("[%s] unmarshall error", shorttxid(responseMsg.Txid))
 			return nil, errors.Errorf("[%s] unmarshal error", shorttxid(responseMsg.Txid))
 		}
+		if err = decompressQueryResponse(getHistoryForKeyResponse); err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("[%s] decompress error", shorttxid(responseMsg.Txid)))
+		}
 
 		return getHistoryForKeyResponse, nil
 	}
@@ -755,3 +770,31 @@ func (handler *Handler) handleMessage(msg *pb.ChaincodeMessage, errc chan error)
 
 	return nil
 }
+
+// decompressQueryResponse replaces the results of a query response that the
+// peer compressed with the results it carries.
+func decompressQueryResponse(response *pb.QueryResponse) error {
+	if !response.Compressed {
+		return nil
+	}
+	if len(response.Results) != 1 {
+		return errors.Errorf("compressed query response holds %d results, expected 1", len(response.Results))
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(response.Results[0].ResultBytes))
+	if err != nil {
+		return errors.Wrap(err, "failed to decompress query response")
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to decompress query response")
+	}
+	batch := &pb.QueryResponse{}
+	if err := proto.Unmarshal(b, batch); err != nil {
+		return errors.Wrap(err, "failed to unmarshal decompressed query response")
+	}
+
+	response.Results = batch.Results
+	response.Compressed = false
+	return nil
+}
@@ -18,12 +18,14 @@ package shim
 
 import (
 	"bytes"
+	"compress/gzip"
 	"os"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/common/flogging"
 	mockpeer "github.com/hyperledger/fabric/common/mocks/peer"
 	"github.com/hyperledger/fabric/common/util"
@@ -973,3 +975,33 @@ func TestSend(t *testing.T) {
 	err := stream.Send(msg)
 	assert.NotNil(t, err, "should have errored on panic")
 }
+
+func TestDecompressQueryResponse(t *testing.T) {
+	results := []*pb.QueryResultBytes{{ResultBytes: []byte("result-1")}, {ResultBytes: []byte("result-2")}}
+	b, err := proto.Marshal(&pb.QueryResponse{Results: results})
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+
+	response := &pb.QueryResponse{Results: []*pb.QueryResultBytes{{ResultBytes: buf.Bytes()}}, HasMore: true, Id: "query-id", Compressed: true}
+	err = decompressQueryResponse(response)
+	assert.NoError(t, err)
+	assert.False(t, response.Compressed)
+	assert.True(t, response.HasMore)
+	assert.Equal(t, "query-id", response.Id)
+	assert.True(t, proto.Equal(&pb.QueryResponse{Results: results}, &pb.QueryResponse{Results: response.Results}))
+
+	uncompressed := &pb.QueryResponse{Results: results}
+	err = decompressQueryResponse(uncompressed)
+	assert.NoError(t, err)
+	assert.Equal(t, results, uncompressed.Results)
+
+	err = decompressQueryResponse(&pb.QueryResponse{Results: results, Compressed: true})
+	assert.EqualError(t, err, "compressed query response holds 2 results, expected 1")
+
+	err = decompressQueryResponse(&pb.QueryResponse{Results: []*pb.QueryResultBytes{{ResultBytes: []byte("garbage")}}, Compressed: true})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to decompress query response")
+}
@@ -0,0 +1,32 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "golang.org/x/net/context"
+
+// Span is the subset of a tracing span used to trace the work performed for a
+// transaction. Adapters for a tracing library provide the root span of a
+// transaction through the SpanKey context value.
+type Span interface {
+	// StartChild starts a span that is a child of the span.
+	StartChild(name string) Span
+	// Finish completes the span.
+	Finish()
+}
+
+// noopSpan is used when a transaction is not traced.
+type noopSpan struct{}
+
+func (noopSpan) StartChild(string) Span { return noopSpan{} }
+func (noopSpan) Finish()                {}
+
+func getSpan(ctx context.Context) Span {
+	if span, ok := ctx.Value(SpanKey).(Span); ok {
+		return span
+	}
+	return nil
+}
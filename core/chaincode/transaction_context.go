@@ -7,69 +7,1339 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	commonledger "github.com/hyperledger/fabric/common/ledger"
 	"github.com/hyperledger/fabric/core/ledger"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
 )
 
+// ErrQuerySourceUnavailable is returned when a strict context lacks the data
+// source required by a query.
+var ErrQuerySourceUnavailable = errors.New("query source not available")
+
+// ErrTooManyQueryIterators is returned by RegisterIterator when the maximum
+// number of open query iterators for the context has been reached.
+var ErrTooManyQueryIterators = errors.New("too many open query iterators, close some before opening more")
+
+// ErrMaxBytesReadExceeded is returned when the query results read by a
+// transaction exceed the maximum number of bytes permitted.
+var ErrMaxBytesReadExceeded = errors.New("transaction exceeded maximum bytes read")
+
+// ErrIteratorIdle is returned when a query iterator is used after it was
+// closed for not having been advanced within the idle timeout.
+var ErrIteratorIdle = errors.New("query iterator was closed after being idle")
+
+// Priority influences how a transaction context is treated when the registry
+// is under pressure. Contexts with a lower priority are evicted first.
+type Priority int
+
+const (
+	// PriorityLow is used for transactions that may be evicted first.
+	PriorityLow Priority = -1
+	// PriorityNormal is the priority of contexts that do not request one.
+	PriorityNormal Priority = 0
+	// PriorityHigh is used for transactions, such as system chaincode
+	// invocations, that should outlive others. High priority contexts are not
+	// subject to creator rate limiting.
+	PriorityHigh Priority = 1
+)
+
+// IteratorReusePolicy determines what RegisterIterator does when an iterator
+// is already registered for the query ID.
+type IteratorReusePolicy int
+
+const (
+	// IteratorReuseError rejects the registration of the second iterator.
+	IteratorReuseError IteratorReusePolicy = iota
+	// IteratorReuseReplace closes the registered iterator and replaces it.
+	IteratorReuseReplace
+)
+
+// QueryType identifies the kind of query a registered iterator serves.
+type QueryType int
+
+const (
+	// QueryTypeUnknown is used for iterators registered without a type.
+	QueryTypeUnknown QueryType = iota
+	// QueryTypeRange is used for key range scans.
+	QueryTypeRange
+	// QueryTypeRich is used for rich queries against the state database.
+	QueryTypeRich
+	// QueryTypeHistory is used for key history queries.
+	QueryTypeHistory
+)
+
+func (q QueryType) String() string {
+	switch q {
+	case QueryTypeRange:
+		return "range"
+	case QueryTypeRich:
+		return "rich"
+	case QueryTypeHistory:
+		return "history"
+	default:
+		return "unknown"
+	}
+}
+
+// SimulatorBackendReporter is implemented by transaction simulators that can
+// report the state database backing them, such as "leveldb" or "couchdb".
+type SimulatorBackendReporter interface {
+	Backend() string
+}
+
+// TransactionContext holds the state of a transaction that is being executed
+// by a chaincode.
+//
+// The query methods of a TransactionContext are safe for concurrent use so
+// that a chaincode may run several queries at once within a transaction.
+// RegisterIterator, GetIterator, GetPendingQueryResult, RemoveIterator,
+// CleanupQueryContext, and the bookmark accessors are serialized by a mutex
+// that guards the iterators, pending results, and bookmarks of the context.
+// Each PendingQueryResult is also safe for concurrent use, but results from
+// concurrent readers of the same query are interleaved. The exported fields
+// must not be modified once the context has been inserted into a registry.
+// The transaction simulator and history query executor may be changed by
+// TransactionContexts.Replace and SetHistoryQueryExecutor, so they must be
+// read through GetTxSimulator and GetHistoryQueryExecutor once the context is
+// in use.
 type TransactionContext struct {
 	ChainID              string
+	TxID                 string
 	SignedProp           *pb.SignedProposal
 	Proposal             *pb.Proposal
 	ResponseNotifier     chan *pb.ChaincodeMessage
 	TXSimulator          ledger.TxSimulator
 	HistoryQueryExecutor ledger.HistoryQueryExecutor
 
-	// tracks open iterators used for range queries
+	// sourcesMutex guards TXSimulator and HistoryQueryExecutor once the
+	// context has been inserted into a registry
+	sourcesMutex sync.RWMutex
+
+	// queryMutex guards the open iterators used for range queries along with
+	// their pending results, open times, and bookmarks
 	queryMutex          sync.Mutex
 	queryIteratorMap    map[string]commonledger.ResultsIterator
 	pendingQueryResults map[string]*PendingQueryResult
+	// iteratorOpened records when each iterator was registered
+	iteratorOpened map[string]time.Time
+	// iteratorAccessed records when each iterator was last advanced
+	iteratorAccessed map[string]time.Time
+	// idleIterators holds the IDs of iterators closed for being idle
+	idleIterators map[string]struct{}
+	// bookmarks holds the position from which a paginated query may resume
+	bookmarks map[string]string
+	// queryTypes holds the kind of query of typed iterators
+	queryTypes map[string]QueryType
+	// iteratorErrors holds iterator errors deferred until the results
+	// buffered before the error have been returned
+	iteratorErrors map[string]error
+	// clientQueryIDs maps the query IDs chosen by clients to the internal IDs
+	// of the iterators most recently registered for them
+	clientQueryIDs map[string]string
+	// nextQueryID is used to generate internal query IDs
+	nextQueryID uint64
+	// readOnly contexts reject state writes
+	readOnly bool
+	// queryOnly contexts serve pure query flows and are not expected to carry
+	// a transaction simulator
+	queryOnly bool
+	// priority determines the order in which contexts are evicted
+	priority Priority
+	// span is the root tracing span of the transaction; nil when untraced
+	span Span
+	// handle identifies the context across registries; set on first insert
+	handle string
+	// rwsetStats counts simulator reads and writes; nil when not tracked
+	rwsetStats *rwsetStats
+	// cacheReads serves repeated simulator reads from memory
+	cacheReads bool
+	// logger tags log messages with the chain and transaction ID
+	logger *TransactionLogger
+	// creator is the serialized identity of the proposal creator
+	creator []byte
+	// chaincodeName is the name of the chaincode invoked by the proposal
+	chaincodeName string
+	// maxQueryIterators limits the number of open iterators; zero is unlimited
+	maxQueryIterators int
+	// readSlots bounds the concurrent iterator reads of the registry; nil is
+	// unlimited
+	readSlots chan struct{}
+	// iteratorWrapper wraps iterators as they are registered; nil disables
+	// wrapping
+	iteratorWrapper func(commonledger.ResultsIterator) commonledger.ResultsIterator
+	// iteratorReuse determines whether a reused query ID replaces the iterator
+	iteratorReuse IteratorReusePolicy
+	// strictQuerySources rejects queries whose data source is missing
+	strictQuerySources bool
+
+	// created is the time the context was created by the registry
+	created time.Time
+	// nextWarning is when the context is next reported as long-lived; it is
+	// guarded by the registry shard lock
+	nextWarning time.Time
+	// bytesRead is the total size of query results returned to the chaincode
+	bytesRead int64
+	// maxBytesRead limits bytesRead; zero is unlimited
+	maxBytesRead int64
+	// budget limits the memory tracked for the transaction; nil is unlimited
+	budget *memoryBudget
+	// parent is the context of the invoking chaincode for child contexts
+	parent *TransactionContext
+	// children are closed when the context is removed from its registry and
+	// are guarded by the registry's lock
+	children []*TransactionContext
+	// leases counts the holders of the context returned by Acquire and
+	// released holds the teardown deferred until they are gone; both are
+	// guarded by the registry's lock
+	leases   int
+	released []func()
+	// deleteHooks are run when the context is removed from its registry
+	hooksMutex  sync.Mutex
+	deleteHooks []func()
+	// labels are caller defined tags used to classify the context
+	labelsMutex sync.Mutex
+	labels      map[string]string
+	// values hold state attached to the context by independent components
+	valuesMutex sync.Mutex
+	values      map[interface{}]interface{}
+	// responses collects the parts of multi-part responses when collecting
+	// is enabled; partial holds the parts received since the last terminal
+	responsesMutex sync.Mutex
+	terminal       func(*pb.ChaincodeMessage) bool
+	partial        []*pb.ChaincodeMessage
+	responses      []*pb.ChaincodeMessage
+	// metrics is notified of query activity; nil disables reporting
+	metrics TransactionContextMetrics
+	// now is the clock used to measure iterator lifetimes
+	now func() time.Time
+	// ctx is derived from the context provided at creation and is cancelled
+	// when the transaction context is deleted
+	ctx    context.Context
+	cancel context.CancelFunc
+	// deadlineTimer fires when the maximum transaction duration is exceeded
+	deadlineTimer *time.Timer
+	// timedOut is set to 1 when the context is removed by deadlineTimer
+	timedOut int32
+}
+
+// NewTransactionContext creates a transaction context for the specified chain
+// and transaction ID that can be registered with TransactionContexts.Insert.
+// The ledger fields may be set by the caller before the context is inserted.
+func NewTransactionContext(chainID, txID string, signedProp *pb.SignedProposal, proposal *pb.Proposal) *TransactionContext {
+	return &TransactionContext{
+		ChainID:             chainID,
+		TxID:                txID,
+		SignedProp:          signedProp,
+		Proposal:            proposal,
+		ResponseNotifier:    make(chan *pb.ChaincodeMessage, 1),
+		queryIteratorMap:    map[string]commonledger.ResultsIterator{},
+		pendingQueryResults: map[string]*PendingQueryResult{},
+		chaincodeName:       getChaincodeName(proposal),
+		logger:              newTransactionLogger(chainID, txID),
+	}
+}
+
+// Context returns the context.Context associated with the transaction. The
+// context is cancelled when the parent context provided at creation is
+// cancelled or when the transaction context is removed from its registry.
+func (t *TransactionContext) Context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// Notify delivers msg to the ResponseNotifier without blocking. It returns
+// false when the message could not be delivered because the notifier is full.
+//
+// When the context collects multi-part responses, messages are buffered until
+// the terminal message arrives, and only the terminal message is delivered.
+func (t *TransactionContext) Notify(msg *pb.ChaincodeMessage) bool {
+	if !t.collect(msg) {
+		return true
+	}
+
+	select {
+	case t.ResponseNotifier <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// CollectResponses enables the collection of multi-part responses. Messages
+// delivered by Notify are collected in order until terminal reports a message
+// as the end of the response. The complete sequence is then available from
+// Responses and the terminal message is delivered to the ResponseNotifier.
+// When terminal is nil, COMPLETED and ERROR messages end a response.
+func (t *TransactionContext) CollectResponses(terminal func(*pb.ChaincodeMessage) bool) {
+	if terminal == nil {
+		terminal = isTerminalResponse
+	}
+
+	t.responsesMutex.Lock()
+	defer t.responsesMutex.Unlock()
+	t.terminal = terminal
+	t.partial = nil
+	t.responses = nil
+}
+
+// Responses returns the messages of the last complete multi-part response,
+// ending with its terminal message. Nil is returned until a terminal message
+// has been received.
+func (t *TransactionContext) Responses() []*pb.ChaincodeMessage {
+	t.responsesMutex.Lock()
+	defer t.responsesMutex.Unlock()
+	if t.responses == nil {
+		return nil
+	}
+	responses := make([]*pb.ChaincodeMessage, len(t.responses))
+	copy(responses, t.responses)
+	return responses
+}
+
+// collect records msg when multi-part responses are collected and reports
+// whether msg should be delivered to the ResponseNotifier.
+func (t *TransactionContext) collect(msg *pb.ChaincodeMessage) bool {
+	t.responsesMutex.Lock()
+	defer t.responsesMutex.Unlock()
+	if t.terminal == nil {
+		return true
+	}
+
+	t.partial = append(t.partial, msg)
+	if !t.terminal(msg) {
+		return false
+	}
+	t.responses, t.partial = t.partial, nil
+	return true
+}
+
+func isTerminalResponse(msg *pb.ChaincodeMessage) bool {
+	return msg.Type == pb.ChaincodeMessage_COMPLETED || msg.Type == pb.ChaincodeMessage_ERROR
+}
+
+// Err returns ErrTransactionTimeout when the transaction context was removed
+// because it exceeded the maximum transaction duration and nil otherwise.
+func (t *TransactionContext) Err() error {
+	if atomic.LoadInt32(&t.timedOut) != 0 {
+		return ErrTransactionTimeout
+	}
+	return nil
+}
+
+// ReadOnly returns true when state writes are not permitted on the
+// transaction context.
+func (t *TransactionContext) ReadOnly() bool {
+	return t.readOnly
+}
+
+// QueryOnly returns true when the transaction context serves a pure query
+// flow, in which the absence of a transaction simulator is intentional.
+func (t *TransactionContext) QueryOnly() bool {
+	return t.queryOnly
+}
+
+// TxSimulatorMissing returns true when the transaction context lacks a
+// transaction simulator that it was expected to carry. A query-only context
+// without a simulator is not reported as missing one.
+func (t *TransactionContext) TxSimulatorMissing() bool {
+	return t.GetTxSimulator() == nil && !t.queryOnly
+}
+
+// Priority returns the priority of the transaction context.
+func (t *TransactionContext) Priority() Priority {
+	return t.priority
+}
+
+// RWSetStats returns the number of keys the transaction has read from and
+// written to its simulator. Both counts are zero unless the registry that
+// created the context tracks read-write set statistics.
+func (t *TransactionContext) RWSetStats() (reads, writes int) {
+	if t.rwsetStats == nil {
+		return 0, 0
+	}
+	return int(atomic.LoadInt64(&t.rwsetStats.reads)), int(atomic.LoadInt64(&t.rwsetStats.writes))
+}
+
+// SimulatorBackend returns the name of the state database backing the
+// simulator of the transaction context. Simulators that implement
+// SimulatorBackendReporter report their backend; for other simulators the Go
+// type of the simulator is returned. An empty string is returned when the
+// context has no simulator.
+func (t *TransactionContext) SimulatorBackend() string {
+	txsim := t.GetTxSimulator()
+	for {
+		switch s := txsim.(type) {
+		case nil:
+			return ""
+		case *statsSimulator:
+			txsim = s.TxSimulator
+		case *budgetSimulator:
+			txsim = s.TxSimulator
+		case *cachingSimulator:
+			txsim = s.TxSimulator
+		case SimulatorBackendReporter:
+			return s.Backend()
+		default:
+			return fmt.Sprintf("%T", s)
+		}
+	}
+}
+
+// Logger returns a logger whose messages are tagged with the chain and
+// transaction ID of the transaction context.
+func (t *TransactionContext) Logger() *TransactionLogger {
+	if t.logger == nil {
+		return newTransactionLogger(t.ChainID, t.TxID)
+	}
+	return t.logger
+}
+
+// WrapErr annotates err with the operation that failed and the chain and
+// transaction ID of the transaction context. The original error can be
+// recovered with errors.Cause. WrapErr returns nil if err is nil.
+func (t *TransactionContext) WrapErr(err error, op string) error {
+	return errors.Wrapf(err, "txid: %s(%s): %s failed", t.TxID, t.ChainID, op)
+}
+
+// Handle returns an opaque string that identifies the transaction context
+// and that can be passed to TransactionContexts.GetByHandle. The handle is
+// assigned when the context is first added to a registry, is kept when the
+// context is transferred, and is never reused by another context.
+func (t *TransactionContext) Handle() string {
+	return t.handle
+}
+
+// StartChildSpan starts a tracing span for an operation of the transaction
+// that is a child of the span provided at creation. When the transaction is
+// not traced, a span that does nothing is returned.
+func (t *TransactionContext) StartChildSpan(name string) Span {
+	if t.span == nil {
+		return noopSpan{}
+	}
+	return t.span.StartChild(name)
+}
+
+func (t *TransactionContext) finishSpan() {
+	if t.span != nil {
+		t.span.Finish()
+	}
+}
+
+// Creator returns the serialized identity of the creator of the proposal or
+// nil when it could not be extracted from the signed proposal.
+func (t *TransactionContext) Creator() []byte {
+	return t.creator
+}
+
+// ChaincodeName returns the name of the chaincode invoked by the proposal of
+// the transaction context or an empty string when the proposal does not name
+// a chaincode.
+func (t *TransactionContext) ChaincodeName() string {
+	return t.chaincodeName
+}
+
+// Parent returns the transaction context of the invoking chaincode when the
+// context was created with CreateChild and nil otherwise.
+func (t *TransactionContext) Parent() *TransactionContext {
+	return t.parent
+}
+
+// GetProposal returns the proposal the transaction context was created with.
+func (t *TransactionContext) GetProposal() *pb.Proposal {
+	return t.Proposal
+}
+
+// GetSignedProposal returns the signed proposal the transaction context was
+// created with.
+func (t *TransactionContext) GetSignedProposal() *pb.SignedProposal {
+	return t.SignedProp
+}
+
+// GetTxSimulator returns the transaction simulator of the transaction
+// context.
+func (t *TransactionContext) GetTxSimulator() ledger.TxSimulator {
+	t.sourcesMutex.RLock()
+	defer t.sourcesMutex.RUnlock()
+	return t.TXSimulator
+}
+
+// setTxSimulator replaces the transaction simulator of the transaction
+// context.
+func (t *TransactionContext) setTxSimulator(txsim ledger.TxSimulator) {
+	t.sourcesMutex.Lock()
+	t.TXSimulator = txsim
+	t.sourcesMutex.Unlock()
+}
+
+// GetHistoryQueryExecutor returns the history query executor of the
+// transaction context.
+func (t *TransactionContext) GetHistoryQueryExecutor() ledger.HistoryQueryExecutor {
+	t.sourcesMutex.RLock()
+	defer t.sourcesMutex.RUnlock()
+	return t.HistoryQueryExecutor
+}
+
+// setHistoryQueryExecutor replaces the history query executor of the
+// transaction context.
+func (t *TransactionContext) setHistoryQueryExecutor(hqe ledger.HistoryQueryExecutor) {
+	t.sourcesMutex.Lock()
+	t.HistoryQueryExecutor = hqe
+	t.sourcesMutex.Unlock()
+}
+
+// SupportsHistory returns true when the transaction context has a history
+// query executor.
+func (t *TransactionContext) SupportsHistory() bool {
+	return t.GetHistoryQueryExecutor() != nil
+}
+
+// checkQuerySource returns an error when the context is strict about query
+// sources and lacks the data source required by queries of type qt. The error
+// for a query-only context notes that its simulator is absent by design.
+func (t *TransactionContext) checkQuerySource(qt QueryType) error {
+	if !t.strictQuerySources {
+		return nil
+	}
+
+	switch qt {
+	case QueryTypeHistory:
+		if t.GetHistoryQueryExecutor() == nil {
+			return errors.Wrapf(ErrQuerySourceUnavailable, "txid: %s(%s): %s query requires a history query executor", t.TxID, t.ChainID, qt)
+		}
+	default:
+		txsim := t.GetTxSimulator()
+		if txsim == nil && t.queryOnly {
+			return errors.Wrapf(ErrQuerySourceUnavailable, "txid: %s(%s): %s query requires a transaction simulator, which query-only contexts do not carry", t.TxID, t.ChainID, qt)
+		}
+		if txsim == nil {
+			return errors.Wrapf(ErrQuerySourceUnavailable, "txid: %s(%s): %s query requires a transaction simulator", t.TxID, t.ChainID, qt)
+		}
+	}
+	return nil
+}
+
+// OnDelete registers a hook that is run when the transaction context is
+// removed from its registry. Hooks run in registration order after the
+// registry lock has been released, so they may call back into the registry.
+// A panic in a hook is recovered and logged and does not prevent other hooks
+// from running.
+func (t *TransactionContext) OnDelete(hook func()) {
+	t.hooksMutex.Lock()
+	t.deleteHooks = append(t.deleteHooks, hook)
+	t.hooksMutex.Unlock()
+}
+
+func (t *TransactionContext) runDeleteHooks() {
+	t.hooksMutex.Lock()
+	hooks := t.deleteHooks
+	t.deleteHooks = nil
+	t.hooksMutex.Unlock()
+
+	for _, hook := range hooks {
+		t.runDeleteHook(hook)
+	}
+}
+
+func (t *TransactionContext) runDeleteHook(hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			chaincodeLogger.Errorf("txid: %s(%s): recovered from panic in delete hook: %v", t.TxID, t.ChainID, r)
+		}
+	}()
+	hook()
+}
+
+// SetLabel tags the transaction context with a label. An existing label with
+// the same key is overwritten.
+func (t *TransactionContext) SetLabel(key, value string) {
+	t.labelsMutex.Lock()
+	if t.labels == nil {
+		t.labels = map[string]string{}
+	}
+	t.labels[key] = value
+	t.labelsMutex.Unlock()
+}
+
+// Label returns the value of the label with the specified key and whether the
+// label has been set.
+func (t *TransactionContext) Label(key string) (string, bool) {
+	t.labelsMutex.Lock()
+	value, ok := t.labels[key]
+	t.labelsMutex.Unlock()
+	return value, ok
+}
+
+// WithValue attaches val to the transaction context under key. As with
+// context.Context, the key must be comparable and callers should define their
+// own unexported key type to avoid collisions with other components. An
+// existing value with the same key is overwritten.
+func (t *TransactionContext) WithValue(key, val interface{}) {
+	if key == nil {
+		panic("nil key")
+	}
+	if !reflect.TypeOf(key).Comparable() {
+		panic("key is not comparable")
+	}
+	t.valuesMutex.Lock()
+	if t.values == nil {
+		t.values = map[interface{}]interface{}{}
+	}
+	t.values[key] = val
+	t.valuesMutex.Unlock()
+}
+
+// Value returns the value attached to the transaction context under key or
+// nil if no value has been attached.
+func (t *TransactionContext) Value(key interface{}) interface{} {
+	t.valuesMutex.Lock()
+	val := t.values[key]
+	t.valuesMutex.Unlock()
+	return val
+}
+
+// copyLabels returns a copy of the labels of the context or nil if no labels
+// have been set.
+func (t *TransactionContext) copyLabels() map[string]string {
+	t.labelsMutex.Lock()
+	defer t.labelsMutex.Unlock()
+	if len(t.labels) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(t.labels))
+	for k, v := range t.labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Guard runs fn and returns its error. If fn panics, the panic is recovered
+// and converted to an error, the query iterators of the context are closed,
+// and an error message is sent on the ResponseNotifier.
+func (t *TransactionContext) Guard(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("txid: %s(%s): recovered from panic: %v", t.TxID, t.ChainID, r)
+			chaincodeLogger.Errorf("%s", err)
+			t.CloseQueryIterators()
+			t.Notify(&pb.ChaincodeMessage{
+				Type:      pb.ChaincodeMessage_ERROR,
+				Payload:   []byte(err.Error()),
+				Txid:      t.TxID,
+				ChannelId: t.ChainID,
+			})
+		}
+	}()
+
+	return fn()
 }
 
-func (t *TransactionContext) InitializeQueryContext(queryID string, iter commonledger.ResultsIterator) {
+// WaitForResponse waits for a message to be delivered to the ResponseNotifier.
+// When ctx is done before a message is delivered, the context error is
+// returned.
+func (t *TransactionContext) WaitForResponse(ctx context.Context) (*pb.ChaincodeMessage, error) {
+	select {
+	case msg := <-t.ResponseNotifier:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// teardown closes the children of a removed context, cancels its context, and
+// runs its delete hooks. It is called once the context has been removed from
+// its registry, without holding the registry's lock; children can no longer be
+// added to a removed context.
+func (t *TransactionContext) teardown() {
+	for _, child := range t.children {
+		child.closeQueryContexts()
+		child.cancelContext()
+	}
+	t.children = nil
+	t.cancelContext()
+	t.recycleQueryMaps()
+	t.finishSpan()
+	t.runDeleteHooks()
+}
+
+func (t *TransactionContext) cancelContext() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// iteratorMapPool and pendingResultsPool recycle the query maps of contexts
+// that are removed from a registry with no open iterators.
+var (
+	iteratorMapPool = sync.Pool{
+		New: func() interface{} { return map[string]commonledger.ResultsIterator{} },
+	}
+	pendingResultsPool = sync.Pool{
+		New: func() interface{} { return map[string]*PendingQueryResult{} },
+	}
+)
+
+// recycleQueryMaps returns the query maps of the context to their pools when
+// they are empty. The maps of a context that still holds iterators are kept so
+// that the iterators can be closed.
+func (t *TransactionContext) recycleQueryMaps() {
 	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	if len(t.queryIteratorMap) != 0 || len(t.pendingQueryResults) != 0 {
+		return
+	}
+	if t.queryIteratorMap != nil {
+		iteratorMapPool.Put(t.queryIteratorMap)
+		t.queryIteratorMap = nil
+	}
+	if t.pendingQueryResults != nil {
+		pendingResultsPool.Put(t.pendingQueryResults)
+		t.pendingQueryResults = nil
+	}
+}
+
+// RegisterIterator associates a results iterator with the query ID and creates
+// an empty pending query result for it. If the registry that holds the context
+// has an IteratorWrapper, the wrapped iterator is registered and returned by
+// GetIterator. When an iterator is already registered for the query ID, an
+// error is returned unless the registry that holds the context uses
+// IteratorReuseReplace, in which case the registered iterator is closed and
+// its query state discarded.
+func (t *TransactionContext) RegisterIterator(queryID string, iter commonledger.ResultsIterator) error {
+	return t.RegisterIteratorOfType(queryID, iter, QueryTypeUnknown)
+}
+
+// InitializeQueryContext registers a results iterator for the query ID.
+//
+// Deprecated: use RegisterIterator.
+func (t *TransactionContext) InitializeQueryContext(queryID string, iter commonledger.ResultsIterator) error {
+	return t.RegisterIterator(queryID, iter)
+}
+
+// RegisterIteratorOfType registers a results iterator like RegisterIterator
+// and records the kind of query the iterator serves.
+func (t *TransactionContext) RegisterIteratorOfType(queryID string, iter commonledger.ResultsIterator, queryType QueryType) error {
+	_, err := t.registerIterator(queryID, iter, queryType)
+	return err
+}
+
+// OpenRegisteredIterator opens an iterator with open and registers it for the
+// query ID like RegisterIterator. The registered iterator, which may be
+// wrapped by the registry, is returned. When the iterator cannot be
+// registered, it is closed and the registration error is returned. Nothing
+// is registered when open fails.
+func (t *TransactionContext) OpenRegisteredIterator(queryID string, open func() (commonledger.ResultsIterator, error)) (commonledger.ResultsIterator, error) {
+	return t.openRegisteredIterator(queryID, QueryTypeUnknown, open)
+}
+
+func (t *TransactionContext) openRegisteredIterator(queryID string, queryType QueryType, open func() (commonledger.ResultsIterator, error)) (commonledger.ResultsIterator, error) {
+	iter, err := open()
+	if err != nil {
+		return nil, err
+	}
+	registered, err := t.registerIterator(queryID, iter, queryType)
+	if err != nil {
+		iter.Close()
+		return nil, err
+	}
+	return registered, nil
+}
+
+// RegisterClientIterator registers a results iterator for a query ID chosen
+// by the client under a unique internal query ID, which is returned. The
+// client ID resolves to the most recently registered iterator in the query
+// methods of the context, while iterators previously registered for the same
+// client ID remain registered and are available through their internal IDs.
+func (t *TransactionContext) RegisterClientIterator(clientID string, iter commonledger.ResultsIterator) (string, error) {
+	queryID, _, err := t.registerClientIterator(clientID, iter, QueryTypeUnknown)
+	return queryID, err
+}
+
+// openClientIterator opens an iterator and registers it for the client query
+// ID like RegisterClientIterator. The internal query ID and the iterator as
+// registered are returned. The opened iterator is closed when it cannot be
+// registered.
+func (t *TransactionContext) openClientIterator(clientID string, queryType QueryType, open func() (commonledger.ResultsIterator, error)) (string, commonledger.ResultsIterator, error) {
+	iter, err := open()
+	if err != nil {
+		return "", nil, err
+	}
+	queryID, registered, err := t.registerClientIterator(clientID, iter, queryType)
+	if err != nil {
+		iter.Close()
+		return "", nil, err
+	}
+	return queryID, registered, nil
+}
+
+// registerClientIterator registers iter for the client query ID under a new
+// internal query ID.
+func (t *TransactionContext) registerClientIterator(clientID string, iter commonledger.ResultsIterator, queryType QueryType) (string, commonledger.ResultsIterator, error) {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+
+	var queryID string
+	for {
+		t.nextQueryID++
+		queryID = fmt.Sprintf("%s#%d", clientID, t.nextQueryID)
+		if _, ok := t.queryIteratorMap[queryID]; !ok {
+			break
+		}
+	}
+	registered, err := t.registerIteratorLocked(queryID, iter, queryType)
+	if err != nil {
+		return "", nil, err
+	}
+	if t.clientQueryIDs == nil {
+		t.clientQueryIDs = map[string]string{}
+	}
+	t.clientQueryIDs[clientID] = queryID
+	return queryID, registered, nil
+}
+
+// resolveQueryID returns the internal query ID for a client query ID. Other
+// query IDs are returned unchanged. The caller must hold the query mutex.
+func (t *TransactionContext) resolveQueryID(queryID string) string {
+	if internal, ok := t.clientQueryIDs[queryID]; ok {
+		return internal
+	}
+	return queryID
+}
+
+// registerIterator registers iter and returns the iterator as registered.
+func (t *TransactionContext) registerIterator(queryID string, iter commonledger.ResultsIterator, queryType QueryType) (commonledger.ResultsIterator, error) {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	return t.registerIteratorLocked(queryID, iter, queryType)
+}
+
+// registerIteratorLocked registers iter. The caller must hold the query mutex.
+func (t *TransactionContext) registerIteratorLocked(queryID string, iter commonledger.ResultsIterator, queryType QueryType) (commonledger.ResultsIterator, error) {
 	if t.queryIteratorMap == nil {
 		t.queryIteratorMap = map[string]commonledger.ResultsIterator{}
 	}
 	if t.pendingQueryResults == nil {
 		t.pendingQueryResults = map[string]*PendingQueryResult{}
 	}
+	if old, ok := t.queryIteratorMap[queryID]; ok {
+		if t.iteratorReuse != IteratorReuseReplace {
+			return nil, errors.Errorf("query iterator %s is already registered", queryID)
+		}
+		if old != nil {
+			old.Close()
+			t.iteratorClosed(queryID)
+		}
+		t.removeIterator(queryID)
+	}
+	if t.maxQueryIterators > 0 && len(t.queryIteratorMap) >= t.maxQueryIterators {
+		return nil, ErrTooManyQueryIterators
+	}
+	if t.iteratorOpened == nil {
+		t.iteratorOpened = map[string]time.Time{}
+	}
+	if t.iteratorAccessed == nil {
+		t.iteratorAccessed = map[string]time.Time{}
+	}
+	if t.readSlots != nil {
+		iter = limitReads(iter, t.readSlots, t.Context())
+	}
+	if t.iteratorWrapper != nil {
+		iter = t.iteratorWrapper(iter)
+	}
+	now := t.clock()
 	t.queryIteratorMap[queryID] = iter
 	t.pendingQueryResults[queryID] = &PendingQueryResult{}
+	t.iteratorOpened[queryID] = now
+	t.iteratorAccessed[queryID] = now
+	delete(t.idleIterators, queryID)
+	if queryType != QueryTypeUnknown {
+		if t.queryTypes == nil {
+			t.queryTypes = map[string]QueryType{}
+		}
+		t.queryTypes[queryID] = queryType
+	}
+	return iter, nil
+}
+
+// QueryType returns the kind of query served by the iterator registered for
+// the query ID.
+func (t *TransactionContext) QueryType(queryID string) QueryType {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	return t.queryTypes[t.resolveQueryID(queryID)]
+}
+
+// countQueryTypes adds the number of open iterators of each query type to
+// counts.
+func (t *TransactionContext) countQueryTypes(counts map[QueryType]int) {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	for queryID := range t.queryIteratorMap {
+		counts[t.queryTypes[queryID]]++
+	}
+}
+
+// GetIterator returns the results iterator registered for the query ID.
+func (t *TransactionContext) GetIterator(queryID string) commonledger.ResultsIterator {
+	t.queryMutex.Lock()
+	iter := t.queryIteratorMap[t.resolveQueryID(queryID)]
 	t.queryMutex.Unlock()
+	return iter
 }
 
+// GetQueryIterator returns the results iterator registered for the query ID.
+//
+// Deprecated: use GetIterator.
 func (t *TransactionContext) GetQueryIterator(queryID string) commonledger.ResultsIterator {
+	return t.GetIterator(queryID)
+}
+
+// touchIterator records that the iterator registered for the query ID is
+// being advanced.
+func (t *TransactionContext) touchIterator(queryID string) {
 	t.queryMutex.Lock()
-	iter := t.queryIteratorMap[queryID]
+	queryID = t.resolveQueryID(queryID)
+	if _, ok := t.iteratorAccessed[queryID]; ok {
+		t.iteratorAccessed[queryID] = t.clock()
+	}
 	t.queryMutex.Unlock()
-	return iter
+}
+
+// iteratorIdle returns true when the iterator registered for the query ID was
+// closed for being idle.
+func (t *TransactionContext) iteratorIdle(queryID string) bool {
+	t.queryMutex.Lock()
+	_, ok := t.idleIterators[t.resolveQueryID(queryID)]
+	t.queryMutex.Unlock()
+	return ok
+}
+
+// closeIdleIterators closes and removes the iterators that have not been
+// advanced since the cutoff. The number of closed iterators is returned.
+func (t *TransactionContext) closeIdleIterators(cutoff time.Time) int {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+
+	closed := 0
+	for queryID, accessed := range t.iteratorAccessed {
+		if !accessed.Before(cutoff) {
+			continue
+		}
+		if iter := t.queryIteratorMap[queryID]; iter != nil {
+			iter.Close()
+			t.iteratorClosed(queryID)
+		}
+		if t.idleIterators == nil {
+			t.idleIterators = map[string]struct{}{}
+		}
+		t.idleIterators[queryID] = struct{}{}
+		// the client IDs stop resolving once the iterator is removed
+		for clientID, internal := range t.clientQueryIDs {
+			if internal == queryID {
+				t.idleIterators[clientID] = struct{}{}
+			}
+		}
+		t.removeIterator(queryID)
+		closed++
+	}
+	return closed
 }
 
 func (t *TransactionContext) GetPendingQueryResult(queryID string) *PendingQueryResult {
 	t.queryMutex.Lock()
-	result := t.pendingQueryResults[queryID]
+	result := t.pendingQueryResults[t.resolveQueryID(queryID)]
 	t.queryMutex.Unlock()
 	return result
 }
 
+// FlushPendingResults returns the query results buffered for the query ID in
+// the order they were produced by the iterator and empties the buffer. The
+// iterator remains registered. An error is returned when no query is
+// registered for the query ID.
+func (t *TransactionContext) FlushPendingResults(queryID string) ([]*pb.QueryResultBytes, error) {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	pending := t.pendingQueryResults[t.resolveQueryID(queryID)]
+	if pending == nil {
+		return nil, errors.Errorf("query iterator %s does not exist", queryID)
+	}
+	return pending.Cut(), nil
+}
+
+// StreamQueryResults starts delivering the results of the iterator registered
+// for the query ID through a QueryResultStream. At most highWaterMark results
+// are buffered before the iterator blocks waiting for the consumer; a high
+// water mark of zero hands each result directly to the consumer. The iterator
+// is cleaned up when the stream terminates.
+func (t *TransactionContext) StreamQueryResults(queryID string, highWaterMark int) (*QueryResultStream, error) {
+	if highWaterMark < 0 {
+		return nil, errors.Errorf("invalid high water mark %d", highWaterMark)
+	}
+	iter := t.GetIterator(queryID)
+	if iter == nil {
+		return nil, errors.Errorf("query iterator %s does not exist", queryID)
+	}
+
+	stream := &QueryResultStream{results: make(chan *pb.QueryResultBytes, highWaterMark)}
+	go stream.produce(t.Context(), t, iter, queryID)
+	return stream, nil
+}
+
+// OpenIteratorIDs returns the sorted IDs of the query iterators registered
+// with the transaction context.
+func (t *TransactionContext) OpenIteratorIDs() []string {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	ids := make([]string, 0, len(t.queryIteratorMap))
+	for id := range t.queryIteratorMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// RemoveIterator removes the results iterator and pending query result
+// registered for the query ID without closing the iterator.
+func (t *TransactionContext) RemoveIterator(queryID string) {
+	t.queryMutex.Lock()
+	t.removeIterator(t.resolveQueryID(queryID))
+	t.queryMutex.Unlock()
+}
+
+// CleanupQueryContext closes the results iterator registered for the query ID
+// and removes it along with its pending query result.
 func (t *TransactionContext) CleanupQueryContext(queryID string) {
+	t.closeIterator(queryID)
+}
+
+// closeIterator closes and removes the results iterator registered for the
+// query ID and forgets whether it was closed for being idle. It returns false
+// when no iterator was registered.
+func (t *TransactionContext) closeIterator(queryID string) bool {
 	t.queryMutex.Lock()
 	defer t.queryMutex.Unlock()
-	iter := t.queryIteratorMap[queryID]
+	delete(t.idleIterators, queryID)
+	queryID = t.resolveQueryID(queryID)
+	delete(t.idleIterators, queryID)
+	iter, ok := t.queryIteratorMap[queryID]
 	if iter != nil {
 		iter.Close()
+		t.iteratorClosed(queryID)
 	}
+	t.removeIterator(queryID)
+	return ok
+}
+
+// removeIterator removes all state associated with the query ID. The caller
+// must hold the query mutex.
+func (t *TransactionContext) removeIterator(queryID string) {
 	delete(t.queryIteratorMap, queryID)
 	delete(t.pendingQueryResults, queryID)
+	delete(t.iteratorOpened, queryID)
+	delete(t.iteratorAccessed, queryID)
+	delete(t.bookmarks, queryID)
+	delete(t.queryTypes, queryID)
+	delete(t.iteratorErrors, queryID)
+	for clientID, internal := range t.clientQueryIDs {
+		if internal == queryID {
+			delete(t.clientQueryIDs, clientID)
+		}
+	}
+}
+
+// deferIteratorError records an error from the iterator registered for the
+// query ID that is to be reported by the next request for the query.
+func (t *TransactionContext) deferIteratorError(queryID string, err error) {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	if t.iteratorErrors == nil {
+		t.iteratorErrors = map[string]error{}
+	}
+	t.iteratorErrors[t.resolveQueryID(queryID)] = err
+}
+
+// takeIteratorError returns and forgets the deferred error of the iterator
+// registered for the query ID.
+func (t *TransactionContext) takeIteratorError(queryID string) error {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	queryID = t.resolveQueryID(queryID)
+	err := t.iteratorErrors[queryID]
+	delete(t.iteratorErrors, queryID)
+	return err
+}
+
+// iteratorClosed reports how long the iterator registered for the query ID was
+// open. Each iterator is reported at most once. The caller must hold the query
+// mutex.
+func (t *TransactionContext) iteratorClosed(queryID string) {
+	opened, ok := t.iteratorOpened[queryID]
+	if !ok {
+		return
+	}
+	delete(t.iteratorOpened, queryID)
+	if t.metrics != nil {
+		t.metrics.IteratorClosed(t.ChainID, t.clock().Sub(opened))
+	}
+}
+
+// BytesRead returns the total number of bytes of query results that have been
+// returned for the transaction.
+func (t *TransactionContext) BytesRead() int64 {
+	return atomic.LoadInt64(&t.bytesRead)
+}
+
+// addBytesRead accounts for the bytes of a batch of query results. An error is
+// returned when the total exceeds the maximum number of bytes permitted.
+func (t *TransactionContext) addBytesRead(batch []*pb.QueryResultBytes) error {
+	var n int64
+	for _, result := range batch {
+		n += int64(len(result.ResultBytes))
+	}
+	total := atomic.AddInt64(&t.bytesRead, n)
+	if t.maxBytesRead > 0 && total > t.maxBytesRead {
+		return errors.Wrapf(ErrMaxBytesReadExceeded, "txid: %s(%s): read %d bytes", t.TxID, t.ChainID, total)
+	}
+	return nil
+}
+
+// queryBatch reports the size of a batch of query results.
+func (t *TransactionContext) queryBatch(size int) {
+	if t.metrics != nil {
+		t.metrics.QueryBatch(t.ChainID, size)
+	}
+}
+
+func (t *TransactionContext) clock() time.Time {
+	if t.now == nil {
+		return time.Now()
+	}
+	return t.now()
+}
+
+// SetBookmark records the bookmark from which the query identified by queryID
+// can be resumed.
+func (t *TransactionContext) SetBookmark(queryID, bookmark string) {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	if t.bookmarks == nil {
+		t.bookmarks = map[string]string{}
+	}
+	t.bookmarks[t.resolveQueryID(queryID)] = bookmark
+}
+
+// copyBookmarks returns a copy of the bookmarks of the context or nil if no
+// bookmarks have been recorded.
+func (t *TransactionContext) copyBookmarks() map[string]string {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	if len(t.bookmarks) == 0 {
+		return nil
+	}
+	bookmarks := make(map[string]string, len(t.bookmarks))
+	for k, v := range t.bookmarks {
+		bookmarks[k] = v
+	}
+	return bookmarks
+}
+
+// GetBookmark returns the bookmark recorded for the query identified by
+// queryID or an empty string if no bookmark has been recorded.
+func (t *TransactionContext) GetBookmark(queryID string) string {
+	t.queryMutex.Lock()
+	bookmark := t.bookmarks[t.resolveQueryID(queryID)]
+	t.queryMutex.Unlock()
+	return bookmark
 }
 
 func (t *TransactionContext) CloseQueryIterators() {
 	t.queryMutex.Lock()
 	defer t.queryMutex.Unlock()
-	for _, iter := range t.queryIteratorMap {
+	for queryID, iter := range t.queryIteratorMap {
 		iter.Close()
+		t.iteratorClosed(queryID)
+	}
+}
+
+// abandon closes the query iterators of a context that is removed before its
+// transaction completes and releases its transaction simulator.
+func (t *TransactionContext) abandon() {
+	t.CloseQueryIterators()
+	if txsim := t.GetTxSimulator(); txsim != nil {
+		txsim.Done()
+	}
+}
+
+// closeQueryIteratorsChecked closes the query iterators of the context in
+// order of query ID and returns an error for each iterator whose Close
+// panics. When failFast is true, the iterators following the first failure
+// are left open.
+func (t *TransactionContext) closeQueryIteratorsChecked(failFast bool) []error {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+
+	queryIDs := make([]string, 0, len(t.queryIteratorMap))
+	for queryID, iter := range t.queryIteratorMap {
+		if iter != nil {
+			queryIDs = append(queryIDs, queryID)
+		}
+	}
+	sort.Strings(queryIDs)
+
+	var errs []error
+	for _, queryID := range queryIDs {
+		if err := closeIterator(t.queryIteratorMap[queryID]); err != nil {
+			errs = append(errs, errors.WithMessage(err, fmt.Sprintf("txid: %s(%s): failed to close query iterator %s", t.TxID, t.ChainID, queryID)))
+			if failFast {
+				return errs
+			}
+			continue
+		}
+		t.iteratorClosed(queryID)
+	}
+	return errs
+}
+
+// closeIterator closes iter and converts a panic in Close to an error.
+func closeIterator(iter commonledger.ResultsIterator) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("recovered from panic: %v", r)
+		}
+	}()
+	iter.Close()
+	return nil
+}
+
+// takeQueryIterators returns the open iterators of the context so that they
+// can be closed without holding the query lock. The iterators remain
+// registered, as with CloseQueryIterators.
+func (t *TransactionContext) takeQueryIterators() map[string]commonledger.ResultsIterator {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	iters := make(map[string]commonledger.ResultsIterator, len(t.queryIteratorMap))
+	for queryID, iter := range t.queryIteratorMap {
+		iters[queryID] = iter
+		t.iteratorClosed(queryID)
+	}
+	return iters
+}
+
+// flushPendingQueryResults attempts to deliver the buffered results of each
+// query on the ResponseNotifier without blocking. Results that cannot be
+// delivered are discarded. The number of queries whose results were delivered
+// is returned.
+func (t *TransactionContext) flushPendingQueryResults() int {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+
+	delivered := 0
+	for queryID, pending := range t.pendingQueryResults {
+		if pending == nil || pending.Size() == 0 {
+			continue
+		}
+		payload, err := proto.Marshal(&pb.QueryResponse{Results: pending.Cut(), Id: queryID})
+		if err != nil {
+			chaincodeLogger.Errorf("failed to marshal pending results of query %s for txid: %s(%s): %s", queryID, t.TxID, t.ChainID, err)
+			continue
+		}
+		msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payload, Txid: t.TxID, ChannelId: t.ChainID}
+		if !t.Notify(msg) {
+			chaincodeLogger.Debugf("dropped pending results of query %s for txid: %s(%s)", queryID, t.TxID, t.ChainID)
+			continue
+		}
+		delivered++
+	}
+	return delivered
+}
+
+// closeQueryContexts closes all open iterators and discards all query state.
+func (t *TransactionContext) closeQueryContexts() {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	for queryID, iter := range t.queryIteratorMap {
+		if iter != nil {
+			iter.Close()
+			t.iteratorClosed(queryID)
+		}
+	}
+	t.queryIteratorMap = map[string]commonledger.ResultsIterator{}
+	t.pendingQueryResults = map[string]*PendingQueryResult{}
+	t.iteratorOpened = nil
+	t.iteratorAccessed = nil
+	t.idleIterators = nil
+	t.bookmarks = nil
+	t.queryTypes = nil
+	t.iteratorErrors = nil
+	t.clientQueryIDs = nil
+}
+
+// checkQueryInvariants reports every query ID that has a results iterator but
+// no pending query result or a pending query result but no results iterator.
+func (t *TransactionContext) checkQueryInvariants() []error {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+
+	var withoutResults, withoutIterators []string
+	for queryID := range t.queryIteratorMap {
+		if _, ok := t.pendingQueryResults[queryID]; !ok {
+			withoutResults = append(withoutResults, queryID)
+		}
+	}
+	for queryID := range t.pendingQueryResults {
+		if _, ok := t.queryIteratorMap[queryID]; !ok {
+			withoutIterators = append(withoutIterators, queryID)
+		}
+	}
+	sort.Strings(withoutResults)
+	sort.Strings(withoutIterators)
+
+	var errs []error
+	for _, queryID := range withoutResults {
+		errs = append(errs, errors.Errorf("txid: %s(%s): query iterator %s has no pending query result", t.TxID, t.ChainID, queryID))
+	}
+	for _, queryID := range withoutIterators {
+		errs = append(errs, errors.Errorf("txid: %s(%s): pending query result %s has no query iterator", t.TxID, t.ChainID, queryID))
+	}
+	return errs
+}
+
+// TransactionContextInfo holds metadata about an active transaction context.
+type TransactionContextInfo struct {
+	ChainID             string
+	TxID                string
+	Created             time.Time
+	QueryIterators      int
+	PendingQueryResults int
+	Labels              map[string]string
+}
+
+// Clone returns a copy of the metadata of the transaction context. The copy,
+// including its labels, does not change when the context is modified.
+func (t *TransactionContext) Clone() TransactionContextInfo {
+	return t.info()
+}
+
+func (t *TransactionContext) info() TransactionContextInfo {
+	t.queryMutex.Lock()
+	defer t.queryMutex.Unlock()
+	return TransactionContextInfo{
+		ChainID:             t.ChainID,
+		TxID:                t.TxID,
+		Created:             t.created,
+		QueryIterators:      len(t.queryIteratorMap),
+		PendingQueryResults: len(t.pendingQueryResults),
+		Labels:              t.copyLabels(),
 	}
 }
@@ -8,11 +8,20 @@ package chaincode_test
 
 import (
 	"fmt"
+	"sync"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/flogging"
+	commonledger "github.com/hyperledger/fabric/common/ledger"
 	"github.com/hyperledger/fabric/core/chaincode"
 	"github.com/hyperledger/fabric/core/chaincode/mock"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
 )
 
 var _ = Describe("TransactionContext", func() {
@@ -26,7 +35,200 @@ var _ = Describe("TransactionContext", func() {
 		transactionContext = &chaincode.TransactionContext{}
 	})
 
-	Describe("InitializeQueryContext", func() {
+	Describe("Notify", func() {
+		var msg *pb.ChaincodeMessage
+
+		BeforeEach(func() {
+			msg = &pb.ChaincodeMessage{Txid: "tx-id"}
+			transactionContext.ResponseNotifier = make(chan *pb.ChaincodeMessage, 1)
+		})
+
+		It("delivers the message to the response notifier", func() {
+			Expect(transactionContext.Notify(msg)).To(BeTrue())
+			Expect(transactionContext.ResponseNotifier).To(Receive(Equal(msg)))
+		})
+
+		Context("when the response notifier is full", func() {
+			BeforeEach(func() {
+				transactionContext.ResponseNotifier <- &pb.ChaincodeMessage{}
+			})
+
+			It("returns false without blocking", func() {
+				Expect(transactionContext.Notify(msg)).To(BeFalse())
+				Expect(transactionContext.ResponseNotifier).To(HaveLen(1))
+			})
+		})
+
+		Context("when multiple notifiers race", func() {
+			It("delivers exactly one message per buffer slot", func() {
+				transactionContext.ResponseNotifier = make(chan *pb.ChaincodeMessage, 3)
+
+				var wg sync.WaitGroup
+				results := make(chan bool, 10)
+				for i := 0; i < 10; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						results <- transactionContext.Notify(msg)
+					}()
+				}
+				wg.Wait()
+				close(results)
+
+				delivered := 0
+				for ok := range results {
+					if ok {
+						delivered++
+					}
+				}
+				Expect(delivered).To(Equal(3))
+				Expect(transactionContext.ResponseNotifier).To(HaveLen(3))
+			})
+		})
+	})
+
+	Describe("CollectResponses", func() {
+		var parts []*pb.ChaincodeMessage
+
+		BeforeEach(func() {
+			transactionContext.ResponseNotifier = make(chan *pb.ChaincodeMessage, 1)
+			parts = []*pb.ChaincodeMessage{
+				{Type: pb.ChaincodeMessage_RESPONSE, Payload: []byte("part-1")},
+				{Type: pb.ChaincodeMessage_RESPONSE, Payload: []byte("part-2")},
+				{Type: pb.ChaincodeMessage_RESPONSE, Payload: []byte("part-3")},
+				{Type: pb.ChaincodeMessage_COMPLETED, Payload: []byte("done")},
+			}
+			transactionContext.CollectResponses(nil)
+		})
+
+		It("collects the messages in order until the terminal message", func() {
+			for _, msg := range parts[:3] {
+				Expect(transactionContext.Notify(msg)).To(BeTrue())
+				Expect(transactionContext.ResponseNotifier).NotTo(Receive())
+				Expect(transactionContext.Responses()).To(BeNil())
+			}
+
+			Expect(transactionContext.Notify(parts[3])).To(BeTrue())
+			Expect(transactionContext.ResponseNotifier).To(Receive(Equal(parts[3])))
+			Expect(transactionContext.Responses()).To(Equal(parts))
+		})
+
+		It("starts a new sequence after the terminal message", func() {
+			for _, msg := range parts {
+				transactionContext.Notify(msg)
+			}
+			Expect(transactionContext.ResponseNotifier).To(Receive())
+
+			errMsg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR}
+			transactionContext.Notify(parts[0])
+			Expect(transactionContext.Responses()).To(Equal(parts))
+			transactionContext.Notify(errMsg)
+			Expect(transactionContext.Responses()).To(Equal([]*pb.ChaincodeMessage{parts[0], errMsg}))
+		})
+
+		Context("when a terminal function is provided", func() {
+			BeforeEach(func() {
+				transactionContext.CollectResponses(func(msg *pb.ChaincodeMessage) bool {
+					return string(msg.Payload) == "part-2"
+				})
+			})
+
+			It("ends the response when it reports a terminal message", func() {
+				transactionContext.Notify(parts[0])
+				Expect(transactionContext.Notify(parts[1])).To(BeTrue())
+				Expect(transactionContext.ResponseNotifier).To(Receive(Equal(parts[1])))
+				Expect(transactionContext.Responses()).To(Equal(parts[:2]))
+			})
+		})
+
+		Context("when the response notifier is full", func() {
+			It("still collects the response and returns false", func() {
+				transactionContext.ResponseNotifier <- &pb.ChaincodeMessage{}
+				for _, msg := range parts[:3] {
+					Expect(transactionContext.Notify(msg)).To(BeTrue())
+				}
+				Expect(transactionContext.Notify(parts[3])).To(BeFalse())
+				Expect(transactionContext.Responses()).To(Equal(parts))
+			})
+		})
+
+		It("does not collect when collection is disabled", func() {
+			transactionContext = &chaincode.TransactionContext{ResponseNotifier: make(chan *pb.ChaincodeMessage, 1)}
+			Expect(transactionContext.Notify(parts[0])).To(BeTrue())
+			Expect(transactionContext.ResponseNotifier).To(Receive(Equal(parts[0])))
+			Expect(transactionContext.Responses()).To(BeNil())
+		})
+	})
+
+	Describe("Guard", func() {
+		BeforeEach(func() {
+			transactionContext = chaincode.NewTransactionContext("chain-id", "tx-id", nil, nil)
+			transactionContext.RegisterIterator("query-id", resultsIterator)
+		})
+
+		It("returns the error from fn", func() {
+			err := transactionContext.Guard(func() error { return errors.New("fn-failed") })
+			Expect(err).To(MatchError("fn-failed"))
+			Expect(transactionContext.ResponseNotifier).NotTo(Receive())
+			Expect(resultsIterator.CloseCallCount()).To(Equal(0))
+		})
+
+		It("returns nil when fn succeeds", func() {
+			err := transactionContext.Guard(func() error { return nil })
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when fn panics", func() {
+			It("returns an error", func() {
+				err := transactionContext.Guard(func() error { panic("boom") })
+				Expect(err).To(MatchError("txid: tx-id(chain-id): recovered from panic: boom"))
+			})
+
+			It("sends an error response", func() {
+				transactionContext.Guard(func() error { panic("boom") })
+
+				var msg *pb.ChaincodeMessage
+				Expect(transactionContext.ResponseNotifier).To(Receive(&msg))
+				Expect(msg.Type).To(Equal(pb.ChaincodeMessage_ERROR))
+				Expect(msg.Txid).To(Equal("tx-id"))
+				Expect(msg.ChannelId).To(Equal("chain-id"))
+				Expect(string(msg.Payload)).To(Equal("txid: tx-id(chain-id): recovered from panic: boom"))
+			})
+
+			It("closes the query iterators", func() {
+				transactionContext.Guard(func() error { panic("boom") })
+				Expect(resultsIterator.CloseCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("WaitForResponse", func() {
+		BeforeEach(func() {
+			transactionContext.ResponseNotifier = make(chan *pb.ChaincodeMessage, 1)
+		})
+
+		It("returns the delivered message", func() {
+			msg := &pb.ChaincodeMessage{Txid: "tx-id"}
+			transactionContext.ResponseNotifier <- msg
+
+			resp, err := transactionContext.WaitForResponse(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp).To(Equal(msg))
+		})
+
+		Context("when the context is cancelled before a message is delivered", func() {
+			It("returns the context error", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				resp, err := transactionContext.WaitForResponse(ctx)
+				Expect(err).To(Equal(context.Canceled))
+				Expect(resp).To(BeNil())
+			})
+		})
+	})
+
+	Describe("RegisterIterator", func() {
 		var iter1, iter2 *mock.ResultsIterator
 
 		BeforeEach(func() {
@@ -35,46 +237,256 @@ var _ = Describe("TransactionContext", func() {
 		})
 
 		It("stores a references to the results iterator", func() {
-			transactionContext.InitializeQueryContext("query-id-1", iter1)
-			transactionContext.InitializeQueryContext("query-id-2", iter2)
+			transactionContext.RegisterIterator("query-id-1", iter1)
+			transactionContext.RegisterIterator("query-id-2", iter2)
 
-			iter := transactionContext.GetQueryIterator("query-id-1")
+			iter := transactionContext.GetIterator("query-id-1")
 			Expect(iter).To(Equal(iter1))
-			iter = transactionContext.GetQueryIterator("query-id-2")
+			iter = transactionContext.GetIterator("query-id-2")
 			Expect(iter).To(Equal(iter2))
 		})
 
 		It("populates a pending query result", func() {
-			transactionContext.InitializeQueryContext("query-id", iter1)
+			transactionContext.RegisterIterator("query-id", iter1)
 			pqr := transactionContext.GetPendingQueryResult("query-id")
 
 			Expect(pqr).To(Equal(&chaincode.PendingQueryResult{}))
 		})
+
+		Context("when an iterator is already registered for the query ID", func() {
+			BeforeEach(func() {
+				err := transactionContext.RegisterIterator("query-id", iter1)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error and keeps the first iterator", func() {
+				err := transactionContext.RegisterIterator("query-id", iter2)
+				Expect(err).To(MatchError("query iterator query-id is already registered"))
+
+				Expect(transactionContext.GetIterator("query-id")).To(Equal(iter1))
+				Expect(transactionContext.GetPendingQueryResult("query-id")).NotTo(BeNil())
+				Expect(iter1.CloseCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the maximum number of query iterators is open", func() {
+			BeforeEach(func() {
+				var err error
+				transactionContext, err = chaincode.NewTransactionContexts(0, 2).Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = transactionContext.RegisterIterator("query-id-1", iter1)
+				Expect(err).NotTo(HaveOccurred())
+				err = transactionContext.RegisterIterator("query-id-2", iter2)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error", func() {
+				err := transactionContext.RegisterIterator("query-id-3", resultsIterator)
+				Expect(err).To(MatchError("too many open query iterators, close some before opening more"))
+				Expect(transactionContext.GetIterator("query-id-3")).To(BeNil())
+				Expect(transactionContext.GetPendingQueryResult("query-id-3")).To(BeNil())
+			})
+
+			It("allows a new iterator once one has been cleaned up", func() {
+				transactionContext.CleanupQueryContext("query-id-1")
+				err := transactionContext.RegisterIterator("query-id-3", resultsIterator)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
 	})
 
-	Describe("GetQueryIterator", func() {
+	Describe("RegisterClientIterator", func() {
+		var iter1, iter2 *mock.ResultsIterator
+
+		BeforeEach(func() {
+			iter1 = &mock.ResultsIterator{}
+			iter2 = &mock.ResultsIterator{}
+		})
+
+		It("registers the iterator under a unique internal ID", func() {
+			queryID, err := transactionContext.RegisterClientIterator("client-id", iter1)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(queryID).NotTo(Equal("client-id"))
+			Expect(transactionContext.OpenIteratorIDs()).To(Equal([]string{queryID}))
+			Expect(transactionContext.GetIterator(queryID)).To(Equal(iter1))
+			Expect(transactionContext.GetIterator("client-id")).To(Equal(iter1))
+			Expect(transactionContext.GetPendingQueryResult("client-id")).To(Equal(&chaincode.PendingQueryResult{}))
+		})
+
+		It("does not lose the first iterator registered with the same client ID", func() {
+			queryID1, err := transactionContext.RegisterClientIterator("client-id", iter1)
+			Expect(err).NotTo(HaveOccurred())
+			queryID2, err := transactionContext.RegisterClientIterator("client-id", iter2)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(queryID1).NotTo(Equal(queryID2))
+			Expect(transactionContext.GetIterator(queryID1)).To(Equal(iter1))
+			Expect(transactionContext.GetIterator(queryID2)).To(Equal(iter2))
+			Expect(transactionContext.GetIterator("client-id")).To(Equal(iter2))
+			Expect(iter1.CloseCallCount()).To(Equal(0))
+
+			iter1.NextReturns(&queryresult.KV{Key: "key1"}, nil)
+			result, err := transactionContext.GetIterator(queryID1).Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(&queryresult.KV{Key: "key1"}))
+		})
+
+		It("cleans up the most recent iterator for the client ID", func() {
+			queryID1, err := transactionContext.RegisterClientIterator("client-id", iter1)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = transactionContext.RegisterClientIterator("client-id", iter2)
+			Expect(err).NotTo(HaveOccurred())
+
+			transactionContext.CleanupQueryContext("client-id")
+
+			Expect(iter2.CloseCallCount()).To(Equal(1))
+			Expect(iter1.CloseCallCount()).To(Equal(0))
+			Expect(transactionContext.OpenIteratorIDs()).To(Equal([]string{queryID1}))
+			Expect(transactionContext.GetIterator("client-id")).To(BeNil())
+		})
+
+		It("forgets the client ID when the iterator is removed by its internal ID", func() {
+			queryID, err := transactionContext.RegisterClientIterator("client-id", iter1)
+			Expect(err).NotTo(HaveOccurred())
+
+			transactionContext.RemoveIterator(queryID)
+			Expect(transactionContext.GetIterator("client-id")).To(BeNil())
+			Expect(transactionContext.GetPendingQueryResult("client-id")).To(BeNil())
+		})
+
+		It("resolves the client ID in the query accessors", func() {
+			queryID, err := transactionContext.RegisterClientIterator("client-id", iter1)
+			Expect(err).NotTo(HaveOccurred())
+
+			transactionContext.SetBookmark("client-id", "bookmark")
+			Expect(transactionContext.GetBookmark(queryID)).To(Equal("bookmark"))
+			Expect(transactionContext.GetBookmark("client-id")).To(Equal("bookmark"))
+			Expect(transactionContext.QueryType("client-id")).To(Equal(transactionContext.QueryType(queryID)))
+		})
+
+		It("discards the bookmark of the client ID when the iterator is cleaned up", func() {
+			queryID, err := transactionContext.RegisterClientIterator("client-id", iter1)
+			Expect(err).NotTo(HaveOccurred())
+			transactionContext.SetBookmark("client-id", "bookmark")
+
+			transactionContext.CleanupQueryContext("client-id")
+			Expect(transactionContext.GetBookmark(queryID)).To(BeEmpty())
+			Expect(transactionContext.GetBookmark("client-id")).To(BeEmpty())
+		})
+
+		It("skips internal IDs that are already registered", func() {
+			Expect(transactionContext.RegisterIterator("client-id#1", iter1)).To(Succeed())
+
+			queryID, err := transactionContext.RegisterClientIterator("client-id", iter2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queryID).To(Equal("client-id#2"))
+			Expect(transactionContext.GetIterator("client-id#1")).To(Equal(iter1))
+		})
+
+		Context("when the maximum number of query iterators is open", func() {
+			BeforeEach(func() {
+				var err error
+				transactionContext, err = chaincode.NewTransactionContexts(0, 1).Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = transactionContext.RegisterClientIterator("client-id", iter1)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error and keeps the client ID mapped to the registered iterator", func() {
+				_, err := transactionContext.RegisterClientIterator("client-id", iter2)
+				Expect(err).To(Equal(chaincode.ErrTooManyQueryIterators))
+				Expect(transactionContext.GetIterator("client-id")).To(Equal(iter1))
+			})
+		})
+	})
+
+	Describe("OpenRegisteredIterator", func() {
+		It("opens the iterator and registers it for the query ID", func() {
+			iter, err := transactionContext.OpenRegisteredIterator("query-id", func() (commonledger.ResultsIterator, error) {
+				return resultsIterator, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(iter).To(Equal(resultsIterator))
+			Expect(transactionContext.GetIterator("query-id")).To(Equal(iter))
+			Expect(transactionContext.GetPendingQueryResult("query-id")).To(Equal(&chaincode.PendingQueryResult{}))
+		})
+
+		Context("when the iterator cannot be opened", func() {
+			It("returns the error and registers nothing", func() {
+				iter, err := transactionContext.OpenRegisteredIterator("query-id", func() (commonledger.ResultsIterator, error) {
+					return nil, errors.New("open-failed")
+				})
+				Expect(err).To(MatchError("open-failed"))
+				Expect(iter).To(BeNil())
+
+				Expect(transactionContext.GetIterator("query-id")).To(BeNil())
+				Expect(transactionContext.GetPendingQueryResult("query-id")).To(BeNil())
+				Expect(transactionContext.OpenIteratorIDs()).To(BeEmpty())
+			})
+		})
+
+		Context("when the iterator cannot be registered", func() {
+			var iter1 *mock.ResultsIterator
+
+			BeforeEach(func() {
+				iter1 = &mock.ResultsIterator{}
+				err := transactionContext.RegisterIterator("query-id", iter1)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("closes the opened iterator and keeps the registered one", func() {
+				iter, err := transactionContext.OpenRegisteredIterator("query-id", func() (commonledger.ResultsIterator, error) {
+					return resultsIterator, nil
+				})
+				Expect(err).To(MatchError("query iterator query-id is already registered"))
+				Expect(iter).To(BeNil())
+
+				Expect(resultsIterator.CloseCallCount()).To(Equal(1))
+				Expect(transactionContext.GetIterator("query-id")).To(Equal(iter1))
+				Expect(iter1.CloseCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("GetIterator", func() {
 		It("returns the results iteraterator provided to initialize query context", func() {
-			transactionContext.InitializeQueryContext("query-id", resultsIterator)
-			iter := transactionContext.GetQueryIterator("query-id")
+			transactionContext.RegisterIterator("query-id", resultsIterator)
+			iter := transactionContext.GetIterator("query-id")
 			Expect(iter).To(Equal(resultsIterator))
 
-			transactionContext.InitializeQueryContext("query-with-nil", nil)
-			iter = transactionContext.GetQueryIterator("query-with-nil")
+			transactionContext.RegisterIterator("query-with-nil", nil)
+			iter = transactionContext.GetIterator("query-with-nil")
 			Expect(iter).To(BeNil())
 		})
 
 		Context("when an unknown query id is used", func() {
 			It("returns a nil query iterator", func() {
-				iter := transactionContext.GetQueryIterator("unknown-id")
+				iter := transactionContext.GetIterator("unknown-id")
 				Expect(iter).To(BeNil())
 			})
 		})
 	})
 
+	Describe("InitializeQueryContext and GetQueryIterator", func() {
+		It("register and return iterators like RegisterIterator and GetIterator", func() {
+			err := transactionContext.InitializeQueryContext("query-id", resultsIterator)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(transactionContext.GetIterator("query-id")).To(Equal(resultsIterator))
+			Expect(transactionContext.GetQueryIterator("query-id")).To(Equal(resultsIterator))
+			Expect(transactionContext.GetPendingQueryResult("query-id")).NotTo(BeNil())
+
+			err = transactionContext.InitializeQueryContext("query-id", resultsIterator)
+			Expect(err).To(MatchError("query iterator query-id is already registered"))
+		})
+	})
+
 	Describe("GetPendingQueryResult", func() {
 		Context("when a query context has been initialized", func() {
 			BeforeEach(func() {
-				transactionContext.InitializeQueryContext("query-id", nil)
+				transactionContext.RegisterIterator("query-id", nil)
 			})
 
 			It("returns a non-nil pending query result", func() {
@@ -91,19 +503,209 @@ var _ = Describe("TransactionContext", func() {
 		})
 	})
 
+	Describe("FlushPendingResults", func() {
+		BeforeEach(func() {
+			transactionContext.RegisterIterator("query-id", resultsIterator)
+			pqr := transactionContext.GetPendingQueryResult("query-id")
+			for i := 0; i < 3; i++ {
+				err := pqr.Add(&queryresult.KV{Key: fmt.Sprintf("key-%d", i)})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("returns the buffered results in the order they were produced", func() {
+			results, err := transactionContext.FlushPendingResults("query-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+			for i, result := range results {
+				kv := &queryresult.KV{}
+				Expect(proto.Unmarshal(result.ResultBytes, kv)).To(Succeed())
+				Expect(kv.Key).To(Equal(fmt.Sprintf("key-%d", i)))
+			}
+		})
+
+		It("empties the buffer and leaves the iterator open", func() {
+			_, err := transactionContext.FlushPendingResults("query-id")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(transactionContext.GetPendingQueryResult("query-id").Size()).To(Equal(0))
+			Expect(transactionContext.GetIterator("query-id")).To(Equal(resultsIterator))
+			Expect(resultsIterator.CloseCallCount()).To(Equal(0))
+
+			results, err := transactionContext.FlushPendingResults("query-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(BeEmpty())
+		})
+
+		Context("when the query ID is not registered", func() {
+			It("returns an error", func() {
+				_, err := transactionContext.FlushPendingResults("missing-query-id")
+				Expect(err).To(MatchError("query iterator missing-query-id does not exist"))
+			})
+		})
+	})
+
+	Describe("Clone", func() {
+		BeforeEach(func() {
+			var err error
+			transactionContext, err = chaincode.NewTransactionContexts(0, 0).Create(context.Background(), "chain-id", "tx-id", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			transactionContext.SetLabel("tenant", "org1")
+		})
+
+		It("copies the metadata of the context", func() {
+			info := transactionContext.Clone()
+			Expect(info.ChainID).To(Equal("chain-id"))
+			Expect(info.TxID).To(Equal("tx-id"))
+			Expect(info.Created).NotTo(BeZero())
+			Expect(info.Labels).To(Equal(map[string]string{"tenant": "org1"}))
+		})
+
+		It("is not affected by later changes to the context", func() {
+			info := transactionContext.Clone()
+
+			transactionContext.SetLabel("tenant", "org2")
+			transactionContext.SetLabel("region", "eu")
+			Expect(transactionContext.RegisterIterator("query-id", resultsIterator)).To(Succeed())
+
+			Expect(info.Labels).To(Equal(map[string]string{"tenant": "org1"}))
+			Expect(info.QueryIterators).To(Equal(0))
+			Expect(info.PendingQueryResults).To(Equal(0))
+		})
+
+		It("does not share labels with the context", func() {
+			info := transactionContext.Clone()
+			info.Labels["tenant"] = "changed"
+
+			value, ok := transactionContext.Label("tenant")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("org1"))
+		})
+	})
+
+	Describe("Logger", func() {
+		var backend *logging.MemoryBackend
+
+		BeforeEach(func() {
+			backend = logging.NewMemoryBackend(10)
+			logging.SetBackend(backend)
+			transactionContext = chaincode.NewTransactionContext("chain-id", "0123456789abcdef", nil, nil)
+		})
+
+		AfterEach(func() {
+			flogging.Reset()
+		})
+
+		It("tags messages with the chain and transaction ID", func() {
+			transactionContext.Logger().Warningf("something %s happened", "bad")
+
+			record := backend.Head().Record
+			Expect(record.Module).To(Equal("chaincode"))
+			Expect(record.Level).To(Equal(logging.WARNING))
+			Expect(record.Message()).To(Equal("[chain-id][01234567] something bad happened"))
+		})
+
+		It("is available on contexts created by a registry", func() {
+			txContext, err := chaincode.NewTransactionContexts(0, 0).Create(context.Background(), "other-chain", "tx-id", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			txContext.Logger().Errorf("failed with %d%%", 50)
+			Expect(backend.Head().Record.Message()).To(Equal("[other-chain][tx-id] failed with 50%"))
+		})
+
+		It("does not log messages below the module level", func() {
+			logging.SetLevel(logging.INFO, "chaincode")
+
+			transactionContext.Logger().Debugf("hidden")
+			Expect(backend.Head()).To(BeNil())
+		})
+	})
+
+	Describe("WrapErr", func() {
+		BeforeEach(func() {
+			transactionContext = chaincode.NewTransactionContext("chain-id", "tx-id", nil, nil)
+		})
+
+		It("annotates the error with the operation, chain, and transaction ID", func() {
+			err := transactionContext.WrapErr(errors.New("boom"), "get state")
+			Expect(err).To(MatchError("txid: tx-id(chain-id): get state failed: boom"))
+		})
+
+		It("preserves the original error as the cause", func() {
+			original := errors.New("boom")
+			err := transactionContext.WrapErr(original, "put state")
+			Expect(errors.Cause(err)).To(BeIdenticalTo(original))
+		})
+
+		It("returns nil when there is no error", func() {
+			Expect(transactionContext.WrapErr(nil, "get state")).To(BeNil())
+		})
+	})
+
+	Describe("OpenIteratorIDs", func() {
+		It("returns the IDs of the registered iterators", func() {
+			transactionContext.RegisterIterator("query-id-2", &mock.ResultsIterator{})
+			transactionContext.RegisterIterator("query-id-1", &mock.ResultsIterator{})
+			transactionContext.RegisterIterator("query-id-3", &mock.ResultsIterator{})
+			transactionContext.CleanupQueryContext("query-id-3")
+
+			Expect(transactionContext.OpenIteratorIDs()).To(Equal([]string{"query-id-1", "query-id-2"}))
+		})
+
+		Context("when no iterators are registered", func() {
+			It("returns an empty list", func() {
+				Expect(transactionContext.OpenIteratorIDs()).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("RemoveIterator", func() {
+		It("removes references to the iterator and results", func() {
+			transactionContext.RegisterIterator("query-id", resultsIterator)
+			transactionContext.RemoveIterator("query-id")
+
+			Expect(transactionContext.GetIterator("query-id")).To(BeNil())
+			Expect(transactionContext.GetPendingQueryResult("query-id")).To(BeNil())
+		})
+
+		It("does not close the query iterator", func() {
+			transactionContext.RegisterIterator("query-id", resultsIterator)
+			transactionContext.RemoveIterator("query-id")
+
+			Expect(resultsIterator.CloseCallCount()).To(Equal(0))
+		})
+
+		It("leaves other iterators registered", func() {
+			otherIterator := &mock.ResultsIterator{}
+			transactionContext.RegisterIterator("query-id", resultsIterator)
+			transactionContext.RegisterIterator("other-query-id", otherIterator)
+			transactionContext.RemoveIterator("query-id")
+
+			Expect(transactionContext.GetIterator("other-query-id")).To(Equal(otherIterator))
+			Expect(transactionContext.GetPendingQueryResult("other-query-id")).NotTo(BeNil())
+		})
+
+		Context("when the query ID is not registered", func() {
+			It("keeps calm and carries on", func() {
+				transactionContext.RemoveIterator("query-id")
+				Expect(transactionContext.GetIterator("query-id")).To(BeNil())
+			})
+		})
+	})
+
 	Describe("CleanupQueryContext", func() {
 		It("removes references to the the iterator and results", func() {
-			transactionContext.InitializeQueryContext("query-id", resultsIterator)
+			transactionContext.RegisterIterator("query-id", resultsIterator)
 			transactionContext.CleanupQueryContext("query-id")
 
-			iter := transactionContext.GetQueryIterator("query-id")
+			iter := transactionContext.GetIterator("query-id")
 			Expect(iter).To(BeNil())
 			pqr := transactionContext.GetPendingQueryResult("query-id")
 			Expect(pqr).To(BeNil())
 		})
 
 		It("closes the query iterator", func() {
-			transactionContext.InitializeQueryContext("query-id", resultsIterator)
+			transactionContext.RegisterIterator("query-id", resultsIterator)
 			transactionContext.CleanupQueryContext("query-id")
 
 			Expect(resultsIterator.CloseCallCount()).To(Equal(1))
@@ -111,7 +713,7 @@ var _ = Describe("TransactionContext", func() {
 
 		Context("when the query iterator is nil", func() {
 			It("keeps calm and carries on", func() {
-				transactionContext.InitializeQueryContext("query-id", nil)
+				transactionContext.RegisterIterator("query-id", nil)
 				transactionContext.CleanupQueryContext("query-id")
 
 				pqr := transactionContext.GetPendingQueryResult("query-id")
@@ -120,13 +722,110 @@ var _ = Describe("TransactionContext", func() {
 		})
 	})
 
+	Describe("Labels", func() {
+		It("reports labels that have not been set as missing", func() {
+			_, ok := transactionContext.Label("workload")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("returns the label set for the key", func() {
+			transactionContext.SetLabel("workload", "batch")
+			transactionContext.SetLabel("tenant", "org1")
+
+			value, ok := transactionContext.Label("workload")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("batch"))
+			value, ok = transactionContext.Label("tenant")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("org1"))
+		})
+
+		It("overwrites an existing label", func() {
+			transactionContext.SetLabel("workload", "batch")
+			transactionContext.SetLabel("workload", "interactive")
+
+			value, ok := transactionContext.Label("workload")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("interactive"))
+		})
+	})
+
+	Describe("Values", func() {
+		type pluginKey string
+		type otherPluginKey string
+
+		It("returns nil for keys without a value", func() {
+			Expect(transactionContext.Value(pluginKey("state"))).To(BeNil())
+		})
+
+		It("keeps values with distinct key types apart", func() {
+			transactionContext.WithValue(pluginKey("state"), 1)
+			transactionContext.WithValue(otherPluginKey("state"), "two")
+
+			Expect(transactionContext.Value(pluginKey("state"))).To(Equal(1))
+			Expect(transactionContext.Value(otherPluginKey("state"))).To(Equal("two"))
+			Expect(transactionContext.Value("state")).To(BeNil())
+		})
+
+		It("overwrites an existing value", func() {
+			transactionContext.WithValue(pluginKey("state"), 1)
+			transactionContext.WithValue(pluginKey("state"), 2)
+
+			Expect(transactionContext.Value(pluginKey("state"))).To(Equal(2))
+		})
+
+		It("panics when the key is nil", func() {
+			Expect(func() { transactionContext.WithValue(nil, 1) }).To(Panic())
+		})
+
+		It("panics when the key is not comparable", func() {
+			Expect(func() { transactionContext.WithValue([]byte("key"), 1) }).To(Panic())
+		})
+
+		It("can be used concurrently", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					transactionContext.WithValue(i, i)
+					Expect(transactionContext.Value(i)).To(Equal(i))
+				}(i)
+			}
+			wg.Wait()
+		})
+	})
+
+	Describe("Bookmarks", func() {
+		It("returns an empty bookmark when none has been set", func() {
+			Expect(transactionContext.GetBookmark("query-id")).To(BeEmpty())
+		})
+
+		It("returns the bookmark set for the query", func() {
+			transactionContext.SetBookmark("query-id", "bookmark-1")
+			transactionContext.SetBookmark("other-query-id", "bookmark-2")
+
+			Expect(transactionContext.GetBookmark("query-id")).To(Equal("bookmark-1"))
+			Expect(transactionContext.GetBookmark("other-query-id")).To(Equal("bookmark-2"))
+		})
+
+		It("is removed when the query context is cleaned up", func() {
+			transactionContext.RegisterIterator("query-id", resultsIterator)
+			transactionContext.SetBookmark("query-id", "bookmark-1")
+			transactionContext.CleanupQueryContext("query-id")
+
+			Expect(transactionContext.GetBookmark("query-id")).To(BeEmpty())
+		})
+	})
+
 	Describe("CloseQueryIterators", func() {
 		var resultsIterators []*mock.ResultsIterator
 
 		BeforeEach(func() {
 			for i := 0; i < 5; i++ {
 				resultsIterators = append(resultsIterators, &mock.ResultsIterator{})
-				transactionContext.InitializeQueryContext(fmt.Sprintf("query-id-%d", i+1), resultsIterators[i])
+				transactionContext.RegisterIterator(fmt.Sprintf("query-id-%d", i+1), resultsIterators[i])
 			}
 		})
 
@@ -137,4 +836,34 @@ var _ = Describe("TransactionContext", func() {
 			}
 		})
 	})
+
+	Context("when queries run concurrently", func() {
+		It("safely registers, reads, and removes iterators", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(queryID string) {
+					defer wg.Done()
+					defer GinkgoRecover()
+
+					err := transactionContext.RegisterIterator(queryID, &mock.ResultsIterator{})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(transactionContext.GetIterator(queryID)).NotTo(BeNil())
+
+					pending := transactionContext.GetPendingQueryResult(queryID)
+					Expect(pending.Add(&queryresult.KV{Key: queryID})).To(Succeed())
+					transactionContext.SetBookmark(queryID, queryID)
+					transactionContext.OpenIteratorIDs()
+
+					results, err := transactionContext.FlushPendingResults(queryID)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(results).To(HaveLen(1))
+					transactionContext.CleanupQueryContext(queryID)
+				}(fmt.Sprintf("query-id-%d", i))
+			}
+			wg.Wait()
+
+			Expect(transactionContext.OpenIteratorIDs()).To(BeEmpty())
+		})
+	})
 })
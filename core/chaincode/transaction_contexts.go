@@ -7,11 +7,21 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	commonledger "github.com/hyperledger/fabric/common/ledger"
+	"github.com/hyperledger/fabric/common/util"
 	"github.com/hyperledger/fabric/core/ledger"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
@@ -26,53 +36,1042 @@ const (
 	// HistoryQueryExecutorKey is the context key used to provide a
 	// ledger.HistoryQueryExecutor from the endorser to the chaincode.
 	HistoryQueryExecutorKey key = "historyqueryexecutorkey"
+
+	// ReadOnlyKey is the context key used to mark a transaction context as
+	// read-only. State writes are rejected on read-only contexts.
+	ReadOnlyKey key = "readonlykey"
+
+	// QueryOnlyKey is the context key used to mark a transaction context as
+	// serving a pure query flow. Query-only contexts are not expected to carry
+	// a transaction simulator, so its absence is treated as intentional.
+	QueryOnlyKey key = "queryonlykey"
+
+	// PriorityKey is the context key used to provide the Priority of a
+	// transaction context. Contexts without a priority use PriorityNormal.
+	PriorityKey key = "prioritykey"
+
+	// SpanKey is the context key used to provide the Span under which the
+	// work of a transaction is traced. The span is finished when the
+	// transaction context is removed from the registry.
+	SpanKey key = "spankey"
 )
 
+// ErrTooManyContexts is returned by Create when the maximum number of active
+// transaction contexts has been reached.
+var ErrTooManyContexts = errors.New("too many active transaction contexts")
+
+// ErrChannelQuotaExceeded is returned by Create when a channel has reached
+// the maximum number of active transaction contexts allowed per channel.
+var ErrChannelQuotaExceeded = errors.New("too many active transaction contexts for channel")
+
+// ErrContextExists is returned when a transaction context is already
+// registered for a chain and transaction ID.
+type ErrContextExists struct {
+	ChainID string
+	TxID    string
+}
+
+func (e *ErrContextExists) Error() string {
+	return fmt.Sprintf("txid: %s(%s) exists", e.TxID, e.ChainID)
+}
+
+// ErrContextNotFound is returned by GetErr when no transaction context is
+// registered for a chain and transaction ID.
+type ErrContextNotFound struct {
+	ChainID string
+	TxID    string
+}
+
+func (e *ErrContextNotFound) Error() string {
+	return fmt.Sprintf("txid: %s(%s) does not exist", e.TxID, e.ChainID)
+}
+
+// ErrTransactionTimeout is reported by a TransactionContext that was removed
+// because it exceeded the maximum transaction duration.
+var ErrTransactionTimeout = errors.New("transaction exceeded maximum duration")
+
+// ErrRegistryClosed is returned when a transaction context is created after
+// the registry has been closed.
+var ErrRegistryClosed = errors.New("transaction context registry is closed")
+
+// ErrRegistryPaused is returned when a transaction context is created while
+// the registry is paused.
+var ErrRegistryPaused = errors.New("transaction context registry is paused")
+
+// TransactionContextMetrics is notified of transaction context lifecycle
+// and query events.
+type TransactionContextMetrics interface {
+	// ContextCreated is called when a transaction context is created.
+	ContextCreated(chainID string)
+	// ContextDeleted is called when a transaction context is removed from the
+	// registry with the amount of time the context was active.
+	ContextDeleted(chainID string, duration time.Duration)
+	// QueryBatch is called with the number of results in each batch returned
+	// from a query iterator.
+	QueryBatch(chainID string, size int)
+	// IteratorClosed is called when a query iterator is closed with the amount
+	// of time the iterator was open.
+	IteratorClosed(chainID string, duration time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ContextCreated(string)                {}
+func (noopMetrics) ContextDeleted(string, time.Duration) {}
+func (noopMetrics) QueryBatch(string, int)               {}
+func (noopMetrics) IteratorClosed(string, time.Duration) {}
+
 // TransactionContexts maintains active transaction contexts for a Handler.
 type TransactionContexts struct {
+	// Metrics is notified when contexts are created and deleted.
+	Metrics TransactionContextMetrics
+	// ResponseNotifierSize is the buffer size of the ResponseNotifier channel
+	// of new contexts. Values less than one use a buffer size of one.
+	ResponseNotifierSize int
+	// RequireTxSimulator causes creation to fail when the provided context
+	// does not carry a transaction simulator. Query-only contexts are exempt.
+	RequireTxSimulator bool
+	// StrictQuerySources causes the handler to reject queries on contexts
+	// that lack the data source required by the query type: a transaction
+	// simulator for range and rich queries and a history query executor for
+	// history queries.
+	StrictQuerySources bool
+	// AllowEmptyChainID permits contexts for chainless transactions, such as
+	// proposals to CSCC, that are executed without a channel. An empty
+	// transaction ID is always rejected.
+	AllowEmptyChainID bool
+	// MaxTransactionDuration is the maximum amount of time a context may
+	// remain active. Contexts that exceed it are closed, removed, and sent an
+	// error on their ResponseNotifier. A value of zero means there is no limit.
+	MaxTransactionDuration time.Duration
+	// MaxBytesRead is the maximum number of bytes of query results a single
+	// transaction may read. A value of zero means there is no limit.
+	MaxBytesRead int64
+	// MemoryBudget is the maximum number of bytes of query results read and
+	// buffered and of keys and values written that a single transaction may
+	// hold. A value of zero means there is no limit.
+	MemoryBudget int64
+	// RateLimiter limits the rate at which each proposal creator may create
+	// contexts. A nil RateLimiter does not limit creation.
+	RateLimiter *CreatorRateLimiter
+	// MaxConcurrentReads is the maximum number of query iterators of all
+	// contexts that may be advanced at the same time. Iterators wait for a
+	// free slot before each call to Next so that one transaction cannot
+	// monopolize the ledger's read capacity. A value of zero means there is no
+	// limit. It must be set before contexts are created.
+	MaxConcurrentReads int
+	// IteratorWrapper, when not nil, wraps every results iterator registered
+	// with a context so that calls to the iterator can be instrumented.
+	// Wrappers that hide a BookmarkedIterator should implement GetBookmark.
+	IteratorWrapper func(commonledger.ResultsIterator) commonledger.ResultsIterator
+	// LongLivedWarnAge is the age after which a context is logged as
+	// long-lived by WarnLongLived. A value of zero disables the warnings.
+	LongLivedWarnAge time.Duration
+	// LongLivedWarnInterval is how often a long-lived context is logged again.
+	// A value of zero logs each context only once.
+	LongLivedWarnInterval time.Duration
+	// EventBufferSize is the number of events buffered for each subscriber
+	// before further events are dropped. Values less than one use a buffer
+	// size of one.
+	EventBufferSize int
+	// IteratorReusePolicy determines what happens when a query iterator is
+	// registered with a query ID that is already in use. The default,
+	// IteratorReuseError, rejects the new iterator.
+	IteratorReusePolicy IteratorReusePolicy
+	// TrackRWSetStats causes the simulators of new contexts to count the keys
+	// they read and write. The counts are reported by RWSetStats.
+	TrackRWSetStats bool
+	// CacheReads causes the simulators of new contexts to serve repeated
+	// reads of a key from memory for the lifetime of the transaction. A
+	// cached value is discarded when the key is written through the
+	// simulator.
+	CacheReads bool
+	// FlushPendingOnClose causes Close to attempt to deliver the pending query
+	// results of each context on its ResponseNotifier before the iterators
+	// are closed. Delivery does not block; results that do not fit in the
+	// notifier are dropped.
+	FlushPendingOnClose bool
+	// MaxContextsPerChannel is the maximum number of active contexts a single
+	// channel may hold. A value of zero means there is no per-channel limit.
+	MaxContextsPerChannel int
+	// EvictionCostWeights, when set, causes Evict to evict the contexts with
+	// the highest resource cost first within a priority rather than the
+	// oldest.
+	EvictionCostWeights *CostWeights
+	// QuarantineSize is the number of contexts retained by Quarantine for
+	// post-mortem inspection. Values less than one retain 16 contexts.
+	QuarantineSize int
+	// RecentWritesWindow is how long the keys written by committed
+	// transactions are remembered by RecordCommittedWrites for WouldConflict.
+	// A value of zero disables the recent writes index.
+	RecentWritesWindow time.Duration
+	// MinContextsPerChannel is the number of contexts guaranteed to each
+	// channel with at least one active context when the registry limits the
+	// total number of contexts. Contexts are not created when doing so would
+	// take a slot guaranteed to another active channel. The per-channel
+	// limits must be set before contexts are created.
+	MinContextsPerChannel int
+
+	// channelCounts holds the number of contexts of each channel; drained
+	// holds, for each channel with WaitChainDrained callers, the channel that
+	// is closed when the count of the channel drops to zero
+	channelsMutex sync.Mutex
+	channelCounts map[string]int
+	drained       map[string]chan struct{}
+
+	// quarantine holds the metadata of quarantined contexts, oldest first
+	quarantineMutex sync.Mutex
+	quarantine      []QuarantinedContext
+
+	// chaincodeStats holds the context counts of each chaincode
+	chaincodesMutex sync.Mutex
+	chaincodeStats  map[string]*ChaincodeStats
+
+	// recentWrites holds, for each channel, the time at which each key was
+	// last written by a committed transaction
+	recentWritesMutex sync.Mutex
+	recentWrites      map[string]map[string]time.Time
+
+	// slotFreed is closed and replaced when a context is removed while
+	// CreateWait callers are waiting
+	slotMutex sync.Mutex
+	slotFreed chan struct{}
+	waiters   int32
+
+	subscribersMutex sync.RWMutex
+	subscribers      map[chan ContextEvent]struct{}
+
+	evictCallbacksMutex sync.RWMutex
+	evictCallbacks      []func(chainID, txID string, reason EvictReason)
+
+	shards            []contextShard
+	count             int32
+	created           uint64
+	deleted           uint64
+	closing           int32
+	paused            int32
+	maxContexts       int
+	maxQueryIterators int
+	admission         []AdmissionFunc
+	readSlotsOnce     sync.Once
+	readSlots         chan struct{}
+	now               func() time.Time
+}
+
+// AdmissionFunc decides whether a transaction context may be created for the
+// specified chain, transaction ID, and proposals. A non-nil error vetoes the
+// creation.
+type AdmissionFunc func(chainID, txID string, signedProp *pb.SignedProposal, prop *pb.Proposal) error
+
+// contextShardCount is the number of buckets transaction contexts are spread
+// across so that unrelated transactions do not contend for the same lock.
+const contextShardCount = 32
+
+// contextShard holds the transaction contexts that hash to one bucket.
+type contextShard struct {
 	mutex    sync.Mutex
 	contexts map[string]*TransactionContext
 }
 
 // NewTransactionContexts creates a registry for active transaction contexts.
-func NewTransactionContexts() *TransactionContexts {
+// The registry will hold at most maxContexts active contexts and each context
+// will allow at most maxQueryIterators open query iterators. A value of zero
+// means there is no limit. The admission functions are run in order before
+// each context is created and the first error aborts the creation.
+func NewTransactionContexts(maxContexts, maxQueryIterators int, admission ...AdmissionFunc) *TransactionContexts {
+	c := newTransactionContexts(maxContexts, maxQueryIterators, contextShardCount, 0)
+	c.admission = admission
+	return c
+}
+
+// NewTransactionContextsWithCapacity creates a registry like
+// NewTransactionContexts that is pre-sized to hold capacity contexts without
+// growing.
+func NewTransactionContextsWithCapacity(maxContexts, maxQueryIterators, capacity int, admission ...AdmissionFunc) *TransactionContexts {
+	c := newTransactionContexts(maxContexts, maxQueryIterators, contextShardCount, capacity)
+	c.admission = admission
+	return c
+}
+
+func newTransactionContexts(maxContexts, maxQueryIterators, shardCount, capacity int) *TransactionContexts {
+	shardCapacity := 0
+	if capacity > 0 {
+		shardCapacity = (capacity + shardCount - 1) / shardCount
+	}
+	shards := make([]contextShard, shardCount)
+	for i := range shards {
+		shards[i].contexts = make(map[string]*TransactionContext, shardCapacity)
+	}
 	return &TransactionContexts{
-		contexts: map[string]*TransactionContext{},
+		Metrics:           noopMetrics{},
+		shards:            shards,
+		maxContexts:       maxContexts,
+		maxQueryIterators: maxQueryIterators,
+		now:               time.Now,
 	}
 }
 
-// contextID creates a transaction identifier that is scoped to a chain.
+// validateIDs returns an error when the transaction ID is empty or when the
+// chain ID is empty and chainless contexts are not allowed.
+func (c *TransactionContexts) validateIDs(chainID, txID string) error {
+	if txID == "" {
+		return errors.Errorf("empty transaction ID for chain: %s", chainID)
+	}
+	if chainID == "" && !c.AllowEmptyChainID {
+		return errors.Errorf("empty chain ID for txid: %s", txID)
+	}
+	return nil
+}
+
+// readSemaphore returns the semaphore shared by the iterators of all contexts
+// or nil when reads are not limited.
+func (c *TransactionContexts) readSemaphore() chan struct{} {
+	c.readSlotsOnce.Do(func() {
+		if c.MaxConcurrentReads > 0 {
+			c.readSlots = make(chan struct{}, c.MaxConcurrentReads)
+		}
+	})
+	return c.readSlots
+}
+
+// admit runs the admission functions of the registry in order and returns the
+// first error.
+func (c *TransactionContexts) admit(chainID, txID string, signedProp *pb.SignedProposal, proposal *pb.Proposal) error {
+	for _, admit := range c.admission {
+		if err := admit(chainID, txID, signedProp, proposal); err != nil {
+			return errors.WithMessage(err, fmt.Sprintf("admission denied for txid: %s(%s)", txID, chainID))
+		}
+	}
+	return nil
+}
+
+// contextID creates a transaction identifier that is scoped to a chain. The
+// chain ID is length prefixed so that distinct chain and transaction ID pairs
+// can never produce the same identifier.
 func contextID(chainID, txID string) string {
-	return chainID + txID
+	return strconv.Itoa(len(chainID)) + ":" + chainID + txID
+}
+
+// handleSequence makes the handles of transaction contexts unique when a chain
+// and transaction ID pair is reused.
+var handleSequence uint64
+
+// shard returns the bucket that holds the context with the specified ID.
+func (c *TransactionContexts) shard(ctxID string) *contextShard {
+	return &c.shards[c.shardIndex(ctxID)]
+}
+
+// shardIndex returns the index of the bucket that holds the context with the
+// specified ID. The ID is hashed with 32-bit FNV-1a.
+func (c *TransactionContexts) shardIndex(ctxID string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(ctxID); i++ {
+		h ^= uint32(ctxID[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(c.shards)))
 }
 
 // Create creates a new TransactionContext for the specified chain and
 // transaction ID. An error is returned when a transaction context has already
-// been created for the specified chain and transaction ID.
+// been created for the specified chain and transaction ID or when the maximum
+// number of active contexts has been reached.
+//
+// The context is built without holding the registry lock so that concurrent
+// lookups are not delayed; the check for an existing context is repeated when
+// the new context is stored.
 func (c *TransactionContexts) Create(ctx context.Context, chainID, txID string, signedProp *pb.SignedProposal, proposal *pb.Proposal) (*TransactionContext, error) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	ctxID := contextID(chainID, txID)
+	shard := c.shard(ctxID)
+	if c.lookup(shard, ctxID) != nil {
+		return nil, &ErrContextExists{ChainID: chainID, TxID: txID}
+	}
+
+	txctx, err := c.build(ctx, chainID, txID, signedProp, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := c.store(shard, ctxID, txctx)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, &ErrContextExists{ChainID: chainID, TxID: txID}
+	}
+	return txctx, nil
+}
+
+// CreateWait creates a transaction context like Create. When the maximum
+// number of contexts for the registry or for the channel has been reached,
+// CreateWait blocks until a context is removed and tries again. If ctx is done
+// first, ctx.Err() is returned.
+func (c *TransactionContexts) CreateWait(ctx context.Context, chainID, txID string, signedProp *pb.SignedProposal, proposal *pb.Proposal) (*TransactionContext, error) {
+	atomic.AddInt32(&c.waiters, 1)
+	defer atomic.AddInt32(&c.waiters, -1)
+
+	for {
+		freed := c.slotFreedChannel()
+		txctx, err := c.Create(ctx, chainID, txID, signedProp, proposal)
+		if cause := errors.Cause(err); cause != ErrTooManyContexts && cause != ErrChannelQuotaExceeded {
+			return txctx, err
+		}
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// WaitChainDrained blocks until no transaction contexts remain for the
+// specified chain. Contexts created for the chain while waiting must also
+// complete before it returns. Callers are woken only when the number of
+// contexts of the chain drops to zero. If ctx is done first, ctx.Err() is
+// returned.
+func (c *TransactionContexts) WaitChainDrained(ctx context.Context, chainID string) error {
+	c.channelsMutex.Lock()
+	if c.channelCounts[chainID] == 0 {
+		c.channelsMutex.Unlock()
+		return nil
+	}
+	if c.drained == nil {
+		c.drained = map[string]chan struct{}{}
+	}
+	drained, ok := c.drained[chainID]
+	if !ok {
+		drained = make(chan struct{})
+		c.drained[chainID] = drained
+	}
+	c.channelsMutex.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// slotFreedChannel returns the channel that is closed the next time a context
+// is removed from the registry.
+func (c *TransactionContexts) slotFreedChannel() <-chan struct{} {
+	c.slotMutex.Lock()
+	defer c.slotMutex.Unlock()
+	if c.slotFreed == nil {
+		c.slotFreed = make(chan struct{})
+	}
+	return c.slotFreed
+}
+
+// notifySlotFreed wakes the CreateWait callers waiting for a context to be
+// removed.
+func (c *TransactionContexts) notifySlotFreed() {
+	if atomic.LoadInt32(&c.waiters) == 0 {
+		return
+	}
+	c.slotMutex.Lock()
+	defer c.slotMutex.Unlock()
+	if c.slotFreed != nil {
+		close(c.slotFreed)
+		c.slotFreed = nil
+	}
+}
+
+// lookup returns the context with the specified ID from the shard.
+func (c *TransactionContexts) lookup(shard *contextShard, ctxID string) *TransactionContext {
+	shard.mutex.Lock()
+	txctx := shard.contexts[ctxID]
+	shard.mutex.Unlock()
+	return txctx
+}
+
+// Validate performs the checks Create would perform for the specified chain
+// and transaction ID without registering a context. An error is returned when
+// a transaction context already exists for the chain and transaction ID or
+// when a transaction simulator is required and ctx does not carry one. A nil
+// error does not guarantee that a later Create will succeed.
+func (c *TransactionContexts) Validate(ctx context.Context, chainID, txID string) error {
+	if err := c.validateIDs(chainID, txID); err != nil {
+		return err
+	}
 
 	ctxID := contextID(chainID, txID)
-	if c.contexts[ctxID] != nil {
-		return nil, errors.Errorf("txid: %s(%s) exists", txID, chainID)
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if shard.contexts[ctxID] != nil {
+		return &ErrContextExists{ChainID: chainID, TxID: txID}
+	}
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return errors.Wrapf(ErrRegistryClosed, "txid: %s(%s)", txID, chainID)
+	}
+	if atomic.LoadInt32(&c.paused) != 0 {
+		return errors.Wrapf(ErrRegistryPaused, "txid: %s(%s)", txID, chainID)
+	}
+	if c.RequireTxSimulator && getTxSimulator(ctx) == nil && !isQueryOnly(ctx) {
+		return errors.Errorf("no tx simulator in context for txid: %s(%s)", txID, chainID)
+	}
+	return nil
+}
+
+// GetOrCreate returns the TransactionContext for the specified chain and
+// transaction ID, creating it if it does not exist. The returned bool is true
+// when a new context was created. An existing context is returned unchanged;
+// the provided context and proposals are only used when creating.
+func (c *TransactionContexts) GetOrCreate(ctx context.Context, chainID, txID string, signedProp *pb.SignedProposal, proposal *pb.Proposal) (*TransactionContext, bool, error) {
+	ctxID := contextID(chainID, txID)
+	shard := c.shard(ctxID)
+	if txctx := c.lookup(shard, ctxID); txctx != nil {
+		return txctx, false, nil
+	}
+
+	txctx, err := c.build(ctx, chainID, txID, signedProp, proposal)
+	if err != nil {
+		return nil, false, err
+	}
+
+	existing, err := c.store(shard, ctxID, txctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return existing, false, nil
+	}
+	return txctx, true, nil
+}
+
+// build creates a new TransactionContext from the values carried by ctx. The
+// context is not stored in the registry.
+func (c *TransactionContexts) build(ctx context.Context, chainID, txID string, signedProp *pb.SignedProposal, proposal *pb.Proposal) (*TransactionContext, error) {
+	if err := c.validateIDs(chainID, txID); err != nil {
+		return nil, err
+	}
+
+	if err := c.admit(chainID, txID, signedProp, proposal); err != nil {
+		return nil, err
+	}
+
+	txsim := getTxSimulator(ctx)
+	if c.RequireTxSimulator && txsim == nil && !isQueryOnly(ctx) {
+		return nil, errors.Errorf("no tx simulator in context for txid: %s(%s)", txID, chainID)
+	}
+
+	notifierSize := c.ResponseNotifierSize
+	if notifierSize < 1 {
+		notifierSize = 1
 	}
 
 	txctx := &TransactionContext{
 		ChainID:              chainID,
+		TxID:                 txID,
 		SignedProp:           signedProp,
 		Proposal:             proposal,
-		ResponseNotifier:     make(chan *pb.ChaincodeMessage, 1),
-		TXSimulator:          getTxSimulator(ctx),
+		ResponseNotifier:     make(chan *pb.ChaincodeMessage, notifierSize),
 		HistoryQueryExecutor: getHistoryQueryExecutor(ctx),
-		queryIteratorMap:     map[string]commonledger.ResultsIterator{},
-		pendingQueryResults:  map[string]*PendingQueryResult{},
+		readOnly:             isReadOnly(ctx),
+		queryOnly:            isQueryOnly(ctx),
+		priority:             getPriority(ctx),
+		span:                 getSpan(ctx),
+		queryIteratorMap:     iteratorMapPool.Get().(map[string]commonledger.ResultsIterator),
+		pendingQueryResults:  pendingResultsPool.Get().(map[string]*PendingQueryResult),
+		maxQueryIterators:    c.maxQueryIterators,
+		logger:               newTransactionLogger(chainID, txID),
+		ctx:                  ctx,
+	}
+	if c.TrackRWSetStats && txsim != nil {
+		txctx.rwsetStats = &rwsetStats{}
+	}
+	txctx.cacheReads = c.CacheReads
+	if c.MemoryBudget > 0 {
+		txctx.budget = &memoryBudget{limit: c.MemoryBudget}
+	}
+	txctx.TXSimulator = wrapSimulator(txctx, txsim)
+	txctx.chaincodeName = getChaincodeName(proposal)
+	if signedProp != nil {
+		creator, err := getCreator(signedProp)
+		if err != nil {
+			chaincodeLogger.Warningf("txid: %s(%s): failed to extract proposal creator: %s", txID, chainID, err)
+		}
+		txctx.creator = creator
+	}
+
+	return txctx, nil
+}
+
+// CreateChild creates a transaction context for a chaincode invoked by the
+// transaction associated with the specified parent chain and transaction ID.
+// The child shares the parent's ledger simulator and history query executor
+// but has its own proposal and query iterators. Child contexts are not
+// registered; they are closed when the parent is removed from the registry.
+// An error is returned when the parent context does not exist.
+func (c *TransactionContexts) CreateChild(parentChainID, parentTxID, childChainID string, prop *pb.Proposal) (*TransactionContext, error) {
+	ctxID := contextID(parentChainID, parentTxID)
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	parent := shard.contexts[ctxID]
+	if parent == nil {
+		return nil, errors.Errorf("txid: %s(%s) does not exist", parentTxID, parentChainID)
+	}
+
+	child := NewTransactionContext(childChainID, parentTxID, parent.SignedProp, prop)
+	child.TXSimulator = parent.GetTxSimulator()
+	child.rwsetStats = parent.rwsetStats
+	child.cacheReads = parent.cacheReads
+	child.budget = parent.budget
+	child.HistoryQueryExecutor = parent.GetHistoryQueryExecutor()
+	child.readOnly = parent.readOnly
+	child.queryOnly = parent.queryOnly
+	child.priority = parent.priority
+	child.creator = parent.creator
+	child.maxQueryIterators = c.maxQueryIterators
+	child.maxBytesRead = c.MaxBytesRead
+	child.metrics = c.Metrics
+	child.now = c.now
+	child.iteratorWrapper = c.IteratorWrapper
+	child.readSlots = c.readSemaphore()
+	child.iteratorReuse = c.IteratorReusePolicy
+	child.strictQuerySources = c.StrictQuerySources
+	child.created = c.now()
+	child.parent = parent
+	child.ctx, child.cancel = context.WithCancel(parent.Context())
+	parent.children = append(parent.children, child)
+
+	return child, nil
+}
+
+// Insert registers a transaction context built by NewTransactionContext. An
+// error is returned when a transaction context has already been registered
+// for the chain and transaction ID of txctx or when the maximum number of
+// active contexts has been reached.
+func (c *TransactionContexts) Insert(txctx *TransactionContext) error {
+	if err := c.validateIDs(txctx.ChainID, txctx.TxID); err != nil {
+		return err
+	}
+	if err := c.admit(txctx.ChainID, txctx.TxID, txctx.SignedProp, txctx.Proposal); err != nil {
+		return err
+	}
+
+	ctxID := contextID(txctx.ChainID, txctx.TxID)
+	existing, err := c.store(c.shard(ctxID), ctxID, txctx)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return &ErrContextExists{ChainID: txctx.ChainID, TxID: txctx.TxID}
+	}
+	return nil
+}
+
+// store inserts a transaction context into the shard unless a context is
+// already registered for the context ID, in which case the registered context
+// is returned. The creation of the context is published once the shard's mutex
+// has been released.
+func (c *TransactionContexts) store(shard *contextShard, ctxID string, txctx *TransactionContext) (*TransactionContext, error) {
+	shard.mutex.Lock()
+	if existing := shard.contexts[ctxID]; existing != nil {
+		shard.mutex.Unlock()
+		return existing, nil
+	}
+	err := c.insert(shard, ctxID, txctx)
+	shard.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	c.publish(ContextCreatedEvent, txctx)
+	return nil, nil
+}
+
+// insert stores a transaction context in the shard. The context of the
+// transaction is derived from the context currently held by txctx. The caller
+// must hold the shard's mutex.
+func (c *TransactionContexts) insert(shard *contextShard, ctxID string, txctx *TransactionContext) error {
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return errors.Wrapf(ErrRegistryClosed, "txid: %s(%s)", txctx.TxID, txctx.ChainID)
+	}
+	if atomic.LoadInt32(&c.paused) != 0 {
+		return errors.Wrapf(ErrRegistryPaused, "txid: %s(%s)", txctx.TxID, txctx.ChainID)
+	}
+	if err := c.acquire(txctx); err != nil {
+		return err
+	}
+	// the creator is charged only once every other check has passed
+	if c.RateLimiter != nil && txctx.priority < PriorityHigh && !c.RateLimiter.Allow(txctx.creator) {
+		c.release(txctx.ChainID)
+		return errors.Wrapf(ErrRateLimited, "txid: %s(%s)", txctx.TxID, txctx.ChainID)
+	}
+
+	if txctx.handle == "" {
+		txctx.handle = strconv.FormatUint(atomic.AddUint64(&handleSequence, 1), 10) + "/" + ctxID
+	}
+	txctx.created = c.now()
+	txctx.metrics = c.Metrics
+	txctx.maxBytesRead = c.MaxBytesRead
+	txctx.now = c.now
+	txctx.iteratorWrapper = c.IteratorWrapper
+	txctx.readSlots = c.readSemaphore()
+	txctx.iteratorReuse = c.IteratorReusePolicy
+	txctx.strictQuerySources = c.StrictQuerySources
+	if c.MaxTransactionDuration > 0 {
+		txctx.ctx, txctx.cancel = context.WithTimeout(txctx.Context(), c.MaxTransactionDuration)
+		txctx.deadlineTimer = time.AfterFunc(c.MaxTransactionDuration, func() { c.expire(ctxID, txctx) })
+	} else {
+		txctx.ctx, txctx.cancel = context.WithCancel(txctx.Context())
+	}
+	shard.contexts[ctxID] = txctx
+	atomic.AddUint64(&c.created, 1)
+	c.Metrics.ContextCreated(txctx.ChainID)
+	c.chaincodeStarted(txctx)
+
+	return nil
+}
+
+// transferMutex serializes transfers between registries so that the shards of
+// two registries are never locked in opposing orders.
+var transferMutex sync.Mutex
+
+// Transfer moves the transaction context associated with the specified chain
+// and transaction ID from the registry to another registry. The context is
+// moved as is, so its query iterators, pending query results, and ledger
+// simulator are preserved and its context.Context is not cancelled. The query
+// limits applied by the source registry remain in effect. If the destination
+// registry limits the transaction duration, the limit applies from the time
+// the context was originally created. An error is returned when
+// the context does not exist, when the context is leased through Acquire,
+// when the destination already holds a context for the chain and transaction
+// ID, or when the destination cannot accept another context.
+func (c *TransactionContexts) Transfer(chainID, txID string, to *TransactionContexts) error {
+	if to == c {
+		return errors.Errorf("txid: %s(%s) cannot be transferred to its own registry", txID, chainID)
+	}
+
+	txctx, err := c.transfer(chainID, txID, to)
+	if err != nil {
+		return err
+	}
+	to.publish(ContextCreatedEvent, txctx)
+	c.publish(ContextDeletedEvent, txctx)
+	return nil
+}
+
+// transfer moves the transaction context to another registry and returns it.
+// Events are not published.
+func (c *TransactionContexts) transfer(chainID, txID string, to *TransactionContexts) (*TransactionContext, error) {
+	transferMutex.Lock()
+	defer transferMutex.Unlock()
+
+	ctxID := contextID(chainID, txID)
+	src := c.shard(ctxID)
+	src.mutex.Lock()
+	defer src.mutex.Unlock()
+
+	txctx := src.contexts[ctxID]
+	if txctx == nil {
+		return nil, errors.Errorf("txid: %s(%s) does not exist", txID, chainID)
+	}
+	if txctx.leases > 0 {
+		return nil, errors.Errorf("txid: %s(%s) cannot be transferred while it is leased", txID, chainID)
+	}
+
+	dst := to.shard(ctxID)
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+
+	if dst.contexts[ctxID] != nil {
+		return nil, &ErrContextExists{ChainID: chainID, TxID: txID}
+	}
+	if err := to.adopt(dst, ctxID, txctx); err != nil {
+		return nil, err
 	}
-	c.contexts[ctxID] = txctx
 
+	delete(src.contexts, ctxID)
+	c.release(txctx.ChainID)
+	atomic.AddUint64(&c.deleted, 1)
+	c.Metrics.ContextDeleted(txctx.ChainID, c.now().Sub(txctx.created))
+	c.chaincodeFinished(txctx)
 	return txctx, nil
 }
 
+// Rekey moves the transaction context associated with the specified chain and
+// old transaction ID to the new transaction ID, for transactions that are
+// reassigned a transaction ID while in flight. The context is moved as is, so
+// its query iterators, pending query results, and ledger simulator are
+// preserved. The TxID of the context and its children is updated and its
+// handle changes to reflect the new ID. The caller must ensure the context is
+// not being used while it is rekeyed. An error is returned when the old
+// context does not exist, when a context already exists for the new
+// transaction ID, or when the context is leased through Acquire.
+func (c *TransactionContexts) Rekey(chainID, oldTxID, newTxID string) error {
+	if err := c.validateIDs(chainID, newTxID); err != nil {
+		return err
+	}
+
+	oldID := contextID(chainID, oldTxID)
+	newID := contextID(chainID, newTxID)
+	if oldID == newID {
+		return errors.Errorf("txid: %s(%s) cannot be rekeyed to itself", oldTxID, chainID)
+	}
+
+	// lock the shards in index order so that concurrent rekeys cannot deadlock
+	oldIndex, newIndex := c.shardIndex(oldID), c.shardIndex(newID)
+	src, dst := &c.shards[oldIndex], &c.shards[newIndex]
+	first, second := src, dst
+	if newIndex < oldIndex {
+		first, second = dst, src
+	}
+	first.mutex.Lock()
+	defer first.mutex.Unlock()
+	if second != first {
+		second.mutex.Lock()
+		defer second.mutex.Unlock()
+	}
+
+	txctx := src.contexts[oldID]
+	if txctx == nil {
+		return errors.Errorf("txid: %s(%s) does not exist", oldTxID, chainID)
+	}
+	if dst.contexts[newID] != nil {
+		return &ErrContextExists{ChainID: chainID, TxID: newTxID}
+	}
+	if txctx.leases > 0 {
+		return errors.Errorf("txid: %s(%s) cannot be rekeyed while it is leased", oldTxID, chainID)
+	}
+
+	delete(src.contexts, oldID)
+	dst.contexts[newID] = txctx
+	txctx.TxID = newTxID
+	txctx.logger = newTransactionLogger(chainID, newTxID)
+	for _, child := range txctx.children {
+		child.TxID = newTxID
+		child.logger = newTransactionLogger(child.ChainID, newTxID)
+	}
+	if i := strings.Index(txctx.handle, "/"); i >= 0 {
+		txctx.handle = txctx.handle[:i+1] + newID
+	}
+	if txctx.deadlineTimer != nil {
+		// a timer that already fired expires the old key, so it is rearmed
+		txctx.deadlineTimer.Stop()
+		remaining := txctx.created.Add(c.MaxTransactionDuration).Sub(c.now())
+		txctx.deadlineTimer = time.AfterFunc(remaining, func() { c.expire(newID, txctx) })
+	}
+	return nil
+}
+
+// adopt stores a transaction context transferred from another registry in
+// the shard. The caller must hold the shard's mutex and the mutex of the
+// shard in the source registry.
+func (c *TransactionContexts) adopt(shard *contextShard, ctxID string, txctx *TransactionContext) error {
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return errors.Wrapf(ErrRegistryClosed, "txid: %s(%s)", txctx.TxID, txctx.ChainID)
+	}
+	if atomic.LoadInt32(&c.paused) != 0 {
+		return errors.Wrapf(ErrRegistryPaused, "txid: %s(%s)", txctx.TxID, txctx.ChainID)
+	}
+	if err := c.acquire(txctx); err != nil {
+		return err
+	}
+
+	if txctx.deadlineTimer != nil {
+		txctx.deadlineTimer.Stop()
+		txctx.deadlineTimer = nil
+	}
+	if c.MaxTransactionDuration > 0 {
+		remaining := txctx.created.Add(c.MaxTransactionDuration).Sub(c.now())
+		txctx.deadlineTimer = time.AfterFunc(remaining, func() { c.expire(ctxID, txctx) })
+	}
+	shard.contexts[ctxID] = txctx
+	atomic.AddUint64(&c.created, 1)
+	c.Metrics.ContextCreated(txctx.ChainID)
+	c.chaincodeStarted(txctx)
+
+	return nil
+}
+
+// acquire reserves a slot for the transaction context and counts it against
+// its channel. The per-channel quota is enforced before the limit on the total
+// number of contexts.
+func (c *TransactionContexts) acquire(txctx *TransactionContext) error {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+
+	n := c.channelCounts[txctx.ChainID]
+	if c.MaxContextsPerChannel > 0 && n >= c.MaxContextsPerChannel {
+		return errors.Wrapf(ErrChannelQuotaExceeded, "txid: %s(%s)", txctx.TxID, txctx.ChainID)
+	}
+
+	// slots guaranteed to other active channels that they have not used
+	reserved := 0
+	if c.MinContextsPerChannel > 0 {
+		for chainID, count := range c.channelCounts {
+			if chainID != txctx.ChainID && count < c.MinContextsPerChannel {
+				reserved += c.MinContextsPerChannel - count
+			}
+		}
+	}
+	if err := c.acquireGlobal(txctx, reserved); err != nil {
+		return err
+	}
+
+	if c.channelCounts == nil {
+		c.channelCounts = map[string]int{}
+	}
+	c.channelCounts[txctx.ChainID] = n + 1
+	return nil
+}
+
+// acquireGlobal reserves one of the slots limited by the maximum number of
+// contexts, leaving the specified number of slots unused.
+func (c *TransactionContexts) acquireGlobal(txctx *TransactionContext, reserved int) error {
+	if n := atomic.AddInt32(&c.count, 1); c.maxContexts > 0 && int(n)+reserved > c.maxContexts {
+		atomic.AddInt32(&c.count, -1)
+		return errors.Wrapf(ErrTooManyContexts, "txid: %s(%s)", txctx.TxID, txctx.ChainID)
+	}
+	return nil
+}
+
+// release frees the slot held by a context of the specified chain. The
+// WaitChainDrained callers of the chain are woken when its last context is
+// released.
+func (c *TransactionContexts) release(chainID string) {
+	defer c.notifySlotFreed()
+	atomic.AddInt32(&c.count, -1)
+
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	if n, ok := c.channelCounts[chainID]; ok {
+		if n > 1 {
+			c.channelCounts[chainID] = n - 1
+			return
+		}
+		delete(c.channelCounts, chainID)
+		if drained, ok := c.drained[chainID]; ok {
+			close(drained)
+			delete(c.drained, chainID)
+		}
+	}
+}
+
+// remove removes a transaction context from the shard. The caller must hold
+// the shard's mutex. The returned function publishes the deletion, reports the
+// reason to the evict callbacks, and tears the context down, running its
+// delete hooks; it must be called once the caller has released the registry
+// locks.
+func (c *TransactionContexts) remove(shard *contextShard, ctxID string, txctx *TransactionContext, reason EvictReason) func() {
+	delete(shard.contexts, ctxID)
+	c.release(txctx.ChainID)
+	if txctx.deadlineTimer != nil {
+		txctx.deadlineTimer.Stop()
+	}
+	atomic.AddUint64(&c.deleted, 1)
+	c.Metrics.ContextDeleted(txctx.ChainID, c.now().Sub(txctx.created))
+	c.chaincodeFinished(txctx)
+	teardown := whenReleased(txctx, txctx.teardown)
+	return func() {
+		c.publish(ContextDeletedEvent, txctx)
+		c.notifyEvicted(txctx, reason)
+		teardown()
+	}
+}
+
+// whenReleased arranges for fn to run once no leases from Acquire are held on
+// txctx. When txctx is not leased, fn is returned for the caller to run after
+// releasing the shard's mutex; otherwise fn is run by the last release and a
+// function that does nothing is returned. The caller must hold the shard's
+// mutex.
+func whenReleased(txctx *TransactionContext, fn func()) func() {
+	if txctx.leases > 0 {
+		txctx.released = append(txctx.released, fn)
+		return func() {}
+	}
+	return fn
+}
+
+// removals collects the functions returned by remove so that they can be run
+// once the registry locks have been released.
+type removals []func()
+
+func (r *removals) add(fn func()) {
+	*r = append(*r, fn)
+}
+
+func (r removals) run() {
+	for _, fn := range r {
+		fn()
+	}
+}
+
+// Acquire retrieves the transaction context associated with the specified
+// chain and transaction ID and leases it to the caller. While a context is
+// leased, Delete and the other removal methods remove it from the registry
+// immediately but defer closing its iterators, releasing its transaction
+// simulator, and cancelling its context until every lease has been released. The returned release function must be called
+// when the caller is done with the context; calling it more than once has no
+// effect. False is returned when the context does not exist.
+func (c *TransactionContexts) Acquire(chainID, txID string) (*TransactionContext, func(), bool) {
+	ctxID := contextID(chainID, txID)
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	txctx := shard.contexts[ctxID]
+	if txctx == nil {
+		return nil, func() {}, false
+	}
+	txctx.leases++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			shard.mutex.Lock()
+			txctx.leases--
+			var released []func()
+			if txctx.leases == 0 {
+				released = txctx.released
+				txctx.released = nil
+			}
+			shard.mutex.Unlock()
+
+			for _, fn := range released {
+				fn()
+			}
+		})
+	}
+	return txctx, release, true
+}
+
+// expire removes a transaction context that has exceeded the maximum
+// transaction duration and notifies the waiting transaction.
+func (c *TransactionContexts) expire(ctxID string, txctx *TransactionContext) {
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+
+	// the context may have completed while the timer was firing
+	if shard.contexts[ctxID] != txctx {
+		shard.mutex.Unlock()
+		return
+	}
+
+	chaincodeLogger.Warningf("transaction context txid: %s(%s) exceeded maximum duration of %s", txctx.TxID, txctx.ChainID, c.MaxTransactionDuration)
+	atomic.StoreInt32(&txctx.timedOut, 1)
+	var removed removals
+	removed.add(whenReleased(txctx, txctx.closeQueryContexts))
+	removed.add(c.remove(shard, ctxID, txctx, EvictTimeout))
+	shard.mutex.Unlock()
+
+	removed.run()
+	txctx.Notify(&pb.ChaincodeMessage{
+		Type:      pb.ChaincodeMessage_ERROR,
+		Payload:   []byte(ErrTransactionTimeout.Error()),
+		Txid:      txctx.TxID,
+		ChannelId: txctx.ChainID,
+	})
+}
+
 func getTxSimulator(ctx context.Context) ledger.TxSimulator {
 	if txsim, ok := ctx.Value(TXSimulatorKey).(ledger.TxSimulator); ok {
 		return txsim
@@ -87,31 +1086,814 @@ func getHistoryQueryExecutor(ctx context.Context) ledger.HistoryQueryExecutor {
 	return nil
 }
 
+func isReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(ReadOnlyKey).(bool)
+	return readOnly
+}
+
+func isQueryOnly(ctx context.Context) bool {
+	queryOnly, _ := ctx.Value(QueryOnlyKey).(bool)
+	return queryOnly
+}
+
+func getPriority(ctx context.Context) Priority {
+	priority, _ := ctx.Value(PriorityKey).(Priority)
+	return priority
+}
+
+// getCreator extracts the serialized identity of the proposal creator from the
+// signed proposal.
+func getCreator(signedProp *pb.SignedProposal) ([]byte, error) {
+	prop, err := utils.GetProposal(signedProp.ProposalBytes)
+	if err != nil {
+		return nil, err
+	}
+	hdr, err := utils.GetHeader(prop.Header)
+	if err != nil {
+		return nil, err
+	}
+	shdr, err := utils.GetSignatureHeader(hdr.SignatureHeader)
+	if err != nil {
+		return nil, err
+	}
+	return shdr.Creator, nil
+}
+
 // Get retrieves the transaction context associated with the chain and
 // transaction ID.
 func (c *TransactionContexts) Get(chainID, txID string) *TransactionContext {
 	ctxID := contextID(chainID, txID)
-	c.mutex.Lock()
-	tc := c.contexts[ctxID]
-	c.mutex.Unlock()
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	tc := shard.contexts[ctxID]
+	shard.mutex.Unlock()
 	return tc
 }
 
+// GetErr retrieves the transaction context associated with the chain and
+// transaction ID like Get but returns an *ErrContextNotFound instead of nil
+// when the context does not exist.
+func (c *TransactionContexts) GetErr(chainID, txID string) (*TransactionContext, error) {
+	txctx := c.Get(chainID, txID)
+	if txctx == nil {
+		return nil, &ErrContextNotFound{ChainID: chainID, TxID: txID}
+	}
+	return txctx, nil
+}
+
+// GetByHandle retrieves the transaction context identified by a handle
+// returned from TransactionContext.Handle. Nil is returned when the context is
+// no longer held by the registry.
+func (c *TransactionContexts) GetByHandle(handle string) *TransactionContext {
+	i := strings.Index(handle, "/")
+	if i < 0 {
+		return nil
+	}
+	ctxID := handle[i+1:]
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if tc := shard.contexts[ctxID]; tc != nil && tc.handle == handle {
+		return tc
+	}
+	return nil
+}
+
+// GetByChain retrieves all transaction contexts associated with the specified
+// chain. The returned slice is a point-in-time view of the registry; callers
+// must not retain the contexts beyond the lifetime of the transactions.
+func (c *TransactionContexts) GetByChain(chainID string) []*TransactionContext {
+	var txctxs []*TransactionContext
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		if txctx.ChainID == chainID {
+			txctxs = append(txctxs, txctx)
+		}
+	})
+	return txctxs
+}
+
 // Delete removes the transaction context associated with the specified chain
-// and transaction ID.
-func (c *TransactionContexts) Delete(chainID, txID string) {
+// and transaction ID. It returns false when no such context was registered.
+func (c *TransactionContexts) Delete(chainID, txID string) bool {
+	ctxID := contextID(chainID, txID)
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	txctx := shard.contexts[ctxID]
+	if txctx == nil {
+		shard.mutex.Unlock()
+		return false
+	}
+	removed := c.remove(shard, ctxID, txctx, EvictDeleted)
+	shard.mutex.Unlock()
+
+	removed()
+	return true
+}
+
+// DeleteAndClose closes the query iterators of the transaction context
+// associated with the specified chain and transaction ID, discards its
+// pending query results, and removes it from the registry.
+func (c *TransactionContexts) DeleteAndClose(chainID, txID string) {
+	ctxID := contextID(chainID, txID)
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	var removed removals
+	if txctx := shard.contexts[ctxID]; txctx != nil {
+		removed.add(whenReleased(txctx, txctx.closeQueryContexts))
+		removed.add(c.remove(shard, ctxID, txctx, EvictDeleted))
+	}
+	shard.mutex.Unlock()
+	removed.run()
+}
+
+// DeleteBatch closes the query iterators of the transaction contexts
+// associated with the specified chain and each of the transaction IDs and
+// removes them from the registry. The lock of each shard is taken once for all
+// of the contexts it holds. Transaction IDs without a context are ignored.
+func (c *TransactionContexts) DeleteBatch(chainID string, txIDs []string) {
+	batches := make([][]string, len(c.shards))
+	for _, txID := range txIDs {
+		ctxID := contextID(chainID, txID)
+		i := c.shardIndex(ctxID)
+		batches[i] = append(batches[i], ctxID)
+	}
+
+	for i, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		shard := &c.shards[i]
+		var removed removals
+		shard.mutex.Lock()
+		for _, ctxID := range batch {
+			if txctx := shard.contexts[ctxID]; txctx != nil {
+				removed.add(whenReleased(txctx, txctx.closeQueryContexts))
+				removed.add(c.remove(shard, ctxID, txctx, EvictDeleted))
+			}
+		}
+		shard.mutex.Unlock()
+		removed.run()
+	}
+}
+
+// Replace swaps the transaction simulator of the transaction context
+// associated with the specified chain and transaction ID. Query iterators and
+// pending query results are left intact. Callers are responsible for closing
+// the simulator that was replaced. Handlers using the context observe the new
+// simulator through GetTxSimulator. An error is returned when the context does
+// not exist.
+func (c *TransactionContexts) Replace(chainID, txID string, txsim ledger.TxSimulator) error {
+	ctxID := contextID(chainID, txID)
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	txctx := shard.contexts[ctxID]
+	if txctx == nil {
+		return errors.Errorf("txid: %s(%s) does not exist", txID, chainID)
+	}
+	txctx.setTxSimulator(wrapSimulator(txctx, txsim))
+	return nil
+}
+
+// wrapSimulator wraps txsim to cache reads, to record the read-write set
+// statistics, and to enforce the memory budget of txctx when they are
+// enabled.
+func wrapSimulator(txctx *TransactionContext, txsim ledger.TxSimulator) ledger.TxSimulator {
+	if txsim == nil {
+		return nil
+	}
+	if txctx.cacheReads {
+		txsim = newCachingSimulator(txsim)
+	}
+	if txctx.rwsetStats != nil {
+		txsim = &statsSimulator{TxSimulator: txsim, stats: txctx.rwsetStats}
+	}
+	if txctx.budget != nil {
+		txsim = &budgetSimulator{TxSimulator: txsim, txctx: txctx}
+	}
+	return txsim
+}
+
+// SetHistoryQueryExecutor sets the history query executor of the transaction
+// context associated with the specified chain and transaction ID. It is used
+// when the executor is provisioned after the context was created. An error is
+// returned when the context does not exist.
+func (c *TransactionContexts) SetHistoryQueryExecutor(chainID, txID string, hqe ledger.HistoryQueryExecutor) error {
 	ctxID := contextID(chainID, txID)
-	c.mutex.Lock()
-	delete(c.contexts, ctxID)
-	c.mutex.Unlock()
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	txctx := shard.contexts[ctxID]
+	if txctx == nil {
+		return errors.Errorf("txid: %s(%s) does not exist", txID, chainID)
+	}
+	txctx.setHistoryQueryExecutor(hqe)
+	return nil
+}
+
+// CollectSimulationResults gathers the simulation results of the transaction
+// contexts associated with the specified chain and each of the transaction
+// IDs. The results are keyed by transaction ID. The simulators are looked up
+// under the registry's lock but their results are retrieved after it has been
+// released. An error is returned when a context does not exist, has no
+// transaction simulator, or fails to produce its results.
+func (c *TransactionContexts) CollectSimulationResults(chainID string, txIDs []string) (map[string]*ledger.TxSimulationResults, error) {
+	simulators := make(map[string]ledger.TxSimulator, len(txIDs))
+	for _, txID := range txIDs {
+		ctxID := contextID(chainID, txID)
+		shard := c.shard(ctxID)
+		shard.mutex.Lock()
+		txctx := shard.contexts[ctxID]
+		var txsim ledger.TxSimulator
+		if txctx != nil {
+			txsim = txctx.GetTxSimulator()
+		}
+		shard.mutex.Unlock()
+
+		if txctx == nil {
+			return nil, errors.Errorf("txid: %s(%s) does not exist", txID, chainID)
+		}
+		if txsim == nil {
+			return nil, errors.Errorf("no tx simulator for txid: %s(%s)", txID, chainID)
+		}
+		simulators[txID] = txsim
+	}
+
+	results := make(map[string]*ledger.TxSimulationResults, len(simulators))
+	for txID, txsim := range simulators {
+		result, err := txsim.GetTxSimulationResults()
+		if err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("failed to get simulation results for txid: %s(%s)", txID, chainID))
+		}
+		results[txID] = result
+	}
+	return results, nil
+}
+
+// CloseIterator closes and removes a single query iterator and its pending
+// query results from the transaction context associated with the specified
+// chain and transaction ID. Other iterators of the context are not affected.
+// An error is returned when the context or the iterator does not exist.
+func (c *TransactionContexts) CloseIterator(chainID, txID, iteratorID string) error {
+	ctxID := contextID(chainID, txID)
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	txctx := shard.contexts[ctxID]
+	if txctx == nil {
+		return errors.Errorf("txid: %s(%s) does not exist", txID, chainID)
+	}
+	if !txctx.closeIterator(iteratorID) {
+		return errors.Errorf("txid: %s(%s): query iterator %s does not exist", txID, chainID, iteratorID)
+	}
+	return nil
 }
 
-// Close closes all query iterators assocated with the context.
-func (c *TransactionContexts) Close() {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// Count returns the number of active transaction contexts.
+func (c *TransactionContexts) Count() int {
+	return int(atomic.LoadInt32(&c.count))
+}
+
+// CheckInvariants scans every transaction context in the registry and returns
+// an error for each query iterator without a pending query result and for each
+// pending query result without a query iterator. A consistent registry returns
+// no errors.
+func (c *TransactionContexts) CheckInvariants() []error {
+	var errs []error
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		errs = append(errs, txctx.checkQueryInvariants()...)
+	})
+	return errs
+}
+
+// QueryTypeCounts returns the number of open query iterators of each query
+// type across all transaction contexts in the registry.
+func (c *TransactionContexts) QueryTypeCounts() map[QueryType]int {
+	counts := map[QueryType]int{}
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		txctx.countQueryTypes(counts)
+	})
+	return counts
+}
+
+// Stats returns the total number of contexts added to and removed from the
+// registry since it was created. Contexts transferred out of the registry are
+// counted as deleted and contexts transferred in are counted as created.
+func (c *TransactionContexts) Stats() (created, deleted uint64) {
+	return atomic.LoadUint64(&c.created), atomic.LoadUint64(&c.deleted)
+}
+
+// RegistryHealth describes how close a registry is to its capacity so that
+// readiness and liveness probes can take the peer out of rotation.
+type RegistryHealth struct {
+	// Count is the number of active transaction contexts.
+	Count int
+	// Max is the maximum number of active contexts; zero is unlimited.
+	Max int
+	// Saturation is Count divided by Max. It is zero when Max is unlimited.
+	Saturation float64
+	// OldestAge is the age of the oldest active context or zero when there
+	// are no active contexts.
+	OldestAge time.Duration
+	// Paused reports whether the registry has been paused.
+	Paused bool
+	// Closed reports whether the registry has been closed.
+	Closed bool
+}
+
+// Health reports the saturation of the registry.
+func (c *TransactionContexts) Health() RegistryHealth {
+	health := RegistryHealth{
+		Max:    c.maxContexts,
+		Paused: atomic.LoadInt32(&c.paused) != 0,
+		Closed: atomic.LoadInt32(&c.closing) != 0,
+	}
+
+	now := c.now()
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		health.Count++
+		if age := now.Sub(txctx.created); age > health.OldestAge {
+			health.OldestAge = age
+		}
+	})
+	if health.Max > 0 {
+		health.Saturation = float64(health.Count) / float64(health.Max)
+	}
+	return health
+}
+
+// each calls fn for every transaction context in the registry in order of chain
+// and transaction ID so that enumeration is reproducible. The mutex of every
+// shard is held while fn is called so fn may remove the context it is called
+// with. Work that can block, such as closing query iterators, belongs outside
+// of fn; see contexts.
+func (c *TransactionContexts) each(fn func(shard *contextShard, ctxID string, txctx *TransactionContext)) {
+	type entry struct {
+		shard *contextShard
+		ctxID string
+		txctx *TransactionContext
+	}
+
+	var entries []entry
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mutex.Lock()
+		defer shard.mutex.Unlock()
+		for ctxID, txctx := range shard.contexts {
+			entries = append(entries, entry{shard: shard, ctxID: ctxID, txctx: txctx})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].txctx, entries[j].txctx
+		if a.ChainID != b.ChainID {
+			return a.ChainID < b.ChainID
+		}
+		return a.TxID < b.TxID
+	})
+	for _, e := range entries {
+		fn(e.shard, e.ctxID, e.txctx)
+	}
+}
+
+// contexts returns the transaction contexts in the registry in the order of
+// each. No shard lock is held once it returns so the caller may close their
+// query iterators without blocking the registry.
+func (c *TransactionContexts) contexts() []*TransactionContext {
+	var txctxs []*TransactionContext
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		txctxs = append(txctxs, txctx)
+	})
+	return txctxs
+}
+
+// Snapshot returns metadata describing each active transaction context. The
+// returned values are copies and are not affected by later changes to the
+// registry.
+func (c *TransactionContexts) Snapshot() []TransactionContextInfo {
+	infos := make([]TransactionContextInfo, 0, c.Count())
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		infos = append(infos, txctx.info())
+	})
+	return infos
+}
+
+// OldestContexts returns metadata describing at most n of the active
+// transaction contexts, ordered from the oldest to the most recently created.
+// Contexts created at the same time are ordered by chain and transaction ID.
+// No contexts are returned when n is less than one.
+func (c *TransactionContexts) OldestContexts(n int) []TransactionContextInfo {
+	if n < 1 {
+		return nil
+	}
+
+	infos := c.Snapshot()
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		switch {
+		case !a.Created.Equal(b.Created):
+			return a.Created.Before(b.Created)
+		case a.ChainID != b.ChainID:
+			return a.ChainID < b.ChainID
+		default:
+			return a.TxID < b.TxID
+		}
+	})
+	if len(infos) > n {
+		infos = infos[:n]
+	}
+	return infos
+}
+
+// contextJSON is the JSON representation of an active transaction context.
+// Proposals and creator identities are never included.
+type contextJSON struct {
+	ChainID             string            `json:"chain_id"`
+	TxID                string            `json:"tx_id"`
+	Created             time.Time         `json:"created"`
+	Age                 string            `json:"age"`
+	QueryIterators      int               `json:"query_iterators"`
+	PendingQueryResults int               `json:"pending_query_results"`
+	Labels              map[string]string `json:"labels,omitempty"`
+	CreatorHash         string            `json:"creator_hash,omitempty"`
+}
+
+// SnapshotJSON marshals a snapshot of the active transaction contexts to a
+// JSON array suitable for serving from a debug endpoint. Proposal payloads and
+// creator identities are omitted. When includeCreatorHash is true, the hex
+// encoded SHA-256 hash of the creator identity is included so that contexts
+// can be correlated by creator.
+func (c *TransactionContexts) SnapshotJSON(includeCreatorHash bool) ([]byte, error) {
+	now := c.now()
+	contexts := make([]contextJSON, 0, c.Count())
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		info := txctx.info()
+		cj := contextJSON{
+			ChainID:             info.ChainID,
+			TxID:                info.TxID,
+			Created:             info.Created,
+			Age:                 now.Sub(info.Created).String(),
+			QueryIterators:      info.QueryIterators,
+			PendingQueryResults: info.PendingQueryResults,
+			Labels:              info.Labels,
+		}
+		if includeCreatorHash && len(txctx.creator) > 0 {
+			cj.CreatorHash = hex.EncodeToString(util.ComputeSHA256(txctx.creator))
+		}
+		contexts = append(contexts, cj)
+	})
+
+	b, err := json.Marshal(contexts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal transaction context snapshot")
+	}
+	return b, nil
+}
+
+// CloseChain closes the query iterators of all transaction contexts
+// associated with the specified chain and removes them from the registry.
+// Contexts associated with other chains are not affected.
+func (c *TransactionContexts) CloseChain(chainID string) {
+	var removed removals
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		if txctx.ChainID != chainID {
+			return
+		}
+		removed.add(whenReleased(txctx, txctx.CloseQueryIterators))
+		removed.add(c.remove(shard, ctxID, txctx, EvictPurged))
+	})
+	removed.run()
+}
+
+// Reap removes transaction contexts that were created more than olderThan
+// ago. The query iterators of reaped contexts are closed and their transaction
+// simulators are released. The number of reaped contexts is returned.
+func (c *TransactionContexts) Reap(olderThan time.Duration) int {
+	reaped := 0
+	cutoff := c.now().Add(-olderThan)
+	var removed removals
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		if !txctx.created.Before(cutoff) {
+			return
+		}
+		chaincodeLogger.Warningf("reaping abandoned transaction context txid: %s(%s) created at %s", txctx.TxID, txctx.ChainID, txctx.created)
+		removed.add(whenReleased(txctx, txctx.abandon))
+		removed.add(c.remove(shard, ctxID, txctx, EvictTimeout))
+		reaped++
+	})
+	removed.run()
+
+	return reaped
+}
+
+// WarnLongLived logs a warning, tagged with the chain and transaction ID, for
+// every transaction context that has been active for at least
+// LongLivedWarnAge and has not been reported within the last
+// LongLivedWarnInterval. Contexts are not removed. The number of contexts
+// reported is returned.
+func (c *TransactionContexts) WarnLongLived() int {
+	if c.LongLivedWarnAge <= 0 {
+		return 0
+	}
+
+	warned := 0
+	now := c.now()
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		if txctx.nextWarning.IsZero() {
+			txctx.nextWarning = txctx.created.Add(c.LongLivedWarnAge)
+		}
+		if now.Before(txctx.nextWarning) {
+			return
+		}
+		txctx.Logger().Warningf("transaction context has been active for %s", now.Sub(txctx.created))
+		warned++
+
+		if c.LongLivedWarnInterval <= 0 {
+			txctx.nextWarning = maxTime
+			return
+		}
+		for !now.Before(txctx.nextWarning) {
+			txctx.nextWarning = txctx.nextWarning.Add(c.LongLivedWarnInterval)
+		}
+	})
+	return warned
+}
+
+// maxTime is a time that is never reached.
+var maxTime = time.Unix(1<<62, 0)
+
+// MonitorLongLived calls WarnLongLived every LongLivedWarnInterval, or every
+// LongLivedWarnAge when no interval is configured, until ctx is done. It
+// returns immediately when LongLivedWarnAge is not set.
+func (c *TransactionContexts) MonitorLongLived(ctx context.Context) {
+	if c.LongLivedWarnAge <= 0 {
+		return
+	}
+	period := c.LongLivedWarnInterval
+	if period <= 0 {
+		period = c.LongLivedWarnAge
+	}
 
-	for _, txctx := range c.contexts {
-		txctx.CloseQueryIterators()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.WarnLongLived()
+		case <-ctx.Done():
+			return
+		}
 	}
 }
+
+// Evict removes transaction contexts until at most limit remain. Contexts
+// with a lower priority are evicted first and, within a priority, older
+// contexts are evicted before newer ones. When EvictionCostWeights is set,
+// contexts within a priority are instead evicted in order of decreasing cost,
+// with older contexts evicted first among those of equal cost. The query
+// iterators of evicted contexts are closed and their transaction simulators
+// are released. The number of evicted contexts is returned.
+func (c *TransactionContexts) Evict(limit int) int {
+	txctxs := c.contexts()
+
+	costs := make(map[*TransactionContext]float64, len(txctxs))
+	if weights := c.EvictionCostWeights; weights != nil {
+		for _, txctx := range txctxs {
+			costs[txctx] = txctx.cost(*weights)
+		}
+	}
+	sort.SliceStable(txctxs, func(i, j int) bool {
+		if txctxs[i].priority != txctxs[j].priority {
+			return txctxs[i].priority < txctxs[j].priority
+		}
+		if costs[txctxs[i]] != costs[txctxs[j]] {
+			return costs[txctxs[i]] > costs[txctxs[j]]
+		}
+		return txctxs[i].created.Before(txctxs[j].created)
+	})
+
+	evicted := 0
+	for _, txctx := range txctxs {
+		if c.Count() <= limit {
+			break
+		}
+		if c.evict(txctx) {
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// evict removes the transaction context if it is still registered.
+func (c *TransactionContexts) evict(txctx *TransactionContext) bool {
+	ctxID := contextID(txctx.ChainID, txctx.TxID)
+	shard := c.shard(ctxID)
+	shard.mutex.Lock()
+
+	if shard.contexts[ctxID] != txctx {
+		shard.mutex.Unlock()
+		return false
+	}
+	chaincodeLogger.Warningf("evicting transaction context txid: %s(%s) with priority %d", txctx.TxID, txctx.ChainID, txctx.priority)
+	var removed removals
+	removed.add(whenReleased(txctx, txctx.abandon))
+	removed.add(c.remove(shard, ctxID, txctx, EvictOverLimit))
+	shard.mutex.Unlock()
+
+	removed.run()
+	return true
+}
+
+// ReapIdleIterators closes the query iterators of all transaction contexts
+// that have not been advanced within idleTimeout. The pending query results of
+// closed iterators are discarded and later attempts to advance them fail with
+// ErrIteratorIdle. The transaction contexts themselves remain registered. The
+// number of closed iterators is returned.
+func (c *TransactionContexts) ReapIdleIterators(idleTimeout time.Duration) int {
+	closed := 0
+	cutoff := c.now().Add(-idleTimeout)
+	for _, txctx := range c.contexts() {
+		n := txctx.closeIdleIterators(cutoff)
+		if n > 0 {
+			chaincodeLogger.Warningf("closed %d idle query iterators of txid: %s(%s)", n, txctx.TxID, txctx.ChainID)
+		}
+		closed += n
+	}
+
+	return closed
+}
+
+// CloseGracefully shuts down the registry without racing in-flight responses.
+// New contexts are rejected as soon as CloseGracefully is called. It then waits
+// for any response already delivered to a context's ResponseNotifier to be
+// received before closing the query iterators of all contexts and clearing the
+// registry. If ctx is done before the responses drain, iterators are closed and
+// the registry is cleared immediately and ctx.Err() is returned.
+func (c *TransactionContexts) CloseGracefully(ctx context.Context) error {
+	atomic.StoreInt32(&c.closing, 1)
+	err := waitForDrain(ctx, c.contexts())
+
+	var removed removals
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		removed.add(whenReleased(txctx, txctx.CloseQueryIterators))
+		removed.add(c.remove(shard, ctxID, txctx, EvictPurged))
+	})
+	removed.run()
+
+	return err
+}
+
+// drainPollInterval is how often waitForDrain checks the response notifiers.
+const drainPollInterval = 10 * time.Millisecond
+
+func waitForDrain(ctx context.Context, txctxs []*TransactionContext) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for _, txctx := range txctxs {
+		for len(txctx.ResponseNotifier) > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	}
+	return nil
+}
+
+// Purge closes the query iterators of all transaction contexts and removes
+// them from the registry. Unlike Close, the registry is left empty. The number
+// of removed contexts is returned for each chain.
+func (c *TransactionContexts) Purge() map[string]int {
+	purged := map[string]int{}
+	var removed removals
+	c.each(func(shard *contextShard, ctxID string, txctx *TransactionContext) {
+		removed.add(whenReleased(txctx, txctx.CloseQueryIterators))
+		removed.add(c.remove(shard, ctxID, txctx, EvictPurged))
+		purged[txctx.ChainID]++
+	})
+	removed.run()
+	return purged
+}
+
+// Pause stops the registry from accepting new transaction contexts until
+// Resume is called. Contexts that are already registered are unaffected and
+// can still be retrieved, used, and deleted.
+func (c *TransactionContexts) Pause() {
+	atomic.StoreInt32(&c.paused, 1)
+}
+
+// Resume allows a paused registry to accept new transaction contexts again.
+func (c *TransactionContexts) Resume() {
+	atomic.StoreInt32(&c.paused, 0)
+}
+
+// Close closes all query iterators assocated with the context. Contexts can no
+// longer be created once the registry is closed but existing contexts remain
+// registered so that they can be retrieved while in-flight transactions
+// drain.
+//
+// Every iterator is closed even when some fail to close. As
+// ResultsIterator.Close does not return an error, an iterator fails when its
+// Close panics; the panic is recovered and an error describing all of the
+// failures is returned.
+func (c *TransactionContexts) Close() error {
+	return c.CloseChecked(false)
+}
+
+// CloseChecked behaves like Close and reports the query iterators that fail
+// to close. Contexts are visited in order of chain and transaction ID and
+// iterators in order of query ID.
+//
+// When failFast is false, the behavior is that of Close. When failFast is
+// true, the first failure is returned immediately and the remaining
+// iterators, including those of contexts not yet visited, are left open for
+// inspection.
+func (c *TransactionContexts) CloseChecked(failFast bool) error {
+	atomic.StoreInt32(&c.closing, 1)
+	var errs []error
+	for _, txctx := range c.contexts() {
+		if c.FlushPendingOnClose {
+			txctx.flushPendingQueryResults()
+		}
+		errs = append(errs, txctx.closeQueryIteratorsChecked(failFast)...)
+		if failFast && len(errs) > 0 {
+			break
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.Errorf("failed to close %d query iterators: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// CloseWithTimeout behaves like Close but closes each query iterator in its own
+// goroutine so that an iterator whose Close blocks cannot hang the shutdown.
+// If some iterators have not closed within d, an error listing them is
+// returned and their goroutines are abandoned.
+func (c *TransactionContexts) CloseWithTimeout(d time.Duration) error {
+	type closing struct {
+		name string
+		done chan struct{}
+	}
+
+	atomic.StoreInt32(&c.closing, 1)
+	var iters []closing
+	for _, txctx := range c.contexts() {
+		if c.FlushPendingOnClose {
+			txctx.flushPendingQueryResults()
+		}
+		for queryID, iter := range txctx.takeQueryIterators() {
+			done := make(chan struct{})
+			go func(iter commonledger.ResultsIterator) {
+				iter.Close()
+				close(done)
+			}(iter)
+			iters = append(iters, closing{
+				name: fmt.Sprintf("%s of txid: %s(%s)", queryID, txctx.TxID, txctx.ChainID),
+				done: done,
+			})
+		}
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	var stuck []string
+	timedOut := false
+	for _, iter := range iters {
+		if !timedOut {
+			select {
+			case <-iter.done:
+			case <-timer.C:
+				timedOut = true
+			}
+		}
+		if timedOut {
+			select {
+			case <-iter.done:
+			default:
+				stuck = append(stuck, iter.name)
+			}
+		}
+	}
+
+	if len(stuck) > 0 {
+		sort.Strings(stuck)
+		return errors.Errorf("timed out after %s closing query iterators: %s", d, strings.Join(stuck, ", "))
+	}
+	return nil
+}
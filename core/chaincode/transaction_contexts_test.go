@@ -7,11 +7,33 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode_test
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/flogging"
+	commonledger "github.com/hyperledger/fabric/common/ledger"
 	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/chaincode/fake"
 	"github.com/hyperledger/fabric/core/chaincode/mock"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
@@ -19,7 +41,7 @@ var _ = Describe("TransactionContexts", func() {
 	var txContexts *chaincode.TransactionContexts
 
 	BeforeEach(func() {
-		txContexts = chaincode.NewTransactionContexts()
+		txContexts = chaincode.NewTransactionContexts(0, 0)
 	})
 
 	Describe("Create", func() {
@@ -48,6 +70,7 @@ var _ = Describe("TransactionContexts", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(txContext.ChainID).To(Equal("chainID"))
+			Expect(txContext.TxID).To(Equal("transactionID"))
 			Expect(txContext.SignedProp).To(Equal(signedProp))
 			Expect(txContext.Proposal).To(Equal(proposal))
 			Expect(txContext.ResponseNotifier).NotTo(BeNil())
@@ -56,6 +79,143 @@ var _ = Describe("TransactionContexts", func() {
 			Expect(txContext.HistoryQueryExecutor).To(Equal(fakeHistoryQueryExecutor))
 		})
 
+		It("rejects an empty chain ID", func() {
+			_, err := txContexts.Create(ctx, "", "transactionID", signedProp, proposal)
+			Expect(err).To(MatchError("empty chain ID for txid: transactionID"))
+			Expect(txContexts.Count()).To(Equal(0))
+		})
+
+		It("rejects an empty transaction ID", func() {
+			_, err := txContexts.Create(ctx, "chainID", "", signedProp, proposal)
+			Expect(err).To(MatchError("empty transaction ID for chain: chainID"))
+			Expect(txContexts.Count()).To(Equal(0))
+		})
+
+		Context("when chainless contexts are allowed", func() {
+			BeforeEach(func() {
+				txContexts.AllowEmptyChainID = true
+			})
+
+			It("creates a context with an empty chain ID", func() {
+				txContext, err := txContexts.Create(ctx, "", "transactionID", signedProp, proposal)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContext.ChainID).To(BeEmpty())
+				Expect(txContexts.Get("", "transactionID")).To(Equal(txContext))
+			})
+
+			It("still rejects an empty transaction ID", func() {
+				_, err := txContexts.Create(ctx, "", "", signedProp, proposal)
+				Expect(err).To(MatchError("empty transaction ID for chain: "))
+			})
+		})
+
+		It("derives the transaction's context from the provided context", func() {
+			parent, cancel := context.WithCancel(ctx)
+			txContext, err := txContexts.Create(parent, "chainID", "transactionID", signedProp, proposal)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContext.Context().Value(chaincode.TXSimulatorKey)).To(Equal(fakeTxSimulator))
+			Expect(txContext.Context().Done()).NotTo(BeClosed())
+			cancel()
+			Expect(txContext.Context().Done()).To(BeClosed())
+		})
+
+		It("provides accessors for the proposals stored at creation", func() {
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", signedProp, proposal)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.GetProposal()).To(BeIdenticalTo(proposal))
+			Expect(txContext.GetSignedProposal()).To(BeIdenticalTo(signedProp))
+		})
+
+		It("provides accessors for the ledger state stored at creation", func() {
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.GetTxSimulator()).To(BeIdenticalTo(fakeTxSimulator))
+			Expect(txContext.GetHistoryQueryExecutor()).To(BeIdenticalTo(fakeHistoryQueryExecutor))
+		})
+
+		It("reports history support when the context carries a history query executor", func() {
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.SupportsHistory()).To(BeTrue())
+		})
+
+		It("reports no history support when the context lacks a history query executor", func() {
+			ctx = context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.SupportsHistory()).To(BeFalse())
+		})
+
+		It("creates a writable context by default", func() {
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.ReadOnly()).To(BeFalse())
+		})
+
+		It("creates a read-only context when requested by the provided context", func() {
+			txContext, err := txContexts.Create(context.WithValue(ctx, chaincode.ReadOnlyKey, true), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.ReadOnly()).To(BeTrue())
+		})
+
+		It("creates a query-only context when requested by the provided context", func() {
+			txContext, err := txContexts.Create(context.WithValue(context.Background(), chaincode.QueryOnlyKey, true), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.QueryOnly()).To(BeTrue())
+			Expect(txContext.TXSimulator).To(BeNil())
+			Expect(txContext.TxSimulatorMissing()).To(BeFalse())
+		})
+
+		It("reports a missing simulator on a context that is not query-only", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.QueryOnly()).To(BeFalse())
+			Expect(txContext.TxSimulatorMissing()).To(BeTrue())
+		})
+
+		It("does not report a missing simulator when one is present", func() {
+			txContext, err := txContexts.Create(context.WithValue(ctx, chaincode.QueryOnlyKey, true), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.TxSimulatorMissing()).To(BeFalse())
+		})
+
+		It("uses the priority requested by the provided context", func() {
+			txContext, err := txContexts.Create(context.WithValue(ctx, chaincode.PriorityKey, chaincode.PriorityHigh), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.Priority()).To(Equal(chaincode.PriorityHigh))
+		})
+
+		It("uses normal priority by default", func() {
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.Priority()).To(Equal(chaincode.PriorityNormal))
+		})
+
+		It("records the creator of a well-formed signed proposal", func() {
+			signedProp = &pb.SignedProposal{
+				ProposalBytes: utils.MarshalOrPanic(&pb.Proposal{
+					Header: utils.MarshalOrPanic(&common.Header{
+						SignatureHeader: utils.MarshalOrPanic(&common.SignatureHeader{
+							Creator: []byte("creator-identity"),
+						}),
+					}),
+				}),
+			}
+
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", signedProp, proposal)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.Creator()).To(Equal([]byte("creator-identity")))
+		})
+
+		It("leaves the creator nil when the signed proposal is malformed", func() {
+			signedProp = &pb.SignedProposal{ProposalBytes: []byte("this-is-a-bogus-payload")}
+
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", signedProp, proposal)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.Creator()).To(BeNil())
+		})
+
 		It("keeps track of the created context", func() {
 			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", signedProp, proposal)
 			Expect(err).NotTo(HaveOccurred())
@@ -74,106 +234,3745 @@ var _ = Describe("TransactionContexts", func() {
 				_, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
 				Expect(err).To(MatchError("txid: transactionID(chainID) exists"))
 			})
+
+			It("returns an ErrContextExists identifying the context", func() {
+				_, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+
+				var existsErr *chaincode.ErrContextExists
+				Expect(stderrors.As(err, &existsErr)).To(BeTrue())
+				Expect(existsErr.ChainID).To(Equal("chainID"))
+				Expect(existsErr.TxID).To(Equal("transactionID"))
+			})
 		})
-	})
 
-	Describe("Get", func() {
-		var c1, c2 *chaincode.TransactionContext
+		Context("when the same context is created concurrently", func() {
+			It("creates exactly one context", func() {
+				var (
+					wg        sync.WaitGroup
+					created   int32
+					duplicate int32
+				)
+				for i := 0; i < 20; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						defer GinkgoRecover()
+						_, err := txContexts.Create(ctx, "chainID", "transactionID", signedProp, proposal)
+						if err == nil {
+							atomic.AddInt32(&created, 1)
+							return
+						}
+						Expect(err).To(MatchError("txid: transactionID(chainID) exists"))
+						atomic.AddInt32(&duplicate, 1)
+					}()
+				}
+				wg.Wait()
 
-		BeforeEach(func() {
-			var err error
-			c1, err = txContexts.Create(context.Background(), "chainID1", "transactionID1", nil, nil)
-			Expect(err).NotTo(HaveOccurred())
+				Expect(created).To(Equal(int32(1)))
+				Expect(duplicate).To(Equal(int32(19)))
+				Expect(txContexts.Count()).To(Equal(1))
+			})
+		})
 
-			c2, err = txContexts.Create(context.Background(), "chainID1", "transactionID2", nil, nil)
+		Context("when the maximum number of contexts has been reached", func() {
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(2, 0)
+				_, err := txContexts.Create(ctx, "chainID", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = txContexts.Create(ctx, "chainID", "transactionID2", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns ErrTooManyContexts", func() {
+				_, err := txContexts.Create(ctx, "chainID", "transactionID3", nil, nil)
+				Expect(err).To(MatchError("txid: transactionID3(chainID): too many active transaction contexts"))
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrTooManyContexts))
+				Expect(txContexts.Get("chainID", "transactionID3")).To(BeNil())
+			})
+
+			It("allows creation once a context has been deleted", func() {
+				txContexts.Delete("chainID", "transactionID1")
+				_, err := txContexts.Create(ctx, "chainID", "transactionID3", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when a per-channel maximum is configured", func() {
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(4, 0)
+				txContexts.MaxContextsPerChannel = 2
+				for _, txID := range []string{"transactionID1", "transactionID2"} {
+					_, err := txContexts.Create(ctx, "busy-chain", txID, nil, nil)
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+
+			It("returns ErrChannelQuotaExceeded for the channel at its cap", func() {
+				_, err := txContexts.Create(ctx, "busy-chain", "transactionID3", nil, nil)
+				Expect(err).To(MatchError("txid: transactionID3(busy-chain): too many active transaction contexts for channel"))
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrChannelQuotaExceeded))
+				Expect(txContexts.Count()).To(Equal(2))
+			})
+
+			It("allows other channels to create contexts up to the global limit", func() {
+				_, err := txContexts.Create(ctx, "quiet-chain", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = txContexts.Create(ctx, "other-chain", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = txContexts.Create(ctx, "third-chain", "transactionID1", nil, nil)
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrTooManyContexts))
+			})
+
+			It("allows creation once a context of the channel has been deleted", func() {
+				txContexts.Delete("busy-chain", "transactionID1")
+				_, err := txContexts.Create(ctx, "busy-chain", "transactionID3", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when a per-channel minimum is configured", func() {
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(4, 0)
+				txContexts.MinContextsPerChannel = 2
+				_, err := txContexts.Create(ctx, "quiet-chain", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				for _, txID := range []string{"transactionID1", "transactionID2"} {
+					_, err := txContexts.Create(ctx, "busy-chain", txID, nil, nil)
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+
+			It("does not let a busy channel take a slot guaranteed to another channel", func() {
+				_, err := txContexts.Create(ctx, "busy-chain", "transactionID3", nil, nil)
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrTooManyContexts))
+
+				_, err = txContexts.Create(ctx, "quiet-chain", "transactionID2", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContexts.Count()).To(Equal(4))
+			})
+
+			It("frees the guarantee once the channel has no active contexts", func() {
+				txContexts.Delete("quiet-chain", "transactionID1")
+				_, err := txContexts.Create(ctx, "busy-chain", "transactionID3", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		It("creates a response notifier with a buffer of one by default", func() {
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(cap(txContext.ResponseNotifier)).To(Equal(1))
 		})
 
-		It("returns the correct transaction context", func() {
-			c := txContexts.Get("chainID1", "transactionID1")
-			Expect(c).To(Equal(c1))
+		Context("when a response notifier size is configured", func() {
+			BeforeEach(func() {
+				txContexts.ResponseNotifierSize = 3
+			})
 
-			c = txContexts.Get("chainID1", "transactionID2")
-			Expect(c).To(Equal(c2))
+			It("creates a response notifier with the configured buffer", func() {
+				txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cap(txContext.ResponseNotifier)).To(Equal(3))
+			})
+		})
 
-			c = txContexts.Get("non-existent", "transactionID1")
-			Expect(c).To(BeNil())
+		Context("when a tx simulator is required", func() {
+			BeforeEach(func() {
+				txContexts.RequireTxSimulator = true
+			})
+
+			It("creates the context when the simulator is present", func() {
+				txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContext.TXSimulator).To(Equal(fakeTxSimulator))
+			})
+
+			It("returns a descriptive error when the simulator is missing", func() {
+				_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).To(MatchError("no tx simulator in context for txid: transactionID(chainID)"))
+				Expect(txContexts.Get("chainID", "transactionID")).To(BeNil())
+			})
+
+			It("creates a query-only context without a simulator", func() {
+				ctx := context.WithValue(context.Background(), chaincode.QueryOnlyKey, true)
+				txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContext.QueryOnly()).To(BeTrue())
+				Expect(txContext.TXSimulator).To(BeNil())
+			})
+		})
+
+		Context("when a tx simulator is not required", func() {
+			It("creates the context without a simulator", func() {
+				txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContext.TXSimulator).To(BeNil())
+			})
+		})
+
+		Context("when a rate limiter is configured", func() {
+			signedPropFor := func(creator string) *pb.SignedProposal {
+				return &pb.SignedProposal{
+					ProposalBytes: utils.MarshalOrPanic(&pb.Proposal{
+						Header: utils.MarshalOrPanic(&common.Header{
+							SignatureHeader: utils.MarshalOrPanic(&common.SignatureHeader{
+								Creator: utils.MarshalOrPanic(&msp.SerializedIdentity{Mspid: "msp", IdBytes: []byte(creator)}),
+							}),
+						}),
+					}),
+				}
+			}
+
+			BeforeEach(func() {
+				txContexts.RateLimiter = chaincode.NewCreatorRateLimiter(0.001, 2)
+			})
+
+			It("throttles a creator that exceeds its burst while others proceed", func() {
+				for i := 0; i < 2; i++ {
+					_, err := txContexts.Create(ctx, "chainID", fmt.Sprintf("transactionID%d", i), signedPropFor("creator-1"), proposal)
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				_, err := txContexts.Create(ctx, "chainID", "transactionID2", signedPropFor("creator-1"), proposal)
+				Expect(err).To(MatchError("txid: transactionID2(chainID): transaction context creation rate exceeded"))
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrRateLimited))
+				Expect(txContexts.Get("chainID", "transactionID2")).To(BeNil())
+
+				_, err = txContexts.Create(ctx, "chainID", "transactionID3", signedPropFor("creator-2"), proposal)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("throttles creators without an identity in a shared bucket", func() {
+				_, err := txContexts.Create(ctx, "chainID", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = txContexts.Create(ctx, "chainID", "transactionID2", &pb.SignedProposal{ProposalBytes: []byte("this-is-a-bogus-payload")}, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = txContexts.Create(ctx, "chainID", "transactionID3", nil, nil)
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrRateLimited))
+			})
+
+			It("does not charge creators for contexts rejected by a quota", func() {
+				txContexts.MaxContextsPerChannel = 1
+				_, err := txContexts.Create(ctx, "chainID", "transactionID1", signedPropFor("creator-1"), proposal)
+				Expect(err).NotTo(HaveOccurred())
+				for i := 0; i < 3; i++ {
+					_, err = txContexts.Create(ctx, "chainID", "transactionID2", signedPropFor("creator-1"), proposal)
+					Expect(errors.Cause(err)).To(Equal(chaincode.ErrChannelQuotaExceeded))
+				}
+
+				_, err = txContexts.Create(ctx, "chainID", "transactionID1", signedPropFor("creator-1"), proposal)
+				Expect(err).To(HaveOccurred())
+
+				_, err = txContexts.Create(ctx, "other-chainID", "transactionID3", signedPropFor("creator-1"), proposal)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("releases the slot of a throttled context", func() {
+				txContexts.MaxContextsPerChannel = 3
+				for i := 0; i < 2; i++ {
+					_, err := txContexts.Create(ctx, "chainID", fmt.Sprintf("transactionID%d", i), signedPropFor("creator-1"), proposal)
+					Expect(err).NotTo(HaveOccurred())
+				}
+				_, err := txContexts.Create(ctx, "chainID", "transactionID2", signedPropFor("creator-1"), proposal)
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrRateLimited))
+
+				_, err = txContexts.Create(ctx, "chainID", "transactionID3", signedPropFor("creator-2"), proposal)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContexts.Count()).To(Equal(3))
+			})
+
+			It("does not throttle high priority contexts", func() {
+				highPriorityCtx := context.WithValue(ctx, chaincode.PriorityKey, chaincode.PriorityHigh)
+				for i := 0; i < 5; i++ {
+					_, err := txContexts.Create(highPriorityCtx, "chainID", fmt.Sprintf("transactionID%d", i), signedPropFor("creator-1"), proposal)
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+		})
+
+		Context("when the maximum number of contexts is zero", func() {
+			It("does not limit the number of contexts", func() {
+				for i := 0; i < 100; i++ {
+					_, err := txContexts.Create(ctx, "chainID", fmt.Sprintf("transactionID%d", i), nil, nil)
+					Expect(err).NotTo(HaveOccurred())
+				}
+				Expect(txContexts.Count()).To(Equal(100))
+			})
 		})
 	})
 
-	Describe("Delete", func() {
+	Describe("NewTransactionContextsWithCapacity", func() {
+		It("creates a registry that holds more contexts than its capacity", func() {
+			txContexts = chaincode.NewTransactionContextsWithCapacity(0, 0, 2)
+			for _, txID := range []string{"tx1", "tx2", "tx3"} {
+				_, err := txContexts.Create(context.Background(), "chainID", txID, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(txContexts.Count()).To(Equal(3))
+		})
+	})
+
+	Describe("CreateWait", func() {
 		BeforeEach(func() {
-			_, err := txContexts.Create(context.Background(), "chainID2", "transactionID1", nil, nil)
+			txContexts = chaincode.NewTransactionContexts(1, 0)
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID1", nil, nil)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
-		It("removes transaction context", func() {
-			c := txContexts.Get("chainID2", "transactionID1")
-			Expect(c).NotTo(BeNil())
+		It("creates the context without waiting when a slot is free", func() {
+			txContexts.Delete("chainID", "transactionID1")
 
-			txContexts.Delete("chainID2", "transactionID1")
+			txContext, err := txContexts.CreateWait(context.Background(), "chainID", "transactionID2", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContexts.Get("chainID", "transactionID2")).To(Equal(txContext))
+		})
 
-			c = txContexts.Get("chainID2", "transactionID1")
-			Expect(c).To(BeNil())
+		It("blocks until a context is deleted", func() {
+			done := make(chan error, 1)
+			go func() {
+				_, err := txContexts.CreateWait(context.Background(), "chainID", "transactionID2", nil, nil)
+				done <- err
+			}()
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+			txContexts.Delete("chainID", "transactionID1")
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(txContexts.Get("chainID", "transactionID2")).NotTo(BeNil())
 		})
 
-		Context("when the context doesn't exist", func() {
-			It("keeps calm and carries on", func() {
-				txContexts.Delete("not-existent", "transactionID1")
-			})
+		It("returns the context error when ctx is cancelled while waiting", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				_, err := txContexts.CreateWait(ctx, "chainID", "transactionID2", nil, nil)
+				done <- err
+			}()
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+			cancel()
+			Eventually(done).Should(Receive(Equal(context.Canceled)))
+			Expect(txContexts.Get("chainID", "transactionID2")).To(BeNil())
 		})
-	})
 
-	Describe("Close", func() {
-		var fakeIterators []*mock.ResultsIterator
+		It("does not wait on errors other than the context limit", func() {
+			_, err := txContexts.CreateWait(context.Background(), "chainID", "transactionID1", nil, nil)
+			Expect(err).To(BeAssignableToTypeOf(&chaincode.ErrContextExists{}))
+		})
+	})
 
+	Describe("WaitChainDrained", func() {
 		BeforeEach(func() {
-			fakeIterators = make([]*mock.ResultsIterator, 6)
-			for i := 0; i < len(fakeIterators); i++ {
-				fakeIterators[i] = &mock.ResultsIterator{}
+			for _, id := range []struct{ chainID, txID string }{
+				{"chainID", "transactionID1"},
+				{"chainID", "transactionID2"},
+				{"other-chainID", "transactionID1"},
+			} {
+				_, err := txContexts.Create(context.Background(), id.chainID, id.txID, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
 			}
+		})
 
-			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+		waitChainDrained := func(ctx context.Context, chainID string) <-chan error {
+			done := make(chan error, 1)
+			go func() { done <- txContexts.WaitChainDrained(ctx, chainID) }()
+			return done
+		}
+
+		It("returns immediately when the chain has no contexts", func() {
+			Expect(txContexts.WaitChainDrained(context.Background(), "idle-chainID")).To(Succeed())
+		})
+
+		It("blocks until the last context on the chain is deleted", func() {
+			done := waitChainDrained(context.Background(), "chainID")
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+			txContexts.Delete("chainID", "transactionID1")
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+			txContexts.Delete("other-chainID", "transactionID1")
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+			txContexts.Delete("chainID", "transactionID2")
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("waits for contexts created on the chain while waiting", func() {
+			done := waitChainDrained(context.Background(), "chainID")
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID3", nil, nil)
 			Expect(err).NotTo(HaveOccurred())
-			txContext.InitializeQueryContext("key1", fakeIterators[0])
-			txContext.InitializeQueryContext("key2", fakeIterators[1])
-			txContext.InitializeQueryContext("key3", fakeIterators[2])
 
-			txContext2, err := txContexts.Create(context.Background(), "chainID", "transactionID2", nil, nil)
+			txContexts.Delete("chainID", "transactionID1")
+			txContexts.Delete("chainID", "transactionID2")
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+			txContexts.Delete("chainID", "transactionID3")
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("wakes every caller waiting on the chain", func() {
+			first := waitChainDrained(context.Background(), "chainID")
+			second := waitChainDrained(context.Background(), "chainID")
+			Consistently(first, 50*time.Millisecond).ShouldNot(Receive())
+
+			txContexts.Delete("chainID", "transactionID1")
+			txContexts.Delete("chainID", "transactionID2")
+			Eventually(first).Should(Receive(BeNil()))
+			Eventually(second).Should(Receive(BeNil()))
+		})
+
+		It("blocks again once a drained chain has new contexts", func() {
+			txContexts.Delete("chainID", "transactionID1")
+			txContexts.Delete("chainID", "transactionID2")
+			Expect(txContexts.WaitChainDrained(context.Background(), "chainID")).To(Succeed())
+
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID3", nil, nil)
 			Expect(err).NotTo(HaveOccurred())
-			txContext2.InitializeQueryContext("key1", fakeIterators[3])
-			txContext2.InitializeQueryContext("key2", fakeIterators[4])
-			txContext2.InitializeQueryContext("key3", fakeIterators[5])
+			done := waitChainDrained(context.Background(), "chainID")
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+			txContexts.Delete("chainID", "transactionID3")
+			Eventually(done).Should(Receive(BeNil()))
 		})
 
-		It("closes all iterators in iterator map", func() {
-			for _, ri := range fakeIterators {
-				Expect(ri.CloseCallCount()).To(Equal(0))
-			}
-			txContexts.Close()
-			for _, ri := range fakeIterators {
-				Expect(ri.CloseCallCount()).To(Equal(1))
-			}
+		It("returns once the contexts of the chain are transferred away", func() {
+			done := waitChainDrained(context.Background(), "chainID")
+			destination := chaincode.NewTransactionContexts(0, 0)
+			Expect(txContexts.Transfer("chainID", "transactionID1", destination)).To(Succeed())
+			Expect(txContexts.Transfer("chainID", "transactionID2", destination)).To(Succeed())
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(destination.WaitChainDrained(context.Background(), "other-chainID")).To(Succeed())
 		})
 
-		Context("when there are no contexts", func() {
-			BeforeEach(func() {
-				txContexts = chaincode.NewTransactionContexts()
-			})
+		It("returns the context error when ctx is cancelled while waiting", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := waitChainDrained(ctx, "chainID")
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
 
-			It("keeps calm and carries on", func() {
-				txContexts.Close()
-			})
+			cancel()
+			Eventually(done).Should(Receive(Equal(context.Canceled)))
+			Expect(txContexts.Get("chainID", "transactionID1")).NotTo(BeNil())
 		})
+	})
+
+	Describe("Validate", func() {
+		var ctx context.Context
+
+		BeforeEach(func() {
+			ctx = context.WithValue(context.Background(), chaincode.TXSimulatorKey, &mock.TxSimulator{})
+		})
+
+		It("succeeds without registering a context", func() {
+			err := txContexts.Validate(ctx, "chainID", "transactionID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContexts.Get("chainID", "transactionID")).To(BeNil())
+			Expect(txContexts.Count()).To(Equal(0))
+		})
+
+		Context("when the context already exists", func() {
+			var existing *chaincode.TransactionContext
 
-		Context("when there is a context with no query iterators", func() {
 			BeforeEach(func() {
-				_, err := txContexts.Create(context.Background(), "chainID", "no-query-iterators", nil, nil)
+				var err error
+				existing, err = txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
 				Expect(err).NotTo(HaveOccurred())
 			})
 
-			It("closes iterators associated with other contexts", func() {
-				txContexts.Close()
+			It("returns an error and leaves the registry unchanged", func() {
+				err := txContexts.Validate(ctx, "chainID", "transactionID")
+				Expect(err).To(MatchError("txid: transactionID(chainID) exists"))
+				Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(existing))
+				Expect(txContexts.Count()).To(Equal(1))
+			})
+		})
+
+		Context("when a tx simulator is required and missing", func() {
+			BeforeEach(func() {
+				txContexts.RequireTxSimulator = true
+			})
+
+			It("returns an error without registering a context", func() {
+				err := txContexts.Validate(context.Background(), "chainID", "transactionID")
+				Expect(err).To(MatchError("no tx simulator in context for txid: transactionID(chainID)"))
+				Expect(txContexts.Count()).To(Equal(0))
+			})
+
+			It("accepts a query-only context without a simulator", func() {
+				ctx := context.WithValue(context.Background(), chaincode.QueryOnlyKey, true)
+				Expect(txContexts.Validate(ctx, "chainID", "transactionID")).To(Succeed())
+			})
+		})
+	})
+
+	Describe("GetOrCreate", func() {
+		var (
+			signedProp      *pb.SignedProposal
+			proposal        *pb.Proposal
+			fakeTxSimulator *mock.TxSimulator
+			ctx             context.Context
+		)
+
+		BeforeEach(func() {
+			signedProp = &pb.SignedProposal{ProposalBytes: []byte("some-proposal-bytes")}
+			proposal = &pb.Proposal{Payload: []byte("some-payload-bytes")}
+			fakeTxSimulator = &mock.TxSimulator{}
+			ctx = context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+		})
+
+		It("creates a new transaction context", func() {
+			txContext, created, err := txContexts.GetOrCreate(ctx, "chainID", "transactionID", signedProp, proposal)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeTrue())
+			Expect(txContext.SignedProp).To(Equal(signedProp))
+			Expect(txContext.Proposal).To(Equal(proposal))
+			Expect(txContext.TXSimulator).To(Equal(fakeTxSimulator))
+			Expect(txContexts.Get("chainID", "transactionID")).To(Equal(txContext))
+		})
+
+		Context("when the transaction context already exists", func() {
+			var existing *chaincode.TransactionContext
+
+			BeforeEach(func() {
+				var err error
+				existing, err = txContexts.Create(ctx, "chainID", "transactionID", signedProp, proposal)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns the existing context as originally created", func() {
+				otherCtx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, &mock.TxSimulator{})
+				txContext, created, err := txContexts.GetOrCreate(otherCtx, "chainID", "transactionID", &pb.SignedProposal{}, &pb.Proposal{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(created).To(BeFalse())
+				Expect(txContext).To(BeIdenticalTo(existing))
+				Expect(txContext.SignedProp).To(Equal(signedProp))
+				Expect(txContext.Proposal).To(Equal(proposal))
+				Expect(txContext.TXSimulator).To(BeIdenticalTo(fakeTxSimulator))
+			})
+		})
+
+		Context("when the same context is requested concurrently", func() {
+			It("creates one context and returns it to every caller", func() {
+				var wg sync.WaitGroup
+				txctxs := make([]*chaincode.TransactionContext, 20)
+				created := make([]bool, 20)
+				for i := range txctxs {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						defer GinkgoRecover()
+						var err error
+						txctxs[i], created[i], err = txContexts.GetOrCreate(ctx, "chainID", "transactionID", signedProp, proposal)
+						Expect(err).NotTo(HaveOccurred())
+					}(i)
+				}
+				wg.Wait()
+
+				n := 0
+				for i, txctx := range txctxs {
+					Expect(txctx).To(BeIdenticalTo(txContexts.Get("chainID", "transactionID")))
+					if created[i] {
+						n++
+					}
+				}
+				Expect(n).To(Equal(1))
+				Expect(txContexts.Count()).To(Equal(1))
+			})
+		})
+
+		Context("when the maximum number of contexts has been reached", func() {
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(1, 0)
+				_, err := txContexts.Create(ctx, "chainID", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns ErrTooManyContexts", func() {
+				_, _, err := txContexts.GetOrCreate(ctx, "chainID", "transactionID2", nil, nil)
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrTooManyContexts))
+			})
+
+			It("still returns existing contexts", func() {
+				_, created, err := txContexts.GetOrCreate(ctx, "chainID", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(created).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("Insert", func() {
+		var txContext *chaincode.TransactionContext
+
+		BeforeEach(func() {
+			txContext = chaincode.NewTransactionContext("chainID", "transactionID", nil, nil)
+			txContext.TXSimulator = &mock.TxSimulator{}
+		})
+
+		It("registers the transaction context", func() {
+			err := txContexts.Insert(txContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+			Expect(txContexts.Count()).To(Equal(1))
+		})
+
+		It("provides a usable transaction context", func() {
+			err := txContexts.Insert(txContext)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContext.Notify(&pb.ChaincodeMessage{})).To(BeTrue())
+			Expect(txContext.RegisterIterator("query-id", &mock.ResultsIterator{})).To(Succeed())
+			Expect(txContext.GetPendingQueryResult("query-id")).NotTo(BeNil())
+		})
+
+		It("cancels the context of the transaction when deleted", func() {
+			err := txContexts.Insert(txContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.Context().Done()).NotTo(BeClosed())
+
+			txContexts.Delete("chainID", "transactionID")
+			Expect(txContext.Context().Err()).To(Equal(context.Canceled))
+		})
+
+		Context("when the transaction context already exists", func() {
+			BeforeEach(func() {
+				_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns a meaningful error", func() {
+				err := txContexts.Insert(txContext)
+				Expect(err).To(MatchError("txid: transactionID(chainID) exists"))
+				Expect(txContexts.Get("chainID", "transactionID")).NotTo(BeIdenticalTo(txContext))
+			})
+		})
+
+		Context("when the maximum number of contexts has been reached", func() {
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(1, 0)
+				_, err := txContexts.Create(context.Background(), "chainID", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns ErrTooManyContexts", func() {
+				err := txContexts.Insert(txContext)
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrTooManyContexts))
+			})
+		})
+	})
+
+	Describe("Tracing", func() {
+		var (
+			rootSpan  *fake.Span
+			childSpan *fake.Span
+			txContext *chaincode.TransactionContext
+		)
+
+		BeforeEach(func() {
+			rootSpan = &fake.Span{}
+			childSpan = &fake.Span{}
+			rootSpan.StartChildReturns(childSpan)
+
+			var err error
+			ctx := context.WithValue(context.Background(), chaincode.SpanKey, rootSpan)
+			txContext, err = txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("starts child spans from the span provided at creation", func() {
+			span := txContext.StartChildSpan("query")
+			Expect(span).To(BeIdenticalTo(childSpan))
+			Expect(rootSpan.StartChildCallCount()).To(Equal(1))
+			Expect(rootSpan.StartChildArgsForCall(0)).To(Equal("query"))
+		})
+
+		It("traces building query responses", func() {
+			iter := &mock.ResultsIterator{}
+			txContext.RegisterIterator("query-id", iter)
+
+			generator := &chaincode.QueryResponseGenerator{MaxResultLimit: 1}
+			_, err := generator.BuildQueryResponse(txContext, iter, "query-id")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(rootSpan.StartChildArgsForCall(0)).To(Equal("BuildQueryResponse"))
+			Expect(childSpan.FinishCallCount()).To(Equal(1))
+		})
+
+		It("finishes the root span when the context is deleted", func() {
+			Expect(rootSpan.FinishCallCount()).To(Equal(0))
+			txContexts.Delete("chainID", "transactionID")
+			Expect(rootSpan.FinishCallCount()).To(Equal(1))
+
+			txContexts.Delete("chainID", "transactionID")
+			Expect(rootSpan.FinishCallCount()).To(Equal(1))
+		})
+
+		Context("when no span is provided", func() {
+			It("returns a span that does nothing", func() {
+				txContext, err := txContexts.Create(context.Background(), "chainID", "untracedTransactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				span := txContext.StartChildSpan("query")
+				Expect(span).NotTo(BeNil())
+				Expect(span.StartChild("nested")).NotTo(BeNil())
+				span.Finish()
+				txContexts.Delete("chainID", "untracedTransactionID")
+			})
+		})
+	})
+
+	Describe("IteratorReusePolicy", func() {
+		var (
+			txContext   *chaincode.TransactionContext
+			oldIterator *mock.ResultsIterator
+			newIterator *mock.ResultsIterator
+		)
+
+		JustBeforeEach(func() {
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			oldIterator = &mock.ResultsIterator{}
+			newIterator = &mock.ResultsIterator{}
+			Expect(txContext.RegisterIterator("query-id", oldIterator)).To(Succeed())
+			Expect(txContext.GetPendingQueryResult("query-id").Add(&queryresult.KV{Key: "old-key"})).To(Succeed())
+		})
+
+		It("rejects a reused query ID by default", func() {
+			err := txContext.RegisterIterator("query-id", newIterator)
+			Expect(err).To(MatchError("query iterator query-id is already registered"))
+
+			Expect(txContext.GetIterator("query-id")).To(Equal(oldIterator))
+			Expect(oldIterator.CloseCallCount()).To(Equal(0))
+		})
+
+		Context("when the policy is replace", func() {
+			BeforeEach(func() {
+				txContexts.IteratorReusePolicy = chaincode.IteratorReuseReplace
+			})
+
+			It("closes the old iterator and installs the new one", func() {
+				Expect(txContext.RegisterIterator("query-id", newIterator)).To(Succeed())
+
+				Expect(oldIterator.CloseCallCount()).To(Equal(1))
+				Expect(txContext.GetIterator("query-id")).To(Equal(newIterator))
+				Expect(txContext.GetPendingQueryResult("query-id").Size()).To(Equal(0))
+				Expect(newIterator.CloseCallCount()).To(Equal(0))
+			})
+
+			It("does not count the replaced iterator against the limit", func() {
+				txContexts = chaincode.NewTransactionContexts(0, 1)
+				txContexts.IteratorReusePolicy = chaincode.IteratorReuseReplace
+				txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(txContext.RegisterIterator("query-id", oldIterator)).To(Succeed())
+				Expect(txContext.RegisterIterator("query-id", newIterator)).To(Succeed())
+				Expect(txContext.RegisterIterator("other-query-id", &mock.ResultsIterator{})).To(Equal(chaincode.ErrTooManyQueryIterators))
+			})
+		})
+	})
+
+	Describe("IteratorWrapper", func() {
+		var (
+			nextCalls  int32
+			closeCalls int32
+		)
+
+		BeforeEach(func() {
+			nextCalls, closeCalls = 0, 0
+			txContexts.IteratorWrapper = func(iter commonledger.ResultsIterator) commonledger.ResultsIterator {
+				return &countingIterator{ResultsIterator: iter, nextCalls: &nextCalls, closeCalls: &closeCalls}
+			}
+		})
+
+		It("wraps every registered iterator", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			var iters []*mock.ResultsIterator
+			for i := 0; i < 3; i++ {
+				iter := &mock.ResultsIterator{}
+				iters = append(iters, iter)
+				err := txContext.RegisterIterator(fmt.Sprintf("query-id-%d", i), iter)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			for i, iter := range iters {
+				wrapped := txContext.GetIterator(fmt.Sprintf("query-id-%d", i))
+				Expect(wrapped).To(BeAssignableToTypeOf(&countingIterator{}))
+				wrapped.Next()
+				Expect(iter.NextCallCount()).To(Equal(1))
+			}
+			Expect(atomic.LoadInt32(&nextCalls)).To(Equal(int32(3)))
+
+			txContexts.DeleteAndClose("chainID", "transactionID")
+			Expect(atomic.LoadInt32(&closeCalls)).To(Equal(int32(3)))
+			for _, iter := range iters {
+				Expect(iter.CloseCallCount()).To(Equal(1))
+			}
+		})
+
+		It("wraps the iterators of child contexts", func() {
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			child, err := txContexts.CreateChild("chainID", "transactionID", "childChainID", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = child.RegisterIterator("query-id", &mock.ResultsIterator{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(child.GetIterator("query-id")).To(BeAssignableToTypeOf(&countingIterator{}))
+		})
+
+		Context("when the wrapper is nil", func() {
+			It("registers iterators unchanged", func() {
+				txContexts.IteratorWrapper = nil
+				txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				iter := &mock.ResultsIterator{}
+				err = txContext.RegisterIterator("query-id", iter)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContext.GetIterator("query-id")).To(BeIdenticalTo(iter))
+			})
+		})
+	})
+
+	Describe("CreateChild", func() {
+		var (
+			parent          *chaincode.TransactionContext
+			fakeTxSimulator *mock.TxSimulator
+			childProposal   *pb.Proposal
+		)
+
+		BeforeEach(func() {
+			fakeTxSimulator = &mock.TxSimulator{}
+			ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+
+			var err error
+			parent, err = txContexts.Create(ctx, "parentChainID", "transactionID", &pb.SignedProposal{}, &pb.Proposal{})
+			Expect(err).NotTo(HaveOccurred())
+			childProposal = &pb.Proposal{Payload: []byte("child-payload")}
+		})
+
+		It("creates a child context with its own proposal", func() {
+			child, err := txContexts.CreateChild("parentChainID", "transactionID", "childChainID", childProposal)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(child.ChainID).To(Equal("childChainID"))
+			Expect(child.TxID).To(Equal("transactionID"))
+			Expect(child.Proposal).To(Equal(childProposal))
+			Expect(child.SignedProp).To(Equal(parent.SignedProp))
+			Expect(child.Parent()).To(BeIdenticalTo(parent))
+		})
+
+		It("shares the tx simulator of the parent", func() {
+			child, err := txContexts.CreateChild("parentChainID", "transactionID", "childChainID", childProposal)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(child.TXSimulator).To(BeIdenticalTo(fakeTxSimulator))
+		})
+
+		It("inherits the query-only flag of the parent", func() {
+			ctx := context.WithValue(context.Background(), chaincode.QueryOnlyKey, true)
+			_, err := txContexts.Create(ctx, "parentChainID", "queryTransactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			child, err := txContexts.CreateChild("parentChainID", "queryTransactionID", "childChainID", childProposal)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(child.QueryOnly()).To(BeTrue())
+			Expect(child.TxSimulatorMissing()).To(BeFalse())
+		})
+
+		It("has its own iterator namespace", func() {
+			parentIterator := &mock.ResultsIterator{}
+			parent.RegisterIterator("query-id", parentIterator)
+
+			child, err := txContexts.CreateChild("parentChainID", "transactionID", "childChainID", childProposal)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(child.GetIterator("query-id")).To(BeNil())
+
+			childIterator := &mock.ResultsIterator{}
+			child.RegisterIterator("query-id", childIterator)
+			Expect(parent.GetIterator("query-id")).To(Equal(parentIterator))
+			Expect(child.GetIterator("query-id")).To(Equal(childIterator))
+		})
+
+		It("does not register the child context", func() {
+			_, err := txContexts.CreateChild("parentChainID", "transactionID", "childChainID", childProposal)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContexts.Get("childChainID", "transactionID")).To(BeNil())
+			Expect(txContexts.Count()).To(Equal(1))
+		})
+
+		It("closes the child when the parent is deleted", func() {
+			child, err := txContexts.CreateChild("parentChainID", "transactionID", "childChainID", childProposal)
+			Expect(err).NotTo(HaveOccurred())
+			childIterator := &mock.ResultsIterator{}
+			child.RegisterIterator("query-id", childIterator)
+
+			txContexts.Delete("parentChainID", "transactionID")
+
+			Expect(childIterator.CloseCallCount()).To(Equal(1))
+			Expect(child.GetIterator("query-id")).To(BeNil())
+			Expect(child.Context().Err()).To(Equal(context.Canceled))
+		})
+
+		Context("when the parent context doesn't exist", func() {
+			It("returns an error", func() {
+				_, err := txContexts.CreateChild("parentChainID", "missing-transactionID", "childChainID", childProposal)
+				Expect(err).To(MatchError("txid: missing-transactionID(parentChainID) does not exist"))
+			})
+		})
+	})
+
+	Describe("Get", func() {
+		var c1, c2 *chaincode.TransactionContext
+
+		BeforeEach(func() {
+			var err error
+			c1, err = txContexts.Create(context.Background(), "chainID1", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			c2, err = txContexts.Create(context.Background(), "chainID1", "transactionID2", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the correct transaction context", func() {
+			c := txContexts.Get("chainID1", "transactionID1")
+			Expect(c).To(Equal(c1))
+
+			c = txContexts.Get("chainID1", "transactionID2")
+			Expect(c).To(Equal(c2))
+
+			c = txContexts.Get("non-existent", "transactionID1")
+			Expect(c).To(BeNil())
+		})
+
+		Context("when chain and transaction IDs concatenate to the same value", func() {
+			var c3, c4 *chaincode.TransactionContext
+
+			BeforeEach(func() {
+				var err error
+				c3, err = txContexts.Create(context.Background(), "ab", "c", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				c4, err = txContexts.Create(context.Background(), "a", "bc", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("keeps the contexts distinct", func() {
+				Expect(txContexts.Get("ab", "c")).To(BeIdenticalTo(c3))
+				Expect(txContexts.Get("a", "bc")).To(BeIdenticalTo(c4))
+
+				txContexts.Delete("ab", "c")
+				Expect(txContexts.Get("ab", "c")).To(BeNil())
+				Expect(txContexts.Get("a", "bc")).To(BeIdenticalTo(c4))
+			})
+		})
+	})
+
+	Describe("GetErr", func() {
+		var txContext *chaincode.TransactionContext
+
+		BeforeEach(func() {
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the context when it exists", func() {
+			c, err := txContexts.GetErr("chainID", "transactionID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(BeIdenticalTo(txContext))
+			Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+		})
+
+		It("returns a not found error when the context does not exist", func() {
+			c, err := txContexts.GetErr("chainID", "missing-transactionID")
+			Expect(c).To(BeNil())
+			Expect(err).To(MatchError("txid: missing-transactionID(chainID) does not exist"))
+			Expect(err).To(BeAssignableToTypeOf(&chaincode.ErrContextNotFound{}))
+			Expect(err.(*chaincode.ErrContextNotFound).ChainID).To(Equal("chainID"))
+			Expect(err.(*chaincode.ErrContextNotFound).TxID).To(Equal("missing-transactionID"))
+			Expect(txContexts.Get("chainID", "missing-transactionID")).To(BeNil())
+		})
+
+		It("returns a not found error once the context is deleted", func() {
+			txContexts.Delete("chainID", "transactionID")
+			_, err := txContexts.GetErr("chainID", "transactionID")
+			Expect(err).To(BeAssignableToTypeOf(&chaincode.ErrContextNotFound{}))
+		})
+	})
+
+	Describe("GetByHandle", func() {
+		It("round-trips the handle of a created context", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.Handle()).NotTo(BeEmpty())
+
+			Expect(txContexts.GetByHandle(txContext.Handle())).To(BeIdenticalTo(txContext))
+		})
+
+		It("invalidates the handle when the context is deleted", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			handle := txContext.Handle()
+
+			txContexts.Delete("chainID", "transactionID")
+			Expect(txContexts.GetByHandle(handle)).To(BeNil())
+
+			recreated, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recreated.Handle()).NotTo(Equal(handle))
+			Expect(txContexts.GetByHandle(handle)).To(BeNil())
+		})
+
+		It("keeps the handle when the context is transferred", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			destination := chaincode.NewTransactionContexts(0, 0)
+			Expect(txContexts.Transfer("chainID", "transactionID", destination)).To(Succeed())
+			Expect(txContexts.GetByHandle(txContext.Handle())).To(BeNil())
+			Expect(destination.GetByHandle(txContext.Handle())).To(BeIdenticalTo(txContext))
+		})
+
+		It("returns nil for unknown handles", func() {
+			Expect(txContexts.GetByHandle("")).To(BeNil())
+			Expect(txContexts.GetByHandle("bogus")).To(BeNil())
+			Expect(txContexts.GetByHandle("1/bogus")).To(BeNil())
+		})
+	})
+
+	Describe("GetByChain", func() {
+		var c1, c2, c3 *chaincode.TransactionContext
+
+		BeforeEach(func() {
+			var err error
+			c1, err = txContexts.Create(context.Background(), "chainID1", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			c2, err = txContexts.Create(context.Background(), "chainID1", "transactionID2", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			c3, err = txContexts.Create(context.Background(), "chainID2", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the contexts associated with the chain", func() {
+			Expect(txContexts.GetByChain("chainID1")).To(ConsistOf(c1, c2))
+			Expect(txContexts.GetByChain("chainID2")).To(ConsistOf(c3))
+		})
+
+		Context("when the chain has no contexts", func() {
+			It("returns an empty result", func() {
+				Expect(txContexts.GetByChain("non-existent")).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("Admission", func() {
+		var (
+			calls      []string
+			signedProp *pb.SignedProposal
+			proposal   *pb.Proposal
+		)
+
+		admit := func(name string, err error) chaincode.AdmissionFunc {
+			return func(chainID, txID string, sp *pb.SignedProposal, p *pb.Proposal) error {
+				Expect(chainID).To(Equal("chainID"))
+				Expect(txID).To(Equal("transactionID"))
+				Expect(sp).To(BeIdenticalTo(signedProp))
+				Expect(p).To(BeIdenticalTo(proposal))
+				calls = append(calls, name)
+				return err
+			}
+		}
+
+		BeforeEach(func() {
+			calls = nil
+			signedProp = &pb.SignedProposal{}
+			proposal = &pb.Proposal{}
+		})
+
+		It("runs the admission functions in order", func() {
+			txContexts = chaincode.NewTransactionContexts(0, 0, admit("first", nil), admit("second", nil))
+
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", signedProp, proposal)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext).NotTo(BeNil())
+			Expect(calls).To(Equal([]string{"first", "second"}))
+		})
+
+		It("aborts creation at the first veto", func() {
+			txContexts = chaincode.NewTransactionContexts(0, 0, admit("allowlist", nil), admit("size", errors.New("proposal too large")), admit("never", nil))
+
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", signedProp, proposal)
+			Expect(err).To(MatchError("admission denied for txid: transactionID(chainID): proposal too large"))
+			Expect(errors.Cause(err)).To(MatchError("proposal too large"))
+			Expect(txContext).To(BeNil())
+			Expect(calls).To(Equal([]string{"allowlist", "size"}))
+			Expect(txContexts.Count()).To(Equal(0))
+		})
+
+		It("applies to inserted contexts", func() {
+			txContexts = chaincode.NewTransactionContexts(0, 0, admit("veto", errors.New("not allowed")))
+
+			err := txContexts.Insert(chaincode.NewTransactionContext("chainID", "transactionID", signedProp, proposal))
+			Expect(err).To(MatchError("admission denied for txid: transactionID(chainID): not allowed"))
+			Expect(txContexts.Count()).To(Equal(0))
+		})
+
+		It("admits every context when no admission functions are provided", func() {
+			txContexts = chaincode.NewTransactionContexts(0, 0)
+
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID", signedProp, proposal)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("Delete", func() {
+		BeforeEach(func() {
+			_, err := txContexts.Create(context.Background(), "chainID2", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("removes transaction context", func() {
+			c := txContexts.Get("chainID2", "transactionID1")
+			Expect(c).NotTo(BeNil())
+
+			txContexts.Delete("chainID2", "transactionID1")
+
+			c = txContexts.Get("chainID2", "transactionID1")
+			Expect(c).To(BeNil())
+		})
+
+		It("cancels the context of the transaction", func() {
+			c := txContexts.Get("chainID2", "transactionID1")
+			Expect(c.Context().Done()).NotTo(BeClosed())
+
+			txContexts.Delete("chainID2", "transactionID1")
+			Expect(c.Context().Done()).To(BeClosed())
+			Expect(c.Context().Err()).To(Equal(context.Canceled))
+
+			txContexts.Delete("chainID2", "transactionID1")
+			Expect(c.Context().Err()).To(Equal(context.Canceled))
+		})
+
+		It("runs delete hooks in registration order after removing the context", func() {
+			c := txContexts.Get("chainID2", "transactionID1")
+
+			var calls []string
+			c.OnDelete(func() {
+				Expect(txContexts.Count()).To(Equal(0))
+				calls = append(calls, "first")
+			})
+			c.OnDelete(func() { calls = append(calls, "second") })
+
+			txContexts.Delete("chainID2", "transactionID1")
+			Expect(calls).To(Equal([]string{"first", "second"}))
+
+			txContexts.Delete("chainID2", "transactionID1")
+			Expect(calls).To(HaveLen(2))
+		})
+
+		It("allows delete hooks to call back into the registry", func() {
+			c := txContexts.Get("chainID2", "transactionID1")
+
+			var found *chaincode.TransactionContext
+			c.OnDelete(func() {
+				found = txContexts.Get("chainID2", "transactionID1")
+				_, err := txContexts.Create(context.Background(), "chainID2", "replacement-transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			txContexts.Delete("chainID2", "transactionID1")
+			Expect(found).To(BeNil())
+			Expect(txContexts.Get("chainID2", "replacement-transactionID")).NotTo(BeNil())
+		})
+
+		It("runs delete hooks outside of the registry locks when removing all contexts", func() {
+			var counts []int
+			for _, c := range txContexts.GetByChain("chainID2") {
+				c.OnDelete(func() { counts = append(counts, txContexts.Count()) })
+			}
+
+			Expect(txContexts.Purge()).NotTo(BeEmpty())
+			Expect(counts).NotTo(BeEmpty())
+			for _, n := range counts {
+				Expect(n).To(Equal(0))
+			}
+		})
+
+		It("recovers from panicking delete hooks", func() {
+			c := txContexts.Get("chainID2", "transactionID1")
+
+			var calls []string
+			c.OnDelete(func() { panic("boom") })
+			c.OnDelete(func() { calls = append(calls, "after-panic") })
+
+			Expect(func() { txContexts.Delete("chainID2", "transactionID1") }).NotTo(Panic())
+			Expect(calls).To(Equal([]string{"after-panic"}))
+			Expect(txContexts.Count()).To(Equal(0))
+
+			_, err := txContexts.Create(context.Background(), "chainID2", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("leaves a deleted context able to track iterators", func() {
+			c := txContexts.Get("chainID2", "transactionID1")
+			txContexts.Delete("chainID2", "transactionID1")
+
+			iter := &mock.ResultsIterator{}
+			Expect(c.RegisterIterator("query-id", iter)).To(Succeed())
+			Expect(c.GetIterator("query-id")).To(Equal(iter))
+			c.CleanupQueryContext("query-id")
+			Expect(iter.CloseCallCount()).To(Equal(1))
+		})
+
+		It("reports whether a context was removed", func() {
+			Expect(txContexts.Delete("chainID2", "transactionID1")).To(BeTrue())
+			Expect(txContexts.Delete("chainID2", "transactionID1")).To(BeFalse())
+		})
+
+		Context("when the context doesn't exist", func() {
+			It("keeps calm and carries on", func() {
+				Expect(txContexts.Delete("not-existent", "transactionID1")).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("DeleteAndClose", func() {
+		var (
+			txContext        *chaincode.TransactionContext
+			resultsIterators []*mock.ResultsIterator
+		)
+
+		BeforeEach(func() {
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			resultsIterators = nil
+			for i := 0; i < 3; i++ {
+				iter := &mock.ResultsIterator{}
+				resultsIterators = append(resultsIterators, iter)
+				err := txContext.RegisterIterator(fmt.Sprintf("query-id-%d", i), iter)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("closes each open iterator exactly once", func() {
+			txContexts.DeleteAndClose("chainID", "transactionID")
+			for _, iter := range resultsIterators {
+				Expect(iter.CloseCallCount()).To(Equal(1))
+			}
+
+			txContexts.DeleteAndClose("chainID", "transactionID")
+			for _, iter := range resultsIterators {
+				Expect(iter.CloseCallCount()).To(Equal(1))
+			}
+		})
+
+		It("discards query state and removes the context", func() {
+			txContexts.DeleteAndClose("chainID", "transactionID")
+
+			Expect(txContext.GetIterator("query-id-0")).To(BeNil())
+			Expect(txContext.GetPendingQueryResult("query-id-0")).To(BeNil())
+			Expect(txContexts.Get("chainID", "transactionID")).To(BeNil())
+			Expect(txContext.Context().Err()).To(Equal(context.Canceled))
+		})
+
+		Context("when the context doesn't exist", func() {
+			It("keeps calm and carries on", func() {
+				txContexts.DeleteAndClose("not-existent", "transactionID")
+				Expect(txContexts.Count()).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("Acquire", func() {
+		var (
+			txContext      *chaincode.TransactionContext
+			txSimulator    *mock.TxSimulator
+			fakeIterator   *mock.ResultsIterator
+			deleteHookRuns int
+		)
+
+		BeforeEach(func() {
+			txSimulator = &mock.TxSimulator{}
+			ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, txSimulator)
+
+			var err error
+			txContext, err = txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeIterator = &mock.ResultsIterator{}
+			Expect(txContext.RegisterIterator("query-id", fakeIterator)).To(Succeed())
+			deleteHookRuns = 0
+			txContext.OnDelete(func() { deleteHookRuns++ })
+		})
+
+		It("returns the registered context", func() {
+			leased, release, ok := txContexts.Acquire("chainID", "transactionID")
+			defer release()
+			Expect(ok).To(BeTrue())
+			Expect(leased).To(BeIdenticalTo(txContext))
+		})
+
+		It("defers teardown of a deleted context until the lease is released", func() {
+			_, release, ok := txContexts.Acquire("chainID", "transactionID")
+			Expect(ok).To(BeTrue())
+
+			txContexts.DeleteAndClose("chainID", "transactionID")
+			Expect(txContexts.Get("chainID", "transactionID")).To(BeNil())
+			Expect(txContexts.Count()).To(Equal(0))
+			Expect(fakeIterator.CloseCallCount()).To(Equal(0))
+			Expect(txContext.GetIterator("query-id")).To(Equal(fakeIterator))
+			Expect(txContext.Context().Err()).NotTo(HaveOccurred())
+			Expect(deleteHookRuns).To(Equal(0))
+
+			release()
+			Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+			Expect(txContext.GetIterator("query-id")).To(BeNil())
+			Expect(txContext.Context().Err()).To(Equal(context.Canceled))
+			Expect(deleteHookRuns).To(Equal(1))
+		})
+
+		DescribeTable("defers closing the iterators of a removed context until the lease is released",
+			func(remove func(), releasesSimulator bool) {
+				_, release, ok := txContexts.Acquire("chainID", "transactionID")
+				Expect(ok).To(BeTrue())
+
+				remove()
+				Expect(txContexts.Get("chainID", "transactionID")).To(BeNil())
+				Expect(fakeIterator.CloseCallCount()).To(Equal(0))
+				Expect(txSimulator.DoneCallCount()).To(Equal(0))
+				Expect(deleteHookRuns).To(Equal(0))
+
+				release()
+				Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+				if releasesSimulator {
+					Expect(txSimulator.DoneCallCount()).To(Equal(1))
+				}
+				Expect(deleteHookRuns).To(Equal(1))
+			},
+			Entry("DeleteBatch", func() { txContexts.DeleteBatch("chainID", []string{"transactionID"}) }, false),
+			Entry("CloseChain", func() { txContexts.CloseChain("chainID") }, false),
+			Entry("Reap", func() { txContexts.Reap(-time.Hour) }, true),
+			Entry("Evict", func() { txContexts.Evict(0) }, true),
+			Entry("CloseGracefully", func() { txContexts.CloseGracefully(context.Background()) }, false),
+			Entry("Purge", func() { txContexts.Purge() }, false),
+			Entry("Quarantine", func() { txContexts.Quarantine("chainID", "transactionID", errors.New("failed")) }, false),
+		)
+
+		Context("when the transaction duration is exceeded", func() {
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(0, 0)
+				txContexts.MaxTransactionDuration = 50 * time.Millisecond
+
+				var err error
+				txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				fakeIterator = &mock.ResultsIterator{}
+				Expect(txContext.RegisterIterator("query-id", fakeIterator)).To(Succeed())
+			})
+
+			It("defers closing the iterators of the expired context until the lease is released", func() {
+				_, release, ok := txContexts.Acquire("chainID", "transactionID")
+				Expect(ok).To(BeTrue())
+
+				Eventually(func() *chaincode.TransactionContext { return txContexts.Get("chainID", "transactionID") }).Should(BeNil())
+				Expect(fakeIterator.CloseCallCount()).To(Equal(0))
+
+				release()
+				Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+			})
+		})
+
+		It("waits for every lease to be released", func() {
+			_, release1, _ := txContexts.Acquire("chainID", "transactionID")
+			_, release2, _ := txContexts.Acquire("chainID", "transactionID")
+
+			txContexts.DeleteAndClose("chainID", "transactionID")
+			release1()
+			release1()
+			Expect(fakeIterator.CloseCallCount()).To(Equal(0))
+
+			release2()
+			Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+		})
+
+		It("does not delay teardown once released", func() {
+			_, release, _ := txContexts.Acquire("chainID", "transactionID")
+			release()
+
+			txContexts.DeleteAndClose("chainID", "transactionID")
+			Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+			Expect(deleteHookRuns).To(Equal(1))
+		})
+
+		Context("when the context doesn't exist", func() {
+			It("returns false and a release that does nothing", func() {
+				leased, release, ok := txContexts.Acquire("chainID", "missing-transactionID")
+				Expect(ok).To(BeFalse())
+				Expect(leased).To(BeNil())
+				release()
+			})
+		})
+	})
+
+	Describe("DeleteBatch", func() {
+		var resultsIterators map[string]*mock.ResultsIterator
+
+		BeforeEach(func() {
+			resultsIterators = map[string]*mock.ResultsIterator{}
+			for i := 0; i < 5; i++ {
+				txID := fmt.Sprintf("transactionID%d", i)
+				txContext, err := txContexts.Create(context.Background(), "chainID", txID, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				resultsIterators[txID] = &mock.ResultsIterator{}
+				txContext.RegisterIterator("query-id", resultsIterators[txID])
+			}
+			_, err := txContexts.Create(context.Background(), "otherChainID", "transactionID0", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("removes the listed contexts and closes their iterators", func() {
+			txContexts.DeleteBatch("chainID", []string{"transactionID0", "transactionID2", "transactionID4", "missing"})
+
+			for _, txID := range []string{"transactionID0", "transactionID2", "transactionID4"} {
+				Expect(txContexts.Get("chainID", txID)).To(BeNil())
+				Expect(resultsIterators[txID].CloseCallCount()).To(Equal(1))
+			}
+			for _, txID := range []string{"transactionID1", "transactionID3"} {
+				Expect(txContexts.Get("chainID", txID)).NotTo(BeNil())
+				Expect(resultsIterators[txID].CloseCallCount()).To(Equal(0))
+			}
+			Expect(txContexts.Get("otherChainID", "transactionID0")).NotTo(BeNil())
+			Expect(txContexts.Count()).To(Equal(3))
+		})
+
+		Context("when a transaction ID is listed more than once", func() {
+			It("removes the context once", func() {
+				txContexts.DeleteBatch("chainID", []string{"transactionID1", "transactionID1"})
+				Expect(txContexts.Get("chainID", "transactionID1")).To(BeNil())
+				Expect(resultsIterators["transactionID1"].CloseCallCount()).To(Equal(1))
+				Expect(txContexts.Count()).To(Equal(5))
+			})
+		})
+	})
+
+	Describe("MaxTransactionDuration", func() {
+		BeforeEach(func() {
+			txContexts.MaxTransactionDuration = 50 * time.Millisecond
+		})
+
+		Context("when a transaction exceeds the maximum duration", func() {
+			var (
+				txContext       *chaincode.TransactionContext
+				resultsIterator *mock.ResultsIterator
+			)
+
+			BeforeEach(func() {
+				var err error
+				txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				resultsIterator = &mock.ResultsIterator{}
+				txContext.RegisterIterator("query-id", resultsIterator)
+			})
+
+			It("sends a timeout message on the response notifier", func() {
+				var msg *pb.ChaincodeMessage
+				Eventually(txContext.ResponseNotifier).Should(Receive(&msg))
+				Expect(msg.Type).To(Equal(pb.ChaincodeMessage_ERROR))
+				Expect(msg.Txid).To(Equal("transactionID"))
+				Expect(msg.ChannelId).To(Equal("chainID"))
+				Expect(txContext.Err()).To(Equal(chaincode.ErrTransactionTimeout))
+			})
+
+			It("closes the iterators and removes the context", func() {
+				Eventually(func() *chaincode.TransactionContext { return txContexts.Get("chainID", "transactionID") }).Should(BeNil())
+				Expect(resultsIterator.CloseCallCount()).To(Equal(1))
+				Expect(txContext.Context().Err()).To(HaveOccurred())
+			})
+
+			It("sets a deadline on the transaction's context", func() {
+				_, ok := txContext.Context().Deadline()
+				Expect(ok).To(BeTrue())
+			})
+		})
+
+		Context("when a transaction is deleted before the maximum duration", func() {
+			It("does not time out", func() {
+				txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				txContexts.Delete("chainID", "transactionID")
+
+				Consistently(txContext.ResponseNotifier, 150*time.Millisecond).ShouldNot(Receive())
+				Expect(txContext.Err()).NotTo(HaveOccurred())
+				Expect(txContext.Context().Err()).To(Equal(context.Canceled))
+			})
+
+			It("does not remove a new context with the same ID", func() {
+				_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				txContexts.Delete("chainID", "transactionID")
+
+				txContexts.MaxTransactionDuration = 0
+				txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Consistently(func() *chaincode.TransactionContext { return txContexts.Get("chainID", "transactionID") }, 150*time.Millisecond).Should(Equal(txContext))
+			})
+		})
+	})
+
+	Describe("TrackRWSetStats", func() {
+		var (
+			fakeTxSimulator *mock.TxSimulator
+			ctx             context.Context
+		)
+
+		BeforeEach(func() {
+			fakeTxSimulator = &mock.TxSimulator{}
+			fakeTxSimulator.GetStateReturns([]byte("value"), nil)
+			ctx = context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+			txContexts.TrackRWSetStats = true
+		})
+
+		It("counts the keys read and written through the simulator", func() {
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			sim := txContext.TXSimulator
+			value, err := sim.GetState("namespace", "key1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal([]byte("value")))
+			sim.GetPrivateData("namespace", "collection", "key2")
+			sim.GetStateMultipleKeys("namespace", []string{"key3", "key4"})
+			Expect(sim.SetState("namespace", "key1", []byte("new-value"))).To(Succeed())
+			Expect(sim.DeleteState("namespace", "key3")).To(Succeed())
+
+			reads, writes := txContext.RWSetStats()
+			Expect(reads).To(Equal(4))
+			Expect(writes).To(Equal(2))
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(1))
+			Expect(fakeTxSimulator.SetStateCallCount()).To(Equal(1))
+		})
+
+		It("keeps counting after the simulator is replaced", func() {
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext.TXSimulator.GetState("namespace", "key")
+
+			replacement := &mock.TxSimulator{}
+			Expect(txContexts.Replace("chainID", "transactionID", replacement)).To(Succeed())
+			txContext.TXSimulator.GetState("namespace", "key")
+
+			reads, _ := txContext.RWSetStats()
+			Expect(reads).To(Equal(2))
+			Expect(replacement.GetStateCallCount()).To(Equal(1))
+		})
+
+		Context("when tracking is disabled", func() {
+			BeforeEach(func() {
+				txContexts.TrackRWSetStats = false
+			})
+
+			It("leaves the simulator unwrapped and reports no counts", func() {
+				txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContext.TXSimulator).To(BeIdenticalTo(fakeTxSimulator))
+
+				txContext.TXSimulator.GetState("namespace", "key")
+				reads, writes := txContext.RWSetStats()
+				Expect(reads).To(Equal(0))
+				Expect(writes).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("CacheReads", func() {
+		var (
+			fakeTxSimulator *mock.TxSimulator
+			txContext       *chaincode.TransactionContext
+		)
+
+		BeforeEach(func() {
+			fakeTxSimulator = &mock.TxSimulator{}
+			fakeTxSimulator.GetStateReturns([]byte("value"), nil)
+			fakeTxSimulator.GetPrivateDataReturns([]byte("private-value"), nil)
+			ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+			txContexts.CacheReads = true
+
+			var err error
+			txContext, err = txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("serves repeated reads of a key from the cache", func() {
+			for i := 0; i < 3; i++ {
+				value, err := txContext.TXSimulator.GetState("namespace", "key")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(Equal([]byte("value")))
+			}
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(1))
+
+			txContext.TXSimulator.GetState("namespace", "other-key")
+			txContext.TXSimulator.GetState("other-namespace", "key")
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(3))
+		})
+
+		It("caches private data separately from public state", func() {
+			txContext.TXSimulator.GetState("namespace", "key")
+			value, err := txContext.TXSimulator.GetPrivateData("namespace", "collection", "key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal([]byte("private-value")))
+			txContext.TXSimulator.GetPrivateData("namespace", "collection", "key")
+			Expect(fakeTxSimulator.GetPrivateDataCallCount()).To(Equal(1))
+		})
+
+		It("caches keys that do not exist", func() {
+			fakeTxSimulator.GetStateReturns(nil, nil)
+			txContext.TXSimulator.GetState("namespace", "missing-key")
+			value, err := txContext.TXSimulator.GetState("namespace", "missing-key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(BeNil())
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(1))
+		})
+
+		It("does not cache failed reads", func() {
+			fakeTxSimulator.GetStateReturnsOnCall(0, nil, errors.New("boom"))
+			_, err := txContext.TXSimulator.GetState("namespace", "key")
+			Expect(err).To(MatchError("boom"))
+
+			value, err := txContext.TXSimulator.GetState("namespace", "key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal([]byte("value")))
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(2))
+		})
+
+		It("invalidates a key when it is written", func() {
+			txContext.TXSimulator.GetState("namespace", "key")
+			txContext.TXSimulator.GetState("namespace", "other-key")
+			Expect(txContext.TXSimulator.SetState("namespace", "key", []byte("new-value"))).To(Succeed())
+			fakeTxSimulator.GetStateReturns([]byte("new-value"), nil)
+
+			value, err := txContext.TXSimulator.GetState("namespace", "key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal([]byte("new-value")))
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(3))
+
+			txContext.TXSimulator.GetState("namespace", "other-key")
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(3))
+		})
+
+		It("invalidates a key when it is deleted", func() {
+			txContext.TXSimulator.GetState("namespace", "key")
+			Expect(txContext.TXSimulator.DeleteState("namespace", "key")).To(Succeed())
+			txContext.TXSimulator.GetState("namespace", "key")
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(2))
+		})
+
+		It("invalidates private data when it is written", func() {
+			txContext.TXSimulator.GetPrivateData("namespace", "collection", "key")
+			Expect(txContext.TXSimulator.SetPrivateData("namespace", "collection", "key", []byte("new-value"))).To(Succeed())
+			txContext.TXSimulator.GetPrivateData("namespace", "collection", "key")
+			Expect(txContext.TXSimulator.DeletePrivateData("namespace", "collection", "key")).To(Succeed())
+			txContext.TXSimulator.GetPrivateData("namespace", "collection", "key")
+			Expect(fakeTxSimulator.GetPrivateDataCallCount()).To(Equal(3))
+		})
+
+		It("invalidates every key written in a batch", func() {
+			txContext.TXSimulator.GetState("namespace", "key1")
+			txContext.TXSimulator.GetState("namespace", "key2")
+			kvs := map[string][]byte{"key1": []byte("value1"), "key2": []byte("value2")}
+			Expect(txContext.TXSimulator.SetStateMultipleKeys("namespace", kvs)).To(Succeed())
+			txContext.TXSimulator.GetState("namespace", "key1")
+			txContext.TXSimulator.GetState("namespace", "key2")
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(4))
+		})
+
+		It("discards the cache after an update query", func() {
+			txContext.TXSimulator.GetState("namespace", "key")
+			Expect(txContext.TXSimulator.ExecuteUpdate("update")).To(Succeed())
+			txContext.TXSimulator.GetState("namespace", "key")
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(2))
+		})
+
+		It("starts with an empty cache after the simulator is replaced", func() {
+			txContext.TXSimulator.GetState("namespace", "key")
+			replacement := &mock.TxSimulator{}
+			Expect(txContexts.Replace("chainID", "transactionID", replacement)).To(Succeed())
+			txContext.TXSimulator.GetState("namespace", "key")
+			Expect(replacement.GetStateCallCount()).To(Equal(1))
+		})
+
+		It("counts cached reads in the read-write set statistics", func() {
+			txContexts.TrackRWSetStats = true
+			ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+			tracked, err := txContexts.Create(ctx, "chainID", "tracked-transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			tracked.TXSimulator.GetState("namespace", "key")
+			tracked.TXSimulator.GetState("namespace", "key")
+			reads, _ := tracked.RWSetStats()
+			Expect(reads).To(Equal(2))
+			Expect(fakeTxSimulator.GetStateCallCount()).To(Equal(1))
+		})
+
+		Context("when caching is disabled", func() {
+			It("leaves the simulator unwrapped", func() {
+				txContexts.CacheReads = false
+				ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+				uncached, err := txContexts.Create(ctx, "chainID", "uncached-transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(uncached.TXSimulator).To(BeIdenticalTo(fakeTxSimulator))
+			})
+		})
+	})
+
+	Describe("SimulatorBackend", func() {
+		It("reports the backend of simulators that know it", func() {
+			for _, backend := range []string{"leveldb", "couchdb"} {
+				sim := &backendSimulator{TxSimulator: &mock.TxSimulator{}, backend: backend}
+				ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, sim)
+				txContext, err := txContexts.Create(ctx, "chainID", "transactionID-"+backend, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContext.SimulatorBackend()).To(Equal(backend))
+			}
+		})
+
+		It("sees through the simulator wrappers of the registry", func() {
+			txContexts.TrackRWSetStats = true
+			txContexts.MemoryBudget = 1024
+			sim := &backendSimulator{TxSimulator: &mock.TxSimulator{}, backend: "couchdb"}
+			ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, sim)
+
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.TXSimulator).NotTo(BeIdenticalTo(sim))
+			Expect(txContext.SimulatorBackend()).To(Equal("couchdb"))
+		})
+
+		It("reports the type of simulators that do not know their backend", func() {
+			ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, &mock.TxSimulator{})
+			txContext, err := txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.SimulatorBackend()).To(Equal("*mock.TxSimulator"))
+		})
+
+		It("reports nothing when the context has no simulator", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.SimulatorBackend()).To(BeEmpty())
+		})
+	})
+
+	Describe("Replace", func() {
+		var (
+			txContext       *chaincode.TransactionContext
+			oldTxSimulator  *mock.TxSimulator
+			resultsIterator *mock.ResultsIterator
+		)
+
+		BeforeEach(func() {
+			oldTxSimulator = &mock.TxSimulator{}
+			ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, oldTxSimulator)
+
+			var err error
+			txContext, err = txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			resultsIterator = &mock.ResultsIterator{}
+			txContext.RegisterIterator("query-id", resultsIterator)
+		})
+
+		It("swaps the tx simulator of the existing context", func() {
+			newTxSimulator := &mock.TxSimulator{}
+			err := txContexts.Replace("chainID", "transactionID", newTxSimulator)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+			Expect(txContext.GetTxSimulator()).To(BeIdenticalTo(newTxSimulator))
+		})
+
+		It("can be used while the simulator is being read", func() {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					Expect(txContext.GetTxSimulator()).NotTo(BeNil())
+				}
+			}()
+			for i := 0; i < 100; i++ {
+				Expect(txContexts.Replace("chainID", "transactionID", &mock.TxSimulator{})).To(Succeed())
+			}
+			wg.Wait()
+		})
+
+		It("leaves the query iterators and results intact", func() {
+			err := txContexts.Replace("chainID", "transactionID", &mock.TxSimulator{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContext.GetIterator("query-id")).To(Equal(resultsIterator))
+			Expect(txContext.GetPendingQueryResult("query-id")).NotTo(BeNil())
+			Expect(resultsIterator.CloseCallCount()).To(Equal(0))
+		})
+
+		It("does not release the old tx simulator", func() {
+			err := txContexts.Replace("chainID", "transactionID", &mock.TxSimulator{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(oldTxSimulator.DoneCallCount()).To(Equal(0))
+		})
+
+		Context("when the context doesn't exist", func() {
+			It("returns an error", func() {
+				err := txContexts.Replace("chainID", "missing-transactionID", &mock.TxSimulator{})
+				Expect(err).To(MatchError("txid: missing-transactionID(chainID) does not exist"))
+			})
+		})
+	})
+
+	Describe("CollectSimulationResults", func() {
+		var (
+			fakeTxSimulators []*mock.TxSimulator
+			results          []*ledger.TxSimulationResults
+		)
+
+		BeforeEach(func() {
+			fakeTxSimulators = nil
+			results = nil
+			for i := 0; i < 3; i++ {
+				result := &ledger.TxSimulationResults{}
+				fakeTxSimulator := &mock.TxSimulator{}
+				fakeTxSimulator.GetTxSimulationResultsReturns(result, nil)
+				fakeTxSimulators = append(fakeTxSimulators, fakeTxSimulator)
+				results = append(results, result)
+
+				ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+				_, err := txContexts.Create(ctx, "chainID", fmt.Sprintf("transactionID%d", i), nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("collects the results of each requested transaction", func() {
+			collected, err := txContexts.CollectSimulationResults("chainID", []string{"transactionID0", "transactionID2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(collected).To(HaveLen(2))
+			Expect(collected["transactionID0"]).To(BeIdenticalTo(results[0]))
+			Expect(collected["transactionID2"]).To(BeIdenticalTo(results[2]))
+			Expect(fakeTxSimulators[1].GetTxSimulationResultsCallCount()).To(Equal(0))
+		})
+
+		It("returns an empty map when no transactions are requested", func() {
+			collected, err := txContexts.CollectSimulationResults("chainID", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(collected).To(BeEmpty())
+		})
+
+		Context("when a transaction context does not exist", func() {
+			It("returns an error without collecting any results", func() {
+				_, err := txContexts.CollectSimulationResults("chainID", []string{"transactionID0", "missing-transactionID"})
+				Expect(err).To(MatchError("txid: missing-transactionID(chainID) does not exist"))
+				Expect(fakeTxSimulators[0].GetTxSimulationResultsCallCount()).To(Equal(0))
+			})
+
+			It("does not look on other chains", func() {
+				_, err := txContexts.CollectSimulationResults("otherChainID", []string{"transactionID0"})
+				Expect(err).To(MatchError("txid: transactionID0(otherChainID) does not exist"))
+			})
+		})
+
+		Context("when a transaction context has no simulator", func() {
+			BeforeEach(func() {
+				_, err := txContexts.Create(context.Background(), "chainID", "no-simulator", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error", func() {
+				_, err := txContexts.CollectSimulationResults("chainID", []string{"no-simulator"})
+				Expect(err).To(MatchError("no tx simulator for txid: no-simulator(chainID)"))
+			})
+		})
+
+		Context("when a simulator fails to produce its results", func() {
+			BeforeEach(func() {
+				fakeTxSimulators[1].GetTxSimulationResultsReturns(nil, errors.New("potato"))
+			})
+
+			It("returns the error", func() {
+				_, err := txContexts.CollectSimulationResults("chainID", []string{"transactionID1"})
+				Expect(err).To(MatchError("failed to get simulation results for txid: transactionID1(chainID): potato"))
+			})
+		})
+	})
+
+	Describe("SetHistoryQueryExecutor", func() {
+		var txContext *chaincode.TransactionContext
+
+		BeforeEach(func() {
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("attaches the history query executor to the existing context", func() {
+			Expect(txContext.SupportsHistory()).To(BeFalse())
+
+			hqe := &mock.HistoryQueryExecutor{}
+			Expect(txContexts.SetHistoryQueryExecutor("chainID", "transactionID", hqe)).To(Succeed())
+
+			Expect(txContext.SupportsHistory()).To(BeTrue())
+			Expect(txContext.GetHistoryQueryExecutor()).To(BeIdenticalTo(hqe))
+		})
+
+		It("can be used while the history query executor is being read", func() {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					txContext.SupportsHistory()
+				}
+			}()
+			for i := 0; i < 100; i++ {
+				Expect(txContexts.SetHistoryQueryExecutor("chainID", "transactionID", &mock.HistoryQueryExecutor{})).To(Succeed())
+			}
+			wg.Wait()
+		})
+
+		Context("when the context doesn't exist", func() {
+			It("returns an error", func() {
+				err := txContexts.SetHistoryQueryExecutor("chainID", "missing-transactionID", &mock.HistoryQueryExecutor{})
+				Expect(err).To(MatchError("txid: missing-transactionID(chainID) does not exist"))
+			})
+		})
+	})
+
+	Describe("Rekey", func() {
+		var (
+			txContext    *chaincode.TransactionContext
+			fakeIterator *mock.ResultsIterator
+		)
+
+		BeforeEach(func() {
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "oldTransactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			fakeIterator = &mock.ResultsIterator{}
+			Expect(txContext.RegisterIterator("query-id", fakeIterator)).To(Succeed())
+		})
+
+		It("moves the context to the new transaction ID with its iterators", func() {
+			err := txContexts.Rekey("chainID", "oldTransactionID", "newTransactionID")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContexts.Get("chainID", "oldTransactionID")).To(BeNil())
+			rekeyed := txContexts.Get("chainID", "newTransactionID")
+			Expect(rekeyed).To(BeIdenticalTo(txContext))
+			Expect(rekeyed.TxID).To(Equal("newTransactionID"))
+			Expect(rekeyed.GetIterator("query-id")).To(Equal(fakeIterator))
+			Expect(rekeyed.GetPendingQueryResult("query-id")).NotTo(BeNil())
+			Expect(fakeIterator.CloseCallCount()).To(Equal(0))
+			Expect(rekeyed.Context().Err()).NotTo(HaveOccurred())
+			Expect(txContexts.Count()).To(Equal(1))
+			Expect(txContexts.GetByHandle(rekeyed.Handle())).To(BeIdenticalTo(rekeyed))
+		})
+
+		It("allows the context to be deleted under the new transaction ID", func() {
+			Expect(txContexts.Rekey("chainID", "oldTransactionID", "newTransactionID")).To(Succeed())
+			Expect(txContexts.Delete("chainID", "oldTransactionID")).To(BeFalse())
+			Expect(txContexts.Delete("chainID", "newTransactionID")).To(BeTrue())
+			Expect(txContexts.Count()).To(Equal(0))
+		})
+
+		Context("when a context exists for the new transaction ID", func() {
+			var existing *chaincode.TransactionContext
+
+			BeforeEach(func() {
+				var err error
+				existing, err = txContexts.Create(context.Background(), "chainID", "newTransactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error and leaves both contexts in place", func() {
+				err := txContexts.Rekey("chainID", "oldTransactionID", "newTransactionID")
+				Expect(err).To(MatchError("txid: newTransactionID(chainID) exists"))
+				Expect(txContexts.Get("chainID", "oldTransactionID")).To(BeIdenticalTo(txContext))
+				Expect(txContexts.Get("chainID", "newTransactionID")).To(BeIdenticalTo(existing))
+				Expect(txContext.TxID).To(Equal("oldTransactionID"))
+			})
+		})
+
+		Context("when the old context does not exist", func() {
+			It("returns an error", func() {
+				err := txContexts.Rekey("chainID", "missingTransactionID", "newTransactionID")
+				Expect(err).To(MatchError("txid: missingTransactionID(chainID) does not exist"))
+				Expect(txContexts.Get("chainID", "newTransactionID")).To(BeNil())
+			})
+		})
+
+		Context("when the context is leased", func() {
+			It("returns an error", func() {
+				_, release, _ := txContexts.Acquire("chainID", "oldTransactionID")
+				defer release()
+
+				err := txContexts.Rekey("chainID", "oldTransactionID", "newTransactionID")
+				Expect(err).To(MatchError("txid: oldTransactionID(chainID) cannot be rekeyed while it is leased"))
+			})
+		})
+
+		Context("when the transaction duration is limited", func() {
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(0, 0)
+				txContexts.MaxTransactionDuration = 100 * time.Millisecond
+				var err error
+				txContext, err = txContexts.Create(context.Background(), "chainID", "oldTransactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("expires the context under its new transaction ID", func() {
+				Expect(txContexts.Rekey("chainID", "oldTransactionID", "newTransactionID")).To(Succeed())
+				Eventually(func() *chaincode.TransactionContext { return txContexts.Get("chainID", "newTransactionID") }).Should(BeNil())
+				Expect(txContexts.Count()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("Transfer", func() {
+		var (
+			txContext       *chaincode.TransactionContext
+			txSimulator     *mock.TxSimulator
+			resultsIterator *mock.ResultsIterator
+			destination     *chaincode.TransactionContexts
+		)
+
+		BeforeEach(func() {
+			txSimulator = &mock.TxSimulator{}
+			ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, txSimulator)
+
+			var err error
+			txContext, err = txContexts.Create(ctx, "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			resultsIterator = &mock.ResultsIterator{}
+			txContext.RegisterIterator("query-id", resultsIterator)
+
+			destination = chaincode.NewTransactionContexts(0, 0)
+		})
+
+		It("moves the context to the destination registry", func() {
+			err := txContexts.Transfer("chainID", "transactionID", destination)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContexts.Get("chainID", "transactionID")).To(BeNil())
+			Expect(txContexts.Count()).To(Equal(0))
+			Expect(destination.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+			Expect(destination.Count()).To(Equal(1))
+		})
+
+		It("preserves the iterators and simulator of the context", func() {
+			err := txContexts.Transfer("chainID", "transactionID", destination)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContext.TXSimulator).To(BeIdenticalTo(txSimulator))
+			Expect(txContext.GetIterator("query-id")).To(Equal(resultsIterator))
+			Expect(resultsIterator.CloseCallCount()).To(Equal(0))
+			Expect(txContext.Context().Err()).NotTo(HaveOccurred())
+		})
+
+		Context("when the destination already has the context", func() {
+			BeforeEach(func() {
+				_, err := destination.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error and leaves the context in the source", func() {
+				err := txContexts.Transfer("chainID", "transactionID", destination)
+				Expect(err).To(MatchError("txid: transactionID(chainID) exists"))
+
+				Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+				Expect(destination.Get("chainID", "transactionID")).NotTo(BeIdenticalTo(txContext))
+				Expect(destination.Count()).To(Equal(1))
+			})
+		})
+
+		Context("when the destination is full", func() {
+			BeforeEach(func() {
+				destination = chaincode.NewTransactionContexts(1, 0)
+				_, err := destination.Create(context.Background(), "chainID", "otherTransactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error and leaves the context in the source", func() {
+				err := txContexts.Transfer("chainID", "transactionID", destination)
+				Expect(errors.Cause(err)).To(Equal(chaincode.ErrTooManyContexts))
+				Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+			})
+		})
+
+		Context("when the context doesn't exist", func() {
+			It("returns an error", func() {
+				err := txContexts.Transfer("chainID", "missing-transactionID", destination)
+				Expect(err).To(MatchError("txid: missing-transactionID(chainID) does not exist"))
+			})
+		})
+
+		Context("when the context is leased", func() {
+			It("returns an error and leaves the context in the source", func() {
+				_, release, ok := txContexts.Acquire("chainID", "transactionID")
+				Expect(ok).To(BeTrue())
+				defer release()
+
+				err := txContexts.Transfer("chainID", "transactionID", destination)
+				Expect(err).To(MatchError("txid: transactionID(chainID) cannot be transferred while it is leased"))
+				Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+				Expect(destination.Count()).To(Equal(0))
+			})
+
+			It("can be transferred once the lease is released", func() {
+				_, release, ok := txContexts.Acquire("chainID", "transactionID")
+				Expect(ok).To(BeTrue())
+				release()
+
+				err := txContexts.Transfer("chainID", "transactionID", destination)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(destination.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+			})
+		})
+
+		Context("when the destination is the source", func() {
+			It("returns an error", func() {
+				err := txContexts.Transfer("chainID", "transactionID", txContexts)
+				Expect(err).To(MatchError("txid: transactionID(chainID) cannot be transferred to its own registry"))
+				Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+			})
+		})
+	})
+
+	Describe("CloseIterator", func() {
+		var (
+			txContext       *chaincode.TransactionContext
+			runawayIterator *mock.ResultsIterator
+			healthyIterator *mock.ResultsIterator
+		)
+
+		BeforeEach(func() {
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			runawayIterator = &mock.ResultsIterator{}
+			healthyIterator = &mock.ResultsIterator{}
+			txContext.RegisterIterator("runaway-query-id", runawayIterator)
+			txContext.RegisterIterator("healthy-query-id", healthyIterator)
+		})
+
+		It("closes and removes only the targeted iterator", func() {
+			err := txContexts.CloseIterator("chainID", "transactionID", "runaway-query-id")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runawayIterator.CloseCallCount()).To(Equal(1))
+			Expect(txContext.GetIterator("runaway-query-id")).To(BeNil())
+			Expect(txContext.GetPendingQueryResult("runaway-query-id")).To(BeNil())
+
+			Expect(healthyIterator.CloseCallCount()).To(Equal(0))
+			Expect(txContext.GetIterator("healthy-query-id")).To(Equal(healthyIterator))
+			Expect(txContext.GetPendingQueryResult("healthy-query-id")).NotTo(BeNil())
+			Expect(txContexts.Get("chainID", "transactionID")).To(Equal(txContext))
+		})
+
+		Context("when the context doesn't exist", func() {
+			It("returns an error", func() {
+				err := txContexts.CloseIterator("chainID", "missing-transactionID", "runaway-query-id")
+				Expect(err).To(MatchError("txid: missing-transactionID(chainID) does not exist"))
+			})
+		})
+
+		Context("when the iterator doesn't exist", func() {
+			It("returns an error", func() {
+				err := txContexts.CloseIterator("chainID", "transactionID", "missing-query-id")
+				Expect(err).To(MatchError("txid: transactionID(chainID): query iterator missing-query-id does not exist"))
+				Expect(healthyIterator.CloseCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("Count", func() {
+		It("tracks the number of active contexts", func() {
+			Expect(txContexts.Count()).To(Equal(0))
+
+			for _, txID := range []string{"transactionID1", "transactionID2", "transactionID3"} {
+				_, err := txContexts.Create(context.Background(), "chainID", txID, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(txContexts.Count()).To(Equal(3))
+
+			txContexts.Delete("chainID", "transactionID1")
+			txContexts.Delete("chainID", "transactionID3")
+			Expect(txContexts.Count()).To(Equal(1))
+
+			txContexts.Delete("chainID", "non-existent")
+			Expect(txContexts.Count()).To(Equal(1))
+		})
+	})
+
+	Describe("CheckInvariants", func() {
+		var txContext *chaincode.TransactionContext
+
+		BeforeEach(func() {
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.RegisterIterator("query-id", &mock.ResultsIterator{})).To(Succeed())
+		})
+
+		It("reports no errors for a consistent registry", func() {
+			Expect(txContexts.CheckInvariants()).To(BeEmpty())
+
+			txContext.CleanupQueryContext("query-id")
+			Expect(txContexts.CheckInvariants()).To(BeEmpty())
+		})
+
+		It("reports iterators without pending query results", func() {
+			chaincode.DeletePendingQueryResult(txContext, "query-id")
+
+			errs := txContexts.CheckInvariants()
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[0]).To(MatchError("txid: transactionID(chainID): query iterator query-id has no pending query result"))
+		})
+
+		It("reports pending query results without iterators", func() {
+			chaincode.AddPendingQueryResult(txContext, "orphan-query-id")
+
+			errs := txContexts.CheckInvariants()
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[0]).To(MatchError("txid: transactionID(chainID): pending query result orphan-query-id has no query iterator"))
+		})
+	})
+
+	Describe("QueryTypeCounts", func() {
+		It("counts the open iterators of each query type across contexts", func() {
+			txContext1, err := txContexts.Create(context.Background(), "chainID", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext2, err := txContexts.Create(context.Background(), "other-chainID", "transactionID2", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(txContext1.RegisterIteratorOfType("range-1", &mock.ResultsIterator{}, chaincode.QueryTypeRange)).To(Succeed())
+			Expect(txContext1.RegisterIteratorOfType("history-1", &mock.ResultsIterator{}, chaincode.QueryTypeHistory)).To(Succeed())
+			Expect(txContext2.RegisterIteratorOfType("history-2", &mock.ResultsIterator{}, chaincode.QueryTypeHistory)).To(Succeed())
+			Expect(txContext2.RegisterIteratorOfType("rich-1", &mock.ResultsIterator{}, chaincode.QueryTypeRich)).To(Succeed())
+			Expect(txContext2.RegisterIterator("untyped", &mock.ResultsIterator{})).To(Succeed())
+
+			Expect(txContexts.QueryTypeCounts()).To(Equal(map[chaincode.QueryType]int{
+				chaincode.QueryTypeRange:   1,
+				chaincode.QueryTypeRich:    1,
+				chaincode.QueryTypeHistory: 2,
+				chaincode.QueryTypeUnknown: 1,
+			}))
+			Expect(txContext1.QueryType("history-1")).To(Equal(chaincode.QueryTypeHistory))
+			Expect(txContext2.QueryType("untyped")).To(Equal(chaincode.QueryTypeUnknown))
+		})
+
+		It("stops counting iterators once they are removed", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.RegisterIteratorOfType("history-1", &mock.ResultsIterator{}, chaincode.QueryTypeHistory)).To(Succeed())
+
+			txContext.CleanupQueryContext("history-1")
+			Expect(txContexts.QueryTypeCounts()).To(BeEmpty())
+			Expect(txContext.QueryType("history-1")).To(Equal(chaincode.QueryTypeUnknown))
+		})
+
+		It("names each query type", func() {
+			Expect(chaincode.QueryTypeRange.String()).To(Equal("range"))
+			Expect(chaincode.QueryTypeRich.String()).To(Equal("rich"))
+			Expect(chaincode.QueryTypeHistory.String()).To(Equal("history"))
+			Expect(chaincode.QueryTypeUnknown.String()).To(Equal("unknown"))
+		})
+	})
+
+	Describe("Stats", func() {
+		It("starts at zero", func() {
+			created, deleted := txContexts.Stats()
+			Expect(created).To(BeZero())
+			Expect(deleted).To(BeZero())
+		})
+
+		It("counts every context created and deleted over the life of the registry", func() {
+			for i := 0; i < 5; i++ {
+				_, err := txContexts.Create(context.Background(), "chainID", fmt.Sprintf("transactionID%d", i), nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			txContexts.Delete("chainID", "transactionID0")
+			txContexts.Delete("chainID", "transactionID1")
+			txContexts.Delete("chainID", "transactionID1")
+			txContexts.DeleteBatch("chainID", []string{"transactionID2", "missing"})
+
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID0", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = txContexts.Create(context.Background(), "chainID", "transactionID0", nil, nil)
+			Expect(err).To(HaveOccurred())
+
+			created, deleted := txContexts.Stats()
+			Expect(created).To(Equal(uint64(6)))
+			Expect(deleted).To(Equal(uint64(3)))
+			Expect(txContexts.Count()).To(Equal(3))
+		})
+
+		It("counts transferred contexts in both registries", func() {
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			destination := chaincode.NewTransactionContexts(0, 0)
+			Expect(txContexts.Transfer("chainID", "transactionID", destination)).To(Succeed())
+
+			created, deleted := txContexts.Stats()
+			Expect(created).To(Equal(uint64(1)))
+			Expect(deleted).To(Equal(uint64(1)))
+			created, deleted = destination.Stats()
+			Expect(created).To(Equal(uint64(1)))
+			Expect(deleted).To(BeZero())
+		})
+	})
+
+	Describe("Snapshot", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Unix(1000, 0)
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+
+			txContext, err := txContexts.Create(context.Background(), "chainID1", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext.RegisterIterator("key1", &mock.ResultsIterator{})
+			txContext.RegisterIterator("key2", &mock.ResultsIterator{})
+
+			now = now.Add(time.Second)
+			_, err = txContexts.Create(context.Background(), "chainID2", "transactionID2", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("describes the active contexts", func() {
+			infos := txContexts.Snapshot()
+			Expect(infos).To(ConsistOf(
+				chaincode.TransactionContextInfo{
+					ChainID:             "chainID1",
+					TxID:                "transactionID1",
+					Created:             time.Unix(1000, 0),
+					QueryIterators:      2,
+					PendingQueryResults: 2,
+				},
+				chaincode.TransactionContextInfo{
+					ChainID: "chainID2",
+					TxID:    "transactionID2",
+					Created: time.Unix(1001, 0),
+				},
+			))
+		})
+
+		It("includes a copy of the labels of each context", func() {
+			txContext := txContexts.Get("chainID2", "transactionID2")
+			txContext.SetLabel("workload", "batch")
+
+			infos := txContexts.Snapshot()
+			Expect(infos).To(HaveLen(2))
+			Expect(infos[0].Labels).To(BeNil())
+			Expect(infos[1].Labels).To(Equal(map[string]string{"workload": "batch"}))
+
+			txContext.SetLabel("workload", "interactive")
+			Expect(infos[1].Labels).To(Equal(map[string]string{"workload": "batch"}))
+		})
+
+		It("is not affected by later changes to the registry", func() {
+			infos := txContexts.Snapshot()
+
+			txContexts.Delete("chainID1", "transactionID1")
+			_, err := txContexts.Create(context.Background(), "chainID3", "transactionID3", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContexts.Get("chainID2", "transactionID2").RegisterIterator("key1", &mock.ResultsIterator{})
+
+			Expect(infos).To(ConsistOf(
+				chaincode.TransactionContextInfo{
+					ChainID:             "chainID1",
+					TxID:                "transactionID1",
+					Created:             time.Unix(1000, 0),
+					QueryIterators:      2,
+					PendingQueryResults: 2,
+				},
+				chaincode.TransactionContextInfo{
+					ChainID: "chainID2",
+					TxID:    "transactionID2",
+					Created: time.Unix(1001, 0),
+				},
+			))
+		})
+	})
+
+	Describe("OldestContexts", func() {
+		BeforeEach(func() {
+			var now time.Time
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+			for _, c := range []struct {
+				txID    string
+				created int64
+			}{
+				{"transactionID1", 1003},
+				{"transactionID2", 1001},
+				{"transactionID3", 1004},
+				{"transactionID4", 1002},
+				{"transactionID5", 1001},
+			} {
+				now = time.Unix(c.created, 0)
+				_, err := txContexts.Create(context.Background(), "chainID", c.txID, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		txIDs := func(infos []chaincode.TransactionContextInfo) []string {
+			var ids []string
+			for _, info := range infos {
+				ids = append(ids, info.TxID)
+			}
+			return ids
+		}
+
+		It("returns the contexts ordered by creation time", func() {
+			infos := txContexts.OldestContexts(10)
+			Expect(txIDs(infos)).To(Equal([]string{"transactionID2", "transactionID5", "transactionID4", "transactionID1", "transactionID3"}))
+			Expect(infos[0].Created).To(Equal(time.Unix(1001, 0)))
+			Expect(infos[4].Created).To(Equal(time.Unix(1004, 0)))
+		})
+
+		It("returns at most n contexts", func() {
+			Expect(txIDs(txContexts.OldestContexts(3))).To(Equal([]string{"transactionID2", "transactionID5", "transactionID4"}))
+			Expect(txIDs(txContexts.OldestContexts(1))).To(Equal([]string{"transactionID2"}))
+		})
+
+		It("returns no contexts when n is less than one", func() {
+			Expect(txContexts.OldestContexts(0)).To(BeEmpty())
+			Expect(txContexts.OldestContexts(-1)).To(BeEmpty())
+		})
+
+		It("does not return deleted contexts", func() {
+			txContexts.Delete("chainID", "transactionID2")
+			Expect(txIDs(txContexts.OldestContexts(2))).To(Equal([]string{"transactionID5", "transactionID4"}))
+		})
+
+		It("orders contexts created at the same time by chain and transaction ID", func() {
+			now := time.Unix(1000, 0)
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+			for _, chainID := range []string{"zzzzzzzzzz", "chainID", "a"} {
+				_, err := txContexts.Create(context.Background(), chainID, "transactionID9", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			var chainIDs []string
+			for _, info := range txContexts.OldestContexts(3) {
+				chainIDs = append(chainIDs, info.ChainID)
+			}
+			Expect(chainIDs).To(Equal([]string{"a", "chainID", "zzzzzzzzzz"}))
+		})
+	})
+
+	Describe("SnapshotJSON", func() {
+		var creator []byte
+
+		BeforeEach(func() {
+			now := time.Unix(1000, 0).UTC()
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+
+			creator = []byte("creator-identity")
+			signedProp := &pb.SignedProposal{
+				ProposalBytes: utils.MarshalOrPanic(&pb.Proposal{
+					Header: utils.MarshalOrPanic(&common.Header{
+						SignatureHeader: utils.MarshalOrPanic(&common.SignatureHeader{
+							Creator: creator,
+						}),
+					}),
+					Payload: []byte("secret-payload"),
+				}),
+			}
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", signedProp, &pb.Proposal{Payload: []byte("secret-payload")})
+			Expect(err).NotTo(HaveOccurred())
+			txContext.RegisterIterator("query-id", &mock.ResultsIterator{})
+			txContext.SetLabel("workload", "batch")
+
+			now = now.Add(90 * time.Second)
+		})
+
+		It("describes the active contexts", func() {
+			b, err := txContexts.SnapshotJSON(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b).To(MatchJSON(`[{
+				"chain_id": "chainID",
+				"tx_id": "transactionID",
+				"created": "1970-01-01T00:16:40Z",
+				"age": "1m30s",
+				"query_iterators": 1,
+				"pending_query_results": 1,
+				"labels": {"workload": "batch"}
+			}]`))
+		})
+
+		It("omits the proposal and the creator identity", func() {
+			b, err := txContexts.SnapshotJSON(true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(b)).NotTo(ContainSubstring("secret-payload"))
+			Expect(string(b)).NotTo(ContainSubstring(base64.StdEncoding.EncodeToString([]byte("secret-payload"))))
+			Expect(string(b)).NotTo(ContainSubstring(string(creator)))
+			Expect(string(b)).NotTo(ContainSubstring(base64.StdEncoding.EncodeToString(creator)))
+		})
+
+		Context("when the creator hash is requested", func() {
+			It("includes the hash of the creator identity", func() {
+				b, err := txContexts.SnapshotJSON(true)
+				Expect(err).NotTo(HaveOccurred())
+
+				hash := sha256.Sum256(creator)
+				Expect(b).To(MatchJSON(`[{
+					"chain_id": "chainID",
+					"tx_id": "transactionID",
+					"created": "1970-01-01T00:16:40Z",
+					"age": "1m30s",
+					"query_iterators": 1,
+					"pending_query_results": 1,
+					"labels": {"workload": "batch"},
+					"creator_hash": "` + hex.EncodeToString(hash[:]) + `"
+				}]`))
+			})
+		})
+
+		Context("when there are no contexts", func() {
+			It("returns an empty array", func() {
+				b, err := chaincode.NewTransactionContexts(0, 0).SnapshotJSON(false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(b).To(MatchJSON(`[]`))
+			})
+		})
+	})
+
+	Describe("CloseChain", func() {
+		var fakeIterators []*mock.ResultsIterator
+
+		BeforeEach(func() {
+			fakeIterators = make([]*mock.ResultsIterator, 3)
+			for i := 0; i < len(fakeIterators); i++ {
+				fakeIterators[i] = &mock.ResultsIterator{}
+			}
+
+			txContext, err := txContexts.Create(context.Background(), "chainID1", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext.RegisterIterator("key1", fakeIterators[0])
+
+			txContext, err = txContexts.Create(context.Background(), "chainID1", "transactionID2", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext.RegisterIterator("key1", fakeIterators[1])
+
+			txContext, err = txContexts.Create(context.Background(), "chainID2", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext.RegisterIterator("key1", fakeIterators[2])
+		})
+
+		It("closes and removes the contexts associated with the chain", func() {
+			txContexts.CloseChain("chainID1")
+
+			Expect(fakeIterators[0].CloseCallCount()).To(Equal(1))
+			Expect(fakeIterators[1].CloseCallCount()).To(Equal(1))
+			Expect(txContexts.Get("chainID1", "transactionID1")).To(BeNil())
+			Expect(txContexts.Get("chainID1", "transactionID2")).To(BeNil())
+		})
+
+		It("leaves contexts associated with other chains alone", func() {
+			txContexts.CloseChain("chainID1")
+
+			Expect(fakeIterators[2].CloseCallCount()).To(Equal(0))
+			Expect(txContexts.Get("chainID2", "transactionID1")).NotTo(BeNil())
+			Expect(txContexts.Count()).To(Equal(1))
+		})
+
+		Context("when the chain has no contexts", func() {
+			It("keeps calm and carries on", func() {
+				txContexts.CloseChain("non-existent")
+				Expect(txContexts.Count()).To(Equal(3))
+			})
+		})
+	})
+
+	Describe("MaxConcurrentReads", func() {
+		var (
+			inFlight    int32
+			maxInFlight int32
+			unblock     func()
+			readers     *sync.WaitGroup
+			blocked     chan struct{}
+		)
+
+		blockingIterator := func() *mock.ResultsIterator {
+			ch := blocked
+			iter := &mock.ResultsIterator{}
+			iter.NextStub = func() (commonledger.QueryResult, error) {
+				n := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+						break
+					}
+				}
+				<-ch
+				return nil, nil
+			}
+			return iter
+		}
+
+		// read advances iter on a goroutine that is joined after the spec.
+		read := func(iter commonledger.ResultsIterator) {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				iter.Next()
+			}()
+		}
+
+		BeforeEach(func() {
+			atomic.StoreInt32(&inFlight, 0)
+			atomic.StoreInt32(&maxInFlight, 0)
+			blocked = make(chan struct{})
+			ch := blocked
+			var once sync.Once
+			unblock = func() { once.Do(func() { close(ch) }) }
+			readers = &sync.WaitGroup{}
+			txContexts.MaxConcurrentReads = 2
+		})
+
+		AfterEach(func() {
+			unblock()
+			readers.Wait()
+		})
+
+		It("bounds the concurrent iterator advances of all contexts", func() {
+			for i := 0; i < 5; i++ {
+				txContext, err := txContexts.Create(context.Background(), "chainID", fmt.Sprintf("transactionID%d", i), nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContext.RegisterIterator("query-id", blockingIterator())).To(Succeed())
+				read(txContext.GetIterator("query-id"))
+			}
+
+			Eventually(func() int32 { return atomic.LoadInt32(&inFlight) }).Should(Equal(int32(2)))
+			Consistently(func() int32 { return atomic.LoadInt32(&inFlight) }, 50*time.Millisecond).Should(Equal(int32(2)))
+
+			unblock()
+			readers.Wait()
+			Expect(atomic.LoadInt32(&maxInFlight)).To(Equal(int32(2)))
+		})
+
+		It("stops waiting for a slot when the transaction is done", func() {
+			for i := 0; i < 2; i++ {
+				txContext, err := txContexts.Create(context.Background(), "chainID", fmt.Sprintf("transactionID%d", i), nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(txContext.RegisterIterator("query-id", blockingIterator())).To(Succeed())
+				read(txContext.GetIterator("query-id"))
+			}
+			Eventually(func() int32 { return atomic.LoadInt32(&inFlight) }).Should(Equal(int32(2)))
+
+			txContext, err := txContexts.Create(context.Background(), "chainID", "waitingTransactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.RegisterIterator("query-id", &mock.ResultsIterator{})).To(Succeed())
+			iter := txContext.GetIterator("query-id")
+			txContexts.Delete("chainID", "waitingTransactionID")
+
+			_, err = iter.Next()
+			Expect(err).To(Equal(context.Canceled))
+		})
+
+		It("preserves the bookmarks of bookmarked iterators", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContext.RegisterIterator("query-id", &bookmarkedIterator{ResultsIterator: &mock.ResultsIterator{}})).To(Succeed())
+
+			bi, ok := txContext.GetIterator("query-id").(chaincode.BookmarkedIterator)
+			Expect(ok).To(BeTrue())
+			Expect(bi.GetBookmark()).To(Equal("bookmark-0"))
+
+			Expect(txContext.RegisterIterator("other-query-id", &mock.ResultsIterator{})).To(Succeed())
+			_, ok = txContext.GetIterator("other-query-id").(chaincode.BookmarkedIterator)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Health", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			txContexts = chaincode.NewTransactionContexts(4, 0)
+			now = time.Unix(1000, 0)
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+		})
+
+		It("reports an empty registry", func() {
+			Expect(txContexts.Health()).To(Equal(chaincode.RegistryHealth{Max: 4}))
+		})
+
+		It("reports the saturation and the age of the oldest context", func() {
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(time.Minute)
+			_, err = txContexts.Create(context.Background(), "chainID", "transactionID2", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = txContexts.Create(context.Background(), "otherChainID", "transactionID3", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(30 * time.Second)
+
+			Expect(txContexts.Health()).To(Equal(chaincode.RegistryHealth{
+				Count:      3,
+				Max:        4,
+				Saturation: 0.75,
+				OldestAge:  90 * time.Second,
+			}))
+		})
+
+		It("reports whether the registry is paused", func() {
+			txContexts.Pause()
+			Expect(txContexts.Health().Paused).To(BeTrue())
+			txContexts.Resume()
+			Expect(txContexts.Health().Paused).To(BeFalse())
+		})
+
+		It("reports whether the registry is closed", func() {
+			Expect(txContexts.Health().Closed).To(BeFalse())
+			txContexts.Close()
+			Expect(txContexts.Health().Closed).To(BeTrue())
+		})
+
+		Context("when the number of contexts is unlimited", func() {
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(0, 0)
+			})
+
+			It("reports no saturation", func() {
+				_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				health := txContexts.Health()
+				Expect(health.Count).To(Equal(1))
+				Expect(health.Max).To(Equal(0))
+				Expect(health.Saturation).To(BeZero())
+			})
+		})
+	})
+
+	Describe("WarnLongLived", func() {
+		var (
+			now     time.Time
+			backend *logging.MemoryBackend
+		)
+
+		BeforeEach(func() {
+			backend = logging.NewMemoryBackend(10)
+			logging.SetBackend(backend)
+
+			now = time.Unix(1000, 0)
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+			txContexts.LongLivedWarnAge = time.Minute
+			txContexts.LongLivedWarnInterval = 30 * time.Second
+
+			_, err := txContexts.Create(context.Background(), "chainID", "old-transaction", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(30 * time.Second)
+			_, err = txContexts.Create(context.Background(), "chainID", "new-transaction", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			flogging.Reset()
+		})
+
+		It("logs contexts that exceed the warn age without removing them", func() {
+			Expect(txContexts.WarnLongLived()).To(Equal(0))
+
+			now = now.Add(30 * time.Second)
+			Expect(txContexts.WarnLongLived()).To(Equal(1))
+			Expect(backend.Head().Record.Level).To(Equal(logging.WARNING))
+			Expect(backend.Head().Record.Message()).To(Equal("[chainID][old-tran] transaction context has been active for 1m0s"))
+			Expect(txContexts.Count()).To(Equal(2))
+		})
+
+		It("repeats the warning every interval", func() {
+			now = now.Add(30 * time.Second)
+			Expect(txContexts.WarnLongLived()).To(Equal(1))
+
+			now = now.Add(10 * time.Second)
+			Expect(txContexts.WarnLongLived()).To(Equal(0))
+
+			now = now.Add(20 * time.Second)
+			Expect(txContexts.WarnLongLived()).To(Equal(2))
+
+			now = now.Add(30 * time.Second)
+			Expect(txContexts.WarnLongLived()).To(Equal(2))
+		})
+
+		It("warns only once for each missed interval", func() {
+			now = now.Add(5 * time.Minute)
+			Expect(txContexts.WarnLongLived()).To(Equal(2))
+			Expect(txContexts.WarnLongLived()).To(Equal(0))
+		})
+
+		Context("when no interval is configured", func() {
+			BeforeEach(func() {
+				txContexts.LongLivedWarnInterval = 0
+			})
+
+			It("warns about each context once", func() {
+				now = now.Add(time.Minute)
+				Expect(txContexts.WarnLongLived()).To(Equal(2))
+
+				now = now.Add(time.Hour)
+				Expect(txContexts.WarnLongLived()).To(Equal(0))
+			})
+		})
+
+		Context("when no warn age is configured", func() {
+			BeforeEach(func() {
+				txContexts.LongLivedWarnAge = 0
+			})
+
+			It("does not warn", func() {
+				now = now.Add(time.Hour)
+				Expect(txContexts.WarnLongLived()).To(Equal(0))
+			})
+
+			It("does not monitor", func() {
+				done := make(chan struct{})
+				go func() {
+					txContexts.MonitorLongLived(context.Background())
+					close(done)
+				}()
+				Eventually(done).Should(BeClosed())
+			})
+		})
+
+		Describe("MonitorLongLived", func() {
+			It("runs until the context is done", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan struct{})
+				go func() {
+					txContexts.MonitorLongLived(ctx)
+					close(done)
+				}()
+				Consistently(done).ShouldNot(BeClosed())
+
+				cancel()
+				Eventually(done).Should(BeClosed())
+			})
+		})
+	})
+
+	Describe("Reap", func() {
+		var (
+			now             time.Time
+			fakeIterator    *mock.ResultsIterator
+			fakeTxSimulator *mock.TxSimulator
+		)
+
+		BeforeEach(func() {
+			now = time.Unix(1000, 0)
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+
+			fakeIterator = &mock.ResultsIterator{}
+			fakeTxSimulator = &mock.TxSimulator{}
+			ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, fakeTxSimulator)
+
+			txContext, err := txContexts.Create(ctx, "chainID", "old-transaction", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext.RegisterIterator("key1", fakeIterator)
+
+			now = now.Add(time.Minute)
+			_, err = txContexts.Create(context.Background(), "chainID", "new-transaction", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("removes contexts older than the threshold", func() {
+			now = now.Add(30 * time.Second)
+
+			reaped := txContexts.Reap(time.Minute)
+			Expect(reaped).To(Equal(1))
+			Expect(txContexts.Get("chainID", "old-transaction")).To(BeNil())
+			Expect(txContexts.Get("chainID", "new-transaction")).NotTo(BeNil())
+		})
+
+		It("closes iterators and releases the simulator of reaped contexts", func() {
+			now = now.Add(30 * time.Second)
+
+			txContexts.Reap(time.Minute)
+			Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+			Expect(fakeTxSimulator.DoneCallCount()).To(Equal(1))
+		})
+
+		Context("when no contexts have expired", func() {
+			It("leaves the registry alone", func() {
+				reaped := txContexts.Reap(time.Hour)
+				Expect(reaped).To(Equal(0))
+				Expect(txContexts.Count()).To(Equal(2))
+				Expect(fakeIterator.CloseCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("Evict", func() {
+		var (
+			now                time.Time
+			fakeIterator       *mock.ResultsIterator
+			fakeSimulator      *mock.TxSimulator
+			createWithPriority func(txID string, priority chaincode.Priority)
+		)
+
+		BeforeEach(func() {
+			now = time.Unix(1000, 0)
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+
+			createWithPriority = func(txID string, priority chaincode.Priority) {
+				now = now.Add(time.Second)
+				ctx := context.WithValue(context.Background(), chaincode.PriorityKey, priority)
+				_, err := txContexts.Create(ctx, "chainID", txID, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			createWithPriority("high1", chaincode.PriorityHigh)
+			createWithPriority("low1", chaincode.PriorityLow)
+			createWithPriority("normal1", chaincode.PriorityNormal)
+			createWithPriority("low2", chaincode.PriorityLow)
+
+			fakeIterator = &mock.ResultsIterator{}
+			fakeSimulator = &mock.TxSimulator{}
+			low1 := txContexts.Get("chainID", "low1")
+			low1.TXSimulator = fakeSimulator
+			low1.RegisterIterator("query-id", fakeIterator)
+		})
+
+		It("evicts low priority contexts before higher priority ones", func() {
+			evicted := txContexts.Evict(2)
+			Expect(evicted).To(Equal(2))
+			Expect(txContexts.Get("chainID", "low1")).To(BeNil())
+			Expect(txContexts.Get("chainID", "low2")).To(BeNil())
+			Expect(txContexts.Get("chainID", "normal1")).NotTo(BeNil())
+			Expect(txContexts.Get("chainID", "high1")).NotTo(BeNil())
+
+			Expect(txContexts.Evict(1)).To(Equal(1))
+			Expect(txContexts.Get("chainID", "normal1")).To(BeNil())
+			Expect(txContexts.Get("chainID", "high1")).NotTo(BeNil())
+		})
+
+		It("evicts older contexts first within a priority", func() {
+			Expect(txContexts.Evict(3)).To(Equal(1))
+			Expect(txContexts.Get("chainID", "low1")).To(BeNil())
+			Expect(txContexts.Get("chainID", "low2")).NotTo(BeNil())
+		})
+
+		It("closes iterators and releases the simulator of evicted contexts", func() {
+			txContexts.Evict(3)
+			Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+			Expect(fakeSimulator.DoneCallCount()).To(Equal(1))
+		})
+
+		Context("when the registry is within the limit", func() {
+			It("leaves the registry alone", func() {
+				Expect(txContexts.Evict(4)).To(Equal(0))
+				Expect(txContexts.Count()).To(Equal(4))
+			})
+		})
+
+		Context("when eviction cost weights are set", func() {
+			var costContexts *chaincode.TransactionContexts
+
+			BeforeEach(func() {
+				costContexts = chaincode.NewTransactionContexts(0, 0)
+				costContexts.TrackRWSetStats = true
+				chaincode.SetTransactionContextsClock(costContexts, func() time.Time { return now })
+				create := func(txID string, priority chaincode.Priority) *chaincode.TransactionContext {
+					now = now.Add(time.Second)
+					ctx := context.WithValue(context.Background(), chaincode.TXSimulatorKey, &mock.TxSimulator{})
+					ctx = context.WithValue(ctx, chaincode.PriorityKey, priority)
+					txContext, err := costContexts.Create(ctx, "chainID", txID, nil, nil)
+					Expect(err).NotTo(HaveOccurred())
+					return txContext
+				}
+
+				// oldest first: 5 keys written, 3 open iterators, 1000 bytes read
+				writer := create("writer", chaincode.PriorityNormal)
+				for i := 0; i < 5; i++ {
+					Expect(writer.TXSimulator.SetState("namespace", fmt.Sprintf("key%d", i), []byte("value"))).To(Succeed())
+				}
+
+				iterators := create("iterators", chaincode.PriorityNormal)
+				for i := 0; i < 3; i++ {
+					Expect(iterators.RegisterIterator(fmt.Sprintf("query-id%d", i), &mock.ResultsIterator{})).To(Succeed())
+				}
+
+				reader := create("reader", chaincode.PriorityNormal)
+				resultsIterator := &mock.ResultsIterator{}
+				resultsIterator.NextReturnsOnCall(0, &queryresult.KV{Key: "key", Value: make([]byte, 992)}, nil)
+				Expect(reader.RegisterIterator("query-id", resultsIterator)).To(Succeed())
+				responseGenerator := &chaincode.QueryResponseGenerator{MaxResultLimit: 10}
+				_, err := responseGenerator.BuildQueryResponse(reader, resultsIterator, "query-id")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reader.BytesRead()).To(Equal(int64(1000)))
+				Expect(reader.GetIterator("query-id")).To(BeNil())
+			})
+
+			It("evicts the highest cost contexts first", func() {
+				costContexts.EvictionCostWeights = &chaincode.CostWeights{BytesRead: 1, OpenIterators: 100, WriteSetSize: 10}
+
+				Expect(costContexts.Evict(2)).To(Equal(1))
+				Expect(costContexts.Get("chainID", "reader")).To(BeNil())
+
+				Expect(costContexts.Evict(1)).To(Equal(1))
+				Expect(costContexts.Get("chainID", "iterators")).To(BeNil())
+				Expect(costContexts.Get("chainID", "writer")).NotTo(BeNil())
+			})
+
+			It("scores the contexts with the configured weights", func() {
+				costContexts.EvictionCostWeights = &chaincode.CostWeights{WriteSetSize: 1}
+
+				Expect(costContexts.Evict(2)).To(Equal(1))
+				Expect(costContexts.Get("chainID", "writer")).To(BeNil())
+
+				// the remaining contexts have no cost and are evicted oldest first
+				Expect(costContexts.Evict(1)).To(Equal(1))
+				Expect(costContexts.Get("chainID", "iterators")).To(BeNil())
+			})
+
+			It("still evicts lower priority contexts first", func() {
+				costContexts.EvictionCostWeights = &chaincode.CostWeights{BytesRead: 1, OpenIterators: 100, WriteSetSize: 10}
+				ctx := context.WithValue(context.Background(), chaincode.PriorityKey, chaincode.PriorityLow)
+				_, err := costContexts.Create(ctx, "chainID", "cheap", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(costContexts.Evict(3)).To(Equal(1))
+				Expect(costContexts.Get("chainID", "cheap")).To(BeNil())
+				Expect(costContexts.Get("chainID", "reader")).NotTo(BeNil())
+			})
+
+			It("evicts the oldest contexts first without weights", func() {
+				Expect(costContexts.Evict(2)).To(Equal(1))
+				Expect(costContexts.Get("chainID", "writer")).To(BeNil())
+			})
+		})
+	})
+
+	Describe("ReapIdleIterators", func() {
+		var (
+			now            time.Time
+			txContext      *chaincode.TransactionContext
+			idleIterator   *mock.ResultsIterator
+			activeIterator *mock.ResultsIterator
+		)
+
+		BeforeEach(func() {
+			now = time.Unix(1000, 0)
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			idleIterator = &mock.ResultsIterator{}
+			err = txContext.RegisterIterator("idle-query", idleIterator)
+			Expect(err).NotTo(HaveOccurred())
+			activeIterator = &mock.ResultsIterator{}
+			activeIterator.NextReturns(&queryresult.KV{Key: "key"}, nil)
+			err = txContext.RegisterIterator("active-query", activeIterator)
+			Expect(err).NotTo(HaveOccurred())
+
+			now = now.Add(time.Minute)
+			generator := &chaincode.QueryResponseGenerator{MaxResultLimit: 1}
+			_, err = generator.BuildQueryResponse(txContext, activeIterator, "active-query")
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(20 * time.Second)
+		})
+
+		It("closes iterators that have not been advanced within the timeout", func() {
+			closed := txContexts.ReapIdleIterators(30 * time.Second)
+			Expect(closed).To(Equal(1))
+
+			Expect(idleIterator.CloseCallCount()).To(Equal(1))
+			Expect(txContext.GetIterator("idle-query")).To(BeNil())
+			Expect(txContext.GetPendingQueryResult("idle-query")).To(BeNil())
+
+			Expect(activeIterator.CloseCallCount()).To(Equal(0))
+			Expect(txContext.GetIterator("active-query")).To(Equal(activeIterator))
+		})
+
+		It("refreshes iterators advanced through their client query ID", func() {
+			clientIterator := &mock.ResultsIterator{}
+			clientIterator.NextReturns(&queryresult.KV{Key: "key"}, nil)
+			_, err := txContext.RegisterClientIterator("client-query", clientIterator)
+			Expect(err).NotTo(HaveOccurred())
+
+			now = now.Add(20 * time.Second)
+			generator := &chaincode.QueryResponseGenerator{MaxResultLimit: 1}
+			_, err = generator.BuildQueryResponse(txContext, clientIterator, "client-query")
+			Expect(err).NotTo(HaveOccurred())
+			now = now.Add(20 * time.Second)
+
+			txContexts.ReapIdleIterators(30 * time.Second)
+			Expect(clientIterator.CloseCallCount()).To(Equal(0))
+			Expect(txContext.GetIterator("client-query")).To(Equal(clientIterator))
+
+			now = now.Add(time.Minute)
+			txContexts.ReapIdleIterators(30 * time.Second)
+			Expect(clientIterator.CloseCallCount()).To(Equal(1))
+			Expect(txContext.GetIterator("client-query")).To(BeNil())
+		})
+
+		It("leaves the transaction context registered", func() {
+			txContexts.ReapIdleIterators(30 * time.Second)
+			Expect(txContexts.Get("chainID", "transactionID")).To(BeIdenticalTo(txContext))
+		})
+
+		Context("when no iterators are idle", func() {
+			It("leaves the iterators alone", func() {
+				closed := txContexts.ReapIdleIterators(time.Hour)
+				Expect(closed).To(Equal(0))
+				Expect(txContext.OpenIteratorIDs()).To(Equal([]string{"active-query", "idle-query"}))
+			})
+		})
+	})
+
+	Describe("CloseGracefully", func() {
+		var (
+			txContext    *chaincode.TransactionContext
+			fakeIterator *mock.ResultsIterator
+		)
+
+		BeforeEach(func() {
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeIterator = &mock.ResultsIterator{}
+			txContext.RegisterIterator("key1", fakeIterator)
+		})
+
+		It("closes iterators and clears the registry", func() {
+			err := txContexts.CloseGracefully(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+			Expect(txContexts.Count()).To(Equal(0))
+		})
+
+		It("rejects new contexts", func() {
+			txContexts.CloseGracefully(context.Background())
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID2", nil, nil)
+			Expect(err).To(MatchError("txid: transactionID2(chainID): transaction context registry is closed"))
+			Expect(errors.Cause(err)).To(Equal(chaincode.ErrRegistryClosed))
+		})
+
+		Context("when a response has not been received", func() {
+			BeforeEach(func() {
+				txContext.ResponseNotifier <- &pb.ChaincodeMessage{Txid: "transactionID"}
+			})
+
+			It("waits for the response to be received before closing iterators", func() {
+				errCh := make(chan error, 1)
+				go func() { errCh <- txContexts.CloseGracefully(context.Background()) }()
+
+				Consistently(errCh).ShouldNot(Receive())
+				Expect(fakeIterator.CloseCallCount()).To(Equal(0))
+
+				Expect(txContext.ResponseNotifier).To(Receive())
+				Eventually(errCh).Should(Receive(BeNil()))
+				Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+			})
+
+			It("gives up when the context is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				errCh := make(chan error, 1)
+				go func() { errCh <- txContexts.CloseGracefully(ctx) }()
+
+				Consistently(errCh).ShouldNot(Receive())
+				cancel()
+				Eventually(errCh).Should(Receive(Equal(context.Canceled)))
+				Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+				Expect(txContexts.Count()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("Metrics", func() {
+		var (
+			fakeMetrics *mock.TransactionContextMetrics
+			now         time.Time
+		)
+
+		BeforeEach(func() {
+			fakeMetrics = &mock.TransactionContextMetrics{}
+			txContexts.Metrics = fakeMetrics
+
+			now = time.Unix(1000, 0)
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+		})
+
+		It("reports created contexts", func() {
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeMetrics.ContextCreatedCallCount()).To(Equal(1))
+			Expect(fakeMetrics.ContextCreatedArgsForCall(0)).To(Equal("chainID"))
+		})
+
+		It("reports deleted contexts with their lifetime", func() {
+			_, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			now = now.Add(3 * time.Second)
+			txContexts.Delete("chainID", "transactionID")
+
+			Expect(fakeMetrics.ContextDeletedCallCount()).To(Equal(1))
+			chainID, duration := fakeMetrics.ContextDeletedArgsForCall(0)
+			Expect(chainID).To(Equal("chainID"))
+			Expect(duration).To(Equal(3 * time.Second))
+		})
+
+		It("does not report contexts that were not created", func() {
+			txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			txContexts.Delete("chainID", "missing")
+
+			Expect(fakeMetrics.ContextCreatedCallCount()).To(Equal(1))
+			Expect(fakeMetrics.ContextDeletedCallCount()).To(Equal(0))
+		})
+
+		It("reports the size of each query result batch", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			resultsIterator := &mock.ResultsIterator{}
+			resultsIterator.NextReturns(&queryresult.KV{Key: "key"}, nil)
+			resultsIterator.NextReturnsOnCall(7, nil, nil)
+			err = txContext.RegisterIterator("query-id", resultsIterator)
+			Expect(err).NotTo(HaveOccurred())
+
+			responseGenerator := &chaincode.QueryResponseGenerator{MaxResultLimit: 3}
+			for {
+				resp, err := responseGenerator.BuildQueryResponse(txContext, resultsIterator, "query-id")
+				Expect(err).NotTo(HaveOccurred())
+				if !resp.HasMore {
+					break
+				}
+			}
+
+			Expect(fakeMetrics.QueryBatchCallCount()).To(Equal(3))
+			for i, expectedSize := range []int{3, 3, 1} {
+				chainID, size := fakeMetrics.QueryBatchArgsForCall(i)
+				Expect(chainID).To(Equal("chainID"))
+				Expect(size).To(Equal(expectedSize))
+			}
+		})
+
+		It("reports how long each query iterator was open when it is closed", func() {
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			txContext.RegisterIterator("query-id-1", &mock.ResultsIterator{})
+			now = now.Add(2 * time.Second)
+			txContext.RegisterIterator("query-id-2", &mock.ResultsIterator{})
+			now = now.Add(3 * time.Second)
+			txContext.CleanupQueryContext("query-id-1")
+
+			Expect(fakeMetrics.IteratorClosedCallCount()).To(Equal(1))
+			chainID, duration := fakeMetrics.IteratorClosedArgsForCall(0)
+			Expect(chainID).To(Equal("chainID"))
+			Expect(duration).To(Equal(5 * time.Second))
+
+			txContext.CloseQueryIterators()
+			txContext.CloseQueryIterators()
+			Expect(fakeMetrics.IteratorClosedCallCount()).To(Equal(2))
+			_, duration = fakeMetrics.IteratorClosedArgsForCall(1)
+			Expect(duration).To(Equal(3 * time.Second))
+		})
+	})
+
+	Describe("enumeration order", func() {
+		BeforeEach(func() {
+			for _, txID := range []string{"transactionID3", "transactionID1", "transactionID4", "transactionID2"} {
+				_, err := txContexts.Create(context.Background(), "chainID", txID, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("enumerates contexts sorted by chain and transaction ID", func() {
+			var txIDs []string
+			for _, info := range txContexts.Snapshot() {
+				txIDs = append(txIDs, info.TxID)
+			}
+			Expect(txIDs).To(Equal([]string{"transactionID1", "transactionID2", "transactionID3", "transactionID4"}))
+
+			txIDs = nil
+			for _, txctx := range txContexts.GetByChain("chainID") {
+				txIDs = append(txIDs, txctx.TxID)
+			}
+			Expect(txIDs).To(Equal([]string{"transactionID1", "transactionID2", "transactionID3", "transactionID4"}))
+		})
+
+		It("orders chain IDs of different lengths lexically", func() {
+			_, err := txContexts.Create(context.Background(), "zzzzzzzzzz", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = txContexts.Create(context.Background(), "a", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			var chainIDs []string
+			for _, info := range txContexts.Snapshot() {
+				chainIDs = append(chainIDs, info.ChainID)
+			}
+			Expect(chainIDs).To(Equal([]string{"a", "chainID", "chainID", "chainID", "chainID", "zzzzzzzzzz"}))
+		})
+
+		It("closes contexts sorted by chain and transaction ID", func() {
+			var closed []string
+			for _, txID := range []string{"transactionID1", "transactionID2", "transactionID3", "transactionID4"} {
+				txID := txID
+				iter := &mock.ResultsIterator{}
+				iter.CloseStub = func() { closed = append(closed, txID) }
+				txContexts.Get("chainID", txID).RegisterIterator("query-id", iter)
+			}
+
+			txContexts.Close()
+			Expect(closed).To(Equal([]string{"transactionID1", "transactionID2", "transactionID3", "transactionID4"}))
+		})
+	})
+
+	Describe("closing iterators without holding the registry locks", func() {
+		var (
+			now       time.Time
+			getResult chan *chaincode.TransactionContext
+		)
+
+		BeforeEach(func() {
+			now = time.Unix(1000, 0)
+			chaincode.SetTransactionContextsClock(txContexts, func() time.Time { return now })
+
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			getResult = make(chan *chaincode.TransactionContext, 1)
+			iter := &mock.ResultsIterator{}
+			iter.CloseStub = func() { getResult <- txContexts.Get("chainID", "transactionID") }
+			txContext.RegisterIterator("query-id", iter)
+			now = now.Add(time.Minute)
+		})
+
+		DescribeTable("lets the registry be used from an iterator's Close",
+			func(closeIterators func()) {
+				done := make(chan struct{})
+				go func() {
+					closeIterators()
+					close(done)
+				}()
+				Eventually(done).Should(BeClosed())
+				Expect(getResult).To(Receive(Not(BeNil())))
+			},
+			Entry("Close", func() { txContexts.Close() }),
+			Entry("CloseChecked", func() { txContexts.CloseChecked(true) }),
+			Entry("CloseWithTimeout", func() { txContexts.CloseWithTimeout(time.Second) }),
+			Entry("ReapIdleIterators", func() { txContexts.ReapIdleIterators(time.Second) }),
+		)
+	})
+
+	Describe("Purge", func() {
+		var fakeIterators []*mock.ResultsIterator
+
+		BeforeEach(func() {
+			fakeIterators = nil
+			for chainID, n := range map[string]int{"chainID1": 3, "chainID2": 1, "chainID3": 2} {
+				for i := 0; i < n; i++ {
+					txContext, err := txContexts.Create(context.Background(), chainID, fmt.Sprintf("transactionID%d", i), nil, nil)
+					Expect(err).NotTo(HaveOccurred())
+					iter := &mock.ResultsIterator{}
+					fakeIterators = append(fakeIterators, iter)
+					txContext.RegisterIterator("query-id", iter)
+				}
+			}
+		})
+
+		It("returns the number of contexts removed for each chain", func() {
+			purged := txContexts.Purge()
+			Expect(purged).To(Equal(map[string]int{"chainID1": 3, "chainID2": 1, "chainID3": 2}))
+		})
+
+		It("closes all iterators and empties the registry", func() {
+			txContexts.Purge()
+			for _, iter := range fakeIterators {
+				Expect(iter.CloseCallCount()).To(Equal(1))
+			}
+			Expect(txContexts.Count()).To(Equal(0))
+			Expect(txContexts.Snapshot()).To(BeEmpty())
+			Expect(txContexts.Get("chainID1", "transactionID0")).To(BeNil())
+		})
+
+		Context("when the registry is empty", func() {
+			It("returns an empty result", func() {
+				Expect(chaincode.NewTransactionContexts(0, 0).Purge()).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("Pause", func() {
+		var (
+			txContext *chaincode.TransactionContext
+			iterator  *mock.ResultsIterator
+		)
+
+		BeforeEach(func() {
+			var err error
+			txContext, err = txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			iterator = &mock.ResultsIterator{}
+			Expect(txContext.RegisterIterator("query-id", iterator)).To(Succeed())
+
+			txContexts.Pause()
+		})
+
+		It("rejects new contexts while paused", func() {
+			_, err := txContexts.Create(context.Background(), "chainID", "new-transactionID", nil, nil)
+			Expect(err).To(MatchError("txid: new-transactionID(chainID): transaction context registry is paused"))
+			Expect(errors.Cause(err)).To(Equal(chaincode.ErrRegistryPaused))
+			Expect(txContexts.Get("chainID", "new-transactionID")).To(BeNil())
+
+			_, _, err = txContexts.GetOrCreate(context.Background(), "chainID", "new-transactionID", nil, nil)
+			Expect(errors.Cause(err)).To(Equal(chaincode.ErrRegistryPaused))
+			err = txContexts.Validate(context.Background(), "chainID", "new-transactionID")
+			Expect(errors.Cause(err)).To(Equal(chaincode.ErrRegistryPaused))
+		})
+
+		It("still returns existing contexts from GetOrCreate", func() {
+			existing, created, err := txContexts.GetOrCreate(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeFalse())
+			Expect(existing).To(Equal(txContext))
+		})
+
+		It("leaves existing contexts and their iterators usable", func() {
+			Expect(txContexts.Get("chainID", "transactionID")).To(Equal(txContext))
+			Expect(txContext.GetIterator("query-id")).To(Equal(iterator))
+			Expect(txContexts.CloseIterator("chainID", "transactionID", "query-id")).To(Succeed())
+			Expect(iterator.CloseCallCount()).To(Equal(1))
+
+			Expect(txContexts.Delete("chainID", "transactionID")).To(BeTrue())
+			Expect(txContexts.Count()).To(Equal(0))
+		})
+
+		It("accepts new contexts after Resume", func() {
+			txContexts.Resume()
+
+			_, err := txContexts.Create(context.Background(), "chainID", "new-transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(txContexts.Count()).To(Equal(2))
+		})
+	})
+
+	Describe("CloseWithTimeout", func() {
+		var (
+			fakeIterator    *mock.ResultsIterator
+			blockedIterator *mock.ResultsIterator
+			unblock         chan struct{}
+			closeReturned   chan struct{}
+		)
+
+		BeforeEach(func() {
+			unblock = make(chan struct{})
+			closeReturned = make(chan struct{})
+			fakeIterator = &mock.ResultsIterator{}
+			blockedIterator = &mock.ResultsIterator{}
+			ch, returned := unblock, closeReturned
+			blockedIterator.CloseStub = func() {
+				defer close(returned)
+				<-ch
+			}
+
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext.RegisterIterator("key1", fakeIterator)
+			txContext.RegisterIterator("key2", blockedIterator)
+		})
+
+		AfterEach(func() {
+			close(unblock)
+			if blockedIterator.CloseStub != nil {
+				Eventually(closeReturned).Should(BeClosed())
+			}
+		})
+
+		It("returns a timeout error listing the iterators that did not close", func() {
+			errCh := make(chan error, 1)
+			go func() { errCh <- txContexts.CloseWithTimeout(50 * time.Millisecond) }()
+
+			var err error
+			Eventually(errCh).Should(Receive(&err))
+			Expect(err).To(MatchError("timed out after 50ms closing query iterators: key2 of txid: transactionID(chainID)"))
+			Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+			Expect(blockedIterator.CloseCallCount()).To(Equal(1))
+		})
+
+		It("rejects contexts created after the registry is closed", func() {
+			txContexts.CloseWithTimeout(time.Millisecond)
+
+			_, err := txContexts.Create(context.Background(), "chainID", "late-transactionID", nil, nil)
+			Expect(errors.Cause(err)).To(Equal(chaincode.ErrRegistryClosed))
+		})
+
+		Context("when all iterators close in time", func() {
+			BeforeEach(func() {
+				blockedIterator.CloseStub = nil
+			})
+
+			It("closes all iterators and returns nil", func() {
+				err := txContexts.CloseWithTimeout(time.Second)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeIterator.CloseCallCount()).To(Equal(1))
+				Expect(blockedIterator.CloseCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("CloseChecked", func() {
+		var fakeIterators []*mock.ResultsIterator
+
+		BeforeEach(func() {
+			fakeIterators = make([]*mock.ResultsIterator, 5)
+			for i := 0; i < len(fakeIterators); i++ {
+				fakeIterators[i] = &mock.ResultsIterator{}
+			}
+			fakeIterators[1].CloseStub = func() { panic("close-failed") }
+			fakeIterators[3].CloseStub = func() { panic("close-also-failed") }
+
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID1", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext.RegisterIterator("key1", fakeIterators[0])
+			txContext.RegisterIterator("key2", fakeIterators[1])
+			txContext.RegisterIterator("key3", fakeIterators[2])
+
+			txContext2, err := txContexts.Create(context.Background(), "chainID", "transactionID2", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext2.RegisterIterator("key1", fakeIterators[3])
+			txContext2.RegisterIterator("key2", fakeIterators[4])
+		})
+
+		Context("when continuing after failures", func() {
+			It("closes every iterator and reports all failures", func() {
+				err := txContexts.CloseChecked(false)
+				Expect(err).To(MatchError("failed to close 2 query iterators: " +
+					"txid: transactionID1(chainID): failed to close query iterator key2: recovered from panic: close-failed; " +
+					"txid: transactionID2(chainID): failed to close query iterator key1: recovered from panic: close-also-failed"))
+				for _, ri := range fakeIterators {
+					Expect(ri.CloseCallCount()).To(Equal(1))
+				}
+			})
+
+			It("returns a single failure as is", func() {
+				fakeIterators[3].CloseStub = nil
+				err := txContexts.CloseChecked(false)
+				Expect(err).To(MatchError("txid: transactionID1(chainID): failed to close query iterator key2: recovered from panic: close-failed"))
+			})
+		})
+
+		Context("when failing fast", func() {
+			It("stops at the first failure and leaves the remaining iterators open", func() {
+				err := txContexts.CloseChecked(true)
+				Expect(err).To(MatchError("txid: transactionID1(chainID): failed to close query iterator key2: recovered from panic: close-failed"))
+
+				Expect(fakeIterators[0].CloseCallCount()).To(Equal(1))
+				Expect(fakeIterators[1].CloseCallCount()).To(Equal(1))
+				for _, ri := range fakeIterators[2:] {
+					Expect(ri.CloseCallCount()).To(Equal(0))
+				}
+				Expect(txContexts.Get("chainID", "transactionID1").GetIterator("key3")).To(Equal(fakeIterators[2]))
+				Expect(txContexts.Get("chainID", "transactionID2").GetIterator("key2")).To(Equal(fakeIterators[4]))
+			})
+
+			It("visits chain IDs of different lengths in lexical order", func() {
+				first := &mock.ResultsIterator{}
+				first.CloseStub = func() { panic("first-failed") }
+				txContext, err := txContexts.Create(context.Background(), "a", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				txContext.RegisterIterator("key1", first)
+
+				last := &mock.ResultsIterator{}
+				txContext, err = txContexts.Create(context.Background(), "zzzzzzzzzz", "transactionID1", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				txContext.RegisterIterator("key1", last)
+
+				err = txContexts.CloseChecked(true)
+				Expect(err).To(MatchError("txid: transactionID1(a): failed to close query iterator key1: recovered from panic: first-failed"))
+				Expect(fakeIterators[0].CloseCallCount()).To(Equal(0))
+				Expect(last.CloseCallCount()).To(Equal(0))
+			})
+		})
+
+		It("returns nil when every iterator closes", func() {
+			fakeIterators[1].CloseStub = nil
+			fakeIterators[3].CloseStub = nil
+			Expect(txContexts.CloseChecked(true)).To(Succeed())
+			for _, ri := range fakeIterators {
+				Expect(ri.CloseCallCount()).To(Equal(1))
+			}
+		})
+
+		It("rejects contexts created after the registry is closed", func() {
+			txContexts.CloseChecked(true)
+			_, err := txContexts.Create(context.Background(), "chainID", "late-transactionID", nil, nil)
+			Expect(errors.Cause(err)).To(Equal(chaincode.ErrRegistryClosed))
+		})
+	})
+
+	Describe("Close", func() {
+		var fakeIterators []*mock.ResultsIterator
+
+		BeforeEach(func() {
+			fakeIterators = make([]*mock.ResultsIterator, 6)
+			for i := 0; i < len(fakeIterators); i++ {
+				fakeIterators[i] = &mock.ResultsIterator{}
+			}
+
+			txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext.RegisterIterator("key1", fakeIterators[0])
+			txContext.RegisterIterator("key2", fakeIterators[1])
+			txContext.RegisterIterator("key3", fakeIterators[2])
+
+			txContext2, err := txContexts.Create(context.Background(), "chainID", "transactionID2", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			txContext2.RegisterIterator("key1", fakeIterators[3])
+			txContext2.RegisterIterator("key2", fakeIterators[4])
+			txContext2.RegisterIterator("key3", fakeIterators[5])
+		})
+
+		It("closes all iterators in iterator map", func() {
+			for _, ri := range fakeIterators {
+				Expect(ri.CloseCallCount()).To(Equal(0))
+			}
+			Expect(txContexts.Close()).To(Succeed())
+			for _, ri := range fakeIterators {
+				Expect(ri.CloseCallCount()).To(Equal(1))
+			}
+		})
+
+		Context("when iterators fail to close", func() {
+			BeforeEach(func() {
+				fakeIterators[0].CloseStub = func() { panic("couchdb-unreachable") }
+				fakeIterators[4].CloseStub = func() { panic("connection-reset") }
+			})
+
+			It("closes the remaining iterators and reports every failure", func() {
+				err := txContexts.Close()
+				Expect(err).To(MatchError("failed to close 2 query iterators: " +
+					"txid: transactionID(chainID): failed to close query iterator key1: recovered from panic: couchdb-unreachable; " +
+					"txid: transactionID2(chainID): failed to close query iterator key2: recovered from panic: connection-reset"))
+				for _, ri := range fakeIterators {
+					Expect(ri.CloseCallCount()).To(Equal(1))
+				}
+			})
+
+			It("returns a single failure as is", func() {
+				fakeIterators[4].CloseStub = nil
+				err := txContexts.Close()
+				Expect(err).To(MatchError("txid: transactionID(chainID): failed to close query iterator key1: recovered from panic: couchdb-unreachable"))
+			})
+		})
+
+		It("rejects contexts created after the registry is closed", func() {
+			txContexts.Close()
+
+			_, err := txContexts.Create(context.Background(), "chainID", "late-transactionID", nil, nil)
+			Expect(err).To(MatchError("txid: late-transactionID(chainID): transaction context registry is closed"))
+			Expect(errors.Cause(err)).To(Equal(chaincode.ErrRegistryClosed))
+			Expect(txContexts.Get("chainID", "late-transactionID")).To(BeNil())
+
+			err = txContexts.Validate(context.Background(), "chainID", "late-transactionID")
+			Expect(errors.Cause(err)).To(Equal(chaincode.ErrRegistryClosed))
+		})
+
+		It("still returns existing contexts", func() {
+			txContexts.Close()
+			Expect(txContexts.Get("chainID", "transactionID")).NotTo(BeNil())
+			Expect(txContexts.Get("chainID", "transactionID2")).NotTo(BeNil())
+		})
+
+		Context("when pending results are flushed on close", func() {
+			var txContext *chaincode.TransactionContext
+
+			BeforeEach(func() {
+				txContexts.FlushPendingOnClose = true
+				txContext = txContexts.Get("chainID", "transactionID")
+				Expect(txContext.GetPendingQueryResult("key1").Add(&queryresult.KV{Key: "pending-key"})).To(Succeed())
+			})
+
+			It("delivers the pending results before closing the iterators", func() {
+				txContexts.Close()
+
+				var msg *pb.ChaincodeMessage
+				Eventually(txContext.ResponseNotifier).Should(Receive(&msg))
+				Expect(msg.Type).To(Equal(pb.ChaincodeMessage_RESPONSE))
+				Expect(msg.Txid).To(Equal("transactionID"))
+				Expect(msg.ChannelId).To(Equal("chainID"))
+
+				response := &pb.QueryResponse{}
+				Expect(proto.Unmarshal(msg.Payload, response)).To(Succeed())
+				Expect(response.Id).To(Equal("key1"))
+				Expect(response.HasMore).To(BeFalse())
+				Expect(response.Results).To(HaveLen(1))
+				Expect(txContext.GetPendingQueryResult("key1").Size()).To(Equal(0))
+
+				for _, ri := range fakeIterators {
+					Expect(ri.CloseCallCount()).To(Equal(1))
+				}
+			})
+
+			It("closes the iterators when the results cannot be delivered", func() {
+				Expect(txContext.Notify(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_COMPLETED})).To(BeTrue())
+
+				txContexts.Close()
+
+				var msg *pb.ChaincodeMessage
+				Expect(txContext.ResponseNotifier).To(Receive(&msg))
+				Expect(msg.Type).To(Equal(pb.ChaincodeMessage_COMPLETED))
+				Expect(txContext.ResponseNotifier).NotTo(Receive())
+
+				for _, ri := range fakeIterators {
+					Expect(ri.CloseCallCount()).To(Equal(1))
+				}
+			})
+		})
+
+		Context("when pending results are not flushed on close", func() {
+			It("does not deliver them", func() {
+				txContext := txContexts.Get("chainID", "transactionID")
+				Expect(txContext.GetPendingQueryResult("key1").Add(&queryresult.KV{Key: "pending-key"})).To(Succeed())
+
+				txContexts.Close()
+				Expect(txContext.ResponseNotifier).NotTo(Receive())
+			})
+		})
+
+		Context("when there are no contexts", func() {
+			BeforeEach(func() {
+				txContexts = chaincode.NewTransactionContexts(0, 0)
+			})
+
+			It("keeps calm and carries on", func() {
+				txContexts.Close()
+			})
+		})
+
+		Context("when there is a context with no query iterators", func() {
+			BeforeEach(func() {
+				_, err := txContexts.Create(context.Background(), "chainID", "no-query-iterators", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("closes iterators associated with other contexts", func() {
+				txContexts.Close()
 				for _, ri := range fakeIterators {
 					Expect(ri.CloseCallCount()).To(Equal(1))
 				}
@@ -181,3 +3980,162 @@ var _ = Describe("TransactionContexts", func() {
 		})
 	})
 })
+
+type countingIterator struct {
+	commonledger.ResultsIterator
+	nextCalls  *int32
+	closeCalls *int32
+}
+
+func (c *countingIterator) Next() (commonledger.QueryResult, error) {
+	atomic.AddInt32(c.nextCalls, 1)
+	return c.ResultsIterator.Next()
+}
+
+func (c *countingIterator) Close() {
+	atomic.AddInt32(c.closeCalls, 1)
+	c.ResultsIterator.Close()
+}
+
+func benchmarkTransactionContexts(b *testing.B, txContexts *chaincode.TransactionContexts) {
+	var seq int64
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			txID := fmt.Sprintf("transactionID%d", atomic.AddInt64(&seq, 1))
+			if _, err := txContexts.Create(context.Background(), "chainID", txID, nil, nil); err != nil {
+				b.Fatal(err)
+			}
+			txContexts.Get("chainID", txID)
+			txContexts.Delete("chainID", txID)
+		}
+	})
+}
+
+func BenchmarkTransactionContextsSingleMutex(b *testing.B) {
+	benchmarkTransactionContexts(b, chaincode.NewTransactionContextsWithShards(0, 0, 1))
+}
+
+func BenchmarkTransactionContextsSharded(b *testing.B) {
+	benchmarkTransactionContexts(b, chaincode.NewTransactionContexts(0, 0))
+}
+
+const deleteBenchmarkBatchSize = 100
+
+func benchmarkDelete(b *testing.B, deleteAll func(txContexts *chaincode.TransactionContexts, txIDs []string)) {
+	txIDs := make([]string, deleteBenchmarkBatchSize)
+	for i := range txIDs {
+		txIDs[i] = fmt.Sprintf("transactionID%d", i)
+	}
+
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		txContexts := chaincode.NewTransactionContexts(0, 0)
+		for _, txID := range txIDs {
+			if _, err := txContexts.Create(context.Background(), "chainID", txID, nil, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+
+		deleteAll(txContexts, txIDs)
+	}
+}
+
+func BenchmarkTransactionContextsDelete(b *testing.B) {
+	benchmarkDelete(b, func(txContexts *chaincode.TransactionContexts, txIDs []string) {
+		for _, txID := range txIDs {
+			txContexts.DeleteAndClose("chainID", txID)
+		}
+	})
+}
+
+func BenchmarkTransactionContextsDeleteBatch(b *testing.B) {
+	benchmarkDelete(b, func(txContexts *chaincode.TransactionContexts, txIDs []string) {
+		txContexts.DeleteBatch("chainID", txIDs)
+	})
+}
+
+func BenchmarkTransactionContextsGetDuringCreate(b *testing.B) {
+	txContexts := chaincode.NewTransactionContextsWithShards(0, 0, 1)
+	if _, err := txContexts.Create(context.Background(), "chainID", "existing", nil, nil); err != nil {
+		b.Fatal(err)
+	}
+	signedProp := &pb.SignedProposal{
+		ProposalBytes: utils.MarshalOrPanic(&pb.Proposal{
+			Header: utils.MarshalOrPanic(&common.Header{
+				SignatureHeader: utils.MarshalOrPanic(&common.SignatureHeader{
+					Creator: make([]byte, 1024),
+				}),
+			}),
+		}),
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				txID := fmt.Sprintf("transactionID%d-%d", i, n)
+				if _, err := txContexts.Create(context.Background(), "chainID", txID, signedProp, nil); err != nil {
+					b.Error(err)
+					return
+				}
+				txContexts.Delete("chainID", txID)
+			}
+		}(i)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		txContexts.Get("chainID", "existing")
+	}
+	b.StopTimer()
+
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkTransactionContextsCreateDelete(b *testing.B) {
+	txContexts := chaincode.NewTransactionContexts(0, 0)
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if _, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil); err != nil {
+			b.Fatal(err)
+		}
+		txContexts.Delete("chainID", "transactionID")
+	}
+}
+
+func BenchmarkTransactionContextsConcurrentReads(b *testing.B) {
+	txContexts := chaincode.NewTransactionContexts(0, 0)
+	txContexts.MaxConcurrentReads = 4
+	txContext, err := txContexts.Create(context.Background(), "chainID", "transactionID", nil, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := txContext.RegisterIterator("query-id", &mock.ResultsIterator{}); err != nil {
+		b.Fatal(err)
+	}
+	iter := txContext.GetIterator("query-id")
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			iter.Next()
+		}
+	})
+}
+
+type backendSimulator struct {
+	*mock.TxSimulator
+	backend string
+}
+
+func (b *backendSimulator) Backend() string { return b.backend }
@@ -0,0 +1,73 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/common/flogging"
+	"github.com/op/go-logging"
+)
+
+// transactionLogger is the logger wrapped by TransactionLogger. The extra
+// call depth attributes records to the caller of the TransactionLogger.
+var transactionLogger = func() *logging.Logger {
+	l := flogging.MustGetLogger("chaincode")
+	l.ExtraCalldepth = 1
+	return l
+}()
+
+// TransactionLogger logs messages tagged with the chain and transaction ID of
+// a transaction.
+type TransactionLogger struct {
+	logger *logging.Logger
+	prefix string
+}
+
+func newTransactionLogger(chainID, txID string) *TransactionLogger {
+	return &TransactionLogger{
+		logger: transactionLogger,
+		prefix: fmt.Sprintf("[%s][%s] ", chainID, shorttxid(txID)),
+	}
+}
+
+func (l *TransactionLogger) log(level logging.Level, format string, args []interface{}) {
+	if !l.logger.IsEnabledFor(level) {
+		return
+	}
+	msg := l.prefix + fmt.Sprintf(format, args...)
+	switch level {
+	case logging.DEBUG:
+		l.logger.Debug(msg)
+	case logging.INFO:
+		l.logger.Info(msg)
+	case logging.WARNING:
+		l.logger.Warning(msg)
+	default:
+		l.logger.Error(msg)
+	}
+}
+
+// Debugf logs a formatted message at the DEBUG level.
+func (l *TransactionLogger) Debugf(format string, args ...interface{}) {
+	l.log(logging.DEBUG, format, args)
+}
+
+// Infof logs a formatted message at the INFO level.
+func (l *TransactionLogger) Infof(format string, args ...interface{}) {
+	l.log(logging.INFO, format, args)
+}
+
+// Warningf logs a formatted message at the WARNING level.
+func (l *TransactionLogger) Warningf(format string, args ...interface{}) {
+	l.log(logging.WARNING, format, args)
+}
+
+// Errorf logs a formatted message at the ERROR level.
+func (l *TransactionLogger) Errorf(format string, args ...interface{}) {
+	l.log(logging.ERROR, format, args)
+}
@@ -362,6 +362,9 @@ type QueryResponse struct {
 	Results []*QueryResultBytes `protobuf:"bytes,1,rep,name=results" json:"results,omitempty"`
 	HasMore bool                `protobuf:"varint,2,opt,name=has_more,json=hasMore" json:"has_more,omitempty"`
 	Id      string              `protobuf:"bytes,3,opt,name=id" json:"id,omitempty"`
+	// When set, results holds a single entry whose bytes are a gzip
+	// compressed QueryResponse carrying the results of the batch.
+	Compressed bool `protobuf:"varint,4,opt,name=compressed" json:"compressed,omitempty"`
 }
 
 func (m *QueryResponse) Reset()                    { *m = QueryResponse{} }
@@ -390,6 +393,13 @@ func (m *QueryResponse) GetId() string {
 	return ""
 }
 
+func (m *QueryResponse) GetCompressed() bool {
+	if m != nil {
+		return m.Compressed
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterType((*ChaincodeMessage)(nil), "protos.ChaincodeMessage")
 	proto.RegisterType((*GetState)(nil), "protos.GetState")
@@ -512,57 +522,58 @@ var _ChaincodeSupport_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("peer/chaincode_shim.proto", fileDescriptor3) }
 
 var fileDescriptor3 = []byte{
-	// 825 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x95, 0x51, 0x6f, 0xe2, 0x46,
-	0x10, 0xc7, 0x8f, 0x00, 0xc1, 0x0c, 0x09, 0xec, 0x6d, 0xae, 0xa9, 0x0f, 0xe9, 0x5a, 0x8a, 0xfa,
-	0x40, 0x5f, 0xa0, 0xa5, 0x7d, 0xe8, 0xc3, 0x49, 0x15, 0xc1, 0x1b, 0x62, 0x85, 0xd8, 0xdc, 0xda,
-	0x39, 0x1d, 0x7d, 0xb1, 0x1c, 0xbc, 0x67, 0xac, 0x1a, 0xaf, 0x6b, 0x2f, 0xa7, 0xf3, 0x67, 0xe8,
-	0x07, 0xeb, 0xd7, 0xaa, 0xd6, 0xc6, 0x84, 0x23, 0x8a, 0x4e, 0xea, 0x13, 0xfe, 0xcf, 0xfc, 0x66,
-	0xe6, 0x3f, 0xd6, 0xb2, 0x86, 0xd7, 0x31, 0x63, 0xc9, 0x68, 0xb5, 0x76, 0x83, 0x68, 0xc5, 0x3d,
-	0xe6, 0xa4, 0xeb, 0x60, 0x33, 0x8c, 0x13, 0x2e, 0x38, 0x3e, 0xcd, 0x7f, 0xd2, 0x6e, 0xf7, 0x08,
-	0x61, 0x9f, 0x58, 0x24, 0x0a, 0xa6, 0x7b, 0x91, 0xe7, 0xe2, 0x84, 0xc7, 0x3c, 0x75, 0xc3, 0x5d,
-	0xf0, 0x7b, 0x9f, 0x73, 0x3f, 0x64, 0xa3, 0x5c, 0x3d, 0x6c, 0x3f, 0x8e, 0x44, 0xb0, 0x61, 0xa9,
-	0x70, 0x37, 0x71, 0x01, 0xf4, 0xff, 0xa9, 0x03, 0x9a, 0x96, 0xfd, 0xee, 0x58, 0x9a, 0xba, 0x3e,
-	0xc3, 0xbf, 0x40, 0x4d, 0x64, 0x31, 0x53, 0x2b, 0xbd, 0xca, 0xa0, 0x3d, 0x7e, 0x53, 0xa0, 0xe9,
-	0xf0, 0x98, 0x1b, 0xda, 0x59, 0xcc, 0x68, 0x8e, 0xe2, 0xdf, 0xa1, 0xb9, 0x6f, 0xad, 0x9e, 0xf4,
-	0x2a, 0x83, 0xd6, 0xb8, 0x3b, 0x2c, 0x86, 0x0f, 0xcb, 0xe1, 0x43, 0xbb, 0x24, 0xe8, 0x23, 0x8c,
-	0x55, 0x68, 0xc4, 0x6e, 0x16, 0x72, 0xd7, 0x53, 0xab, 0xbd, 0xca, 0xe0, 0x8c, 0x96, 0x12, 0x63,
-	0xa8, 0x89, 0xcf, 0x81, 0xa7, 0xd6, 0x7a, 0x95, 0x41, 0x93, 0xe6, 0xcf, 0x78, 0x0c, 0x4a, 0xb9,
-	0xa2, 0x5a, 0xcf, 0xc7, 0x5c, 0x96, 0xf6, 0xac, 0xc0, 0x8f, 0x98, 0xb7, 0xd8, 0x65, 0xe9, 0x9e,
-	0xc3, 0x7f, 0x40, 0xe7, 0xe8, 0x95, 0xa9, 0xa7, 0x5f, 0x96, 0xee, 0x37, 0x23, 0x32, 0x4b, 0xdb,
-	0xab, 0x2f, 0x34, 0x7e, 0x03, 0xb0, 0x5a, 0xbb, 0x51, 0xc4, 0x42, 0x27, 0xf0, 0xd4, 0x46, 0x6e,
-	0xa7, 0xb9, 0x8b, 0xe8, 0x5e, 0xff, 0xdf, 0x13, 0xa8, 0xc9, 0x57, 0x81, 0xcf, 0xa1, 0x79, 0x6f,
-	0x68, 0xe4, 0x5a, 0x37, 0x88, 0x86, 0x5e, 0xe0, 0x33, 0x50, 0x28, 0x99, 0xe9, 0x96, 0x4d, 0x28,
-	0xaa, 0xe0, 0x36, 0x40, 0xa9, 0x88, 0x86, 0x4e, 0xb0, 0x02, 0x35, 0xdd, 0xd0, 0x6d, 0x54, 0xc5,
-	0x4d, 0xa8, 0x53, 0x32, 0xd1, 0x96, 0xa8, 0x86, 0x3b, 0xd0, 0xb2, 0xe9, 0xc4, 0xb0, 0x26, 0x53,
-	0x5b, 0x37, 0x0d, 0x54, 0x97, 0x2d, 0xa7, 0xe6, 0xdd, 0x62, 0x4e, 0x6c, 0xa2, 0xa1, 0x53, 0x89,
-	0x12, 0x4a, 0x4d, 0x8a, 0x1a, 0x32, 0x33, 0x23, 0xb6, 0x63, 0xd9, 0x13, 0x9b, 0x20, 0x45, 0xca,
-	0xc5, 0x7d, 0x29, 0x9b, 0x52, 0x6a, 0x64, 0xbe, 0x93, 0x80, 0x5f, 0x01, 0xd2, 0x8d, 0xf7, 0xe6,
-	0x2d, 0x71, 0xa6, 0x37, 0x13, 0xdd, 0x98, 0x9a, 0x1a, 0x41, 0xad, 0xc2, 0xa0, 0xb5, 0x30, 0x0d,
-	0x8b, 0xa0, 0x73, 0x7c, 0x09, 0x78, 0xdf, 0xd0, 0xb9, 0x5a, 0x3a, 0x74, 0x62, 0xcc, 0x08, 0x6a,
-	0xcb, 0x5a, 0x19, 0x7f, 0x77, 0x4f, 0xe8, 0xd2, 0xa1, 0xc4, 0xba, 0x9f, 0xdb, 0xa8, 0x23, 0xa3,
-	0x45, 0xa4, 0xe0, 0x0d, 0xf2, 0xc1, 0x46, 0x08, 0x7f, 0x03, 0x2f, 0x0f, 0xa3, 0xd3, 0xb9, 0x69,
-	0x11, 0xf4, 0x52, 0xba, 0xb9, 0x25, 0x64, 0x31, 0x99, 0xeb, 0xef, 0x09, 0xc2, 0xf8, 0x5b, 0xb8,
-	0x90, 0x1d, 0x6f, 0x74, 0xcb, 0x36, 0xe9, 0xd2, 0xb9, 0x36, 0xa9, 0x73, 0x4b, 0x96, 0xe8, 0xa2,
-	0xff, 0x16, 0x94, 0x19, 0x13, 0x96, 0x70, 0x05, 0xc3, 0x08, 0xaa, 0x7f, 0xb1, 0x2c, 0x3f, 0x83,
-	0x4d, 0x2a, 0x1f, 0xf1, 0x77, 0x00, 0x2b, 0x1e, 0x86, 0x6c, 0x25, 0x02, 0x1e, 0xe5, 0x87, 0xac,
-	0x49, 0x0f, 0x22, 0x7d, 0x0a, 0xca, 0x62, 0xfb, 0x6c, 0xf5, 0x2b, 0xa8, 0x7f, 0x72, 0xc3, 0x2d,
-	0xcb, 0x0b, 0xcf, 0x68, 0x21, 0x8e, 0x7a, 0x56, 0x9f, 0xf4, 0x7c, 0x0b, 0x8a, 0xc6, 0xc2, 0xff,
-	0xeb, 0x88, 0x41, 0xa7, 0xdc, 0xe7, 0x2a, 0xa3, 0x6e, 0xe4, 0x33, 0xdc, 0x05, 0x25, 0x15, 0x6e,
-	0x22, 0x6e, 0xf7, 0x9d, 0xf6, 0x1a, 0x5f, 0xc2, 0x29, 0x8b, 0x3c, 0x99, 0x29, 0x5a, 0xed, 0xd4,
-	0x57, 0x4d, 0x5e, 0x43, 0x7b, 0xc6, 0xc4, 0xbb, 0x2d, 0x4b, 0x32, 0xca, 0xd2, 0x6d, 0x28, 0xe4,
-	0xb2, 0x7f, 0x4b, 0xb9, 0x1b, 0x51, 0x88, 0xaf, 0xda, 0xfd, 0x11, 0xd0, 0x8c, 0x89, 0x9b, 0x20,
-	0x15, 0x3c, 0xc9, 0xae, 0x79, 0x22, 0x67, 0x3f, 0x59, 0xba, 0xdf, 0x83, 0x76, 0x3e, 0x2a, 0x5f,
-	0xcb, 0x60, 0x9f, 0x05, 0x6e, 0xc3, 0x49, 0xe0, 0xed, 0x90, 0x93, 0xc0, 0xeb, 0xff, 0x00, 0x9d,
-	0x47, 0x62, 0x1a, 0xf2, 0x94, 0x3d, 0x41, 0x7e, 0x03, 0x74, 0xe0, 0xf7, 0x2a, 0x13, 0x2c, 0xc5,
-	0x3d, 0x68, 0x25, 0x8f, 0x32, 0x87, 0xcf, 0xe8, 0x61, 0xa8, 0x1f, 0xc1, 0x79, 0x59, 0x15, 0xf3,
-	0x28, 0x65, 0x78, 0x0c, 0x8d, 0x22, 0x2f, 0xf1, 0xea, 0xa0, 0x35, 0x56, 0xcb, 0xbf, 0xf4, 0x71,
-	0x77, 0x5a, 0x82, 0xf8, 0x35, 0x28, 0x6b, 0x37, 0x75, 0x36, 0x3c, 0x29, 0xce, 0x82, 0x42, 0x1b,
-	0x6b, 0x37, 0xbd, 0xe3, 0x49, 0xe9, 0xb2, 0x5a, 0xba, 0x1c, 0x7f, 0x38, 0xb8, 0x1c, 0xad, 0x6d,
-	0x1c, 0xf3, 0x44, 0x60, 0x0d, 0x14, 0xca, 0xfc, 0x20, 0x15, 0x2c, 0xc1, 0xea, 0x73, 0x57, 0x63,
-	0xf7, 0xd9, 0x4c, 0xff, 0xc5, 0xa0, 0xf2, 0x73, 0xe5, 0xca, 0x84, 0x3e, 0x4f, 0xfc, 0xe1, 0x3a,
-	0x8b, 0x59, 0x12, 0x32, 0xcf, 0x67, 0xc9, 0xf0, 0xa3, 0xfb, 0x90, 0x04, 0xab, 0xb2, 0x4e, 0xde,
-	0xe6, 0x7f, 0xfe, 0xe4, 0x07, 0x62, 0xbd, 0x7d, 0x18, 0xae, 0xf8, 0x66, 0x74, 0x80, 0x8e, 0x0a,
-	0xb4, 0xb8, 0xd5, 0xd3, 0x91, 0x44, 0x1f, 0x8a, 0x4f, 0xc4, 0xaf, 0xff, 0x05, 0x00, 0x00, 0xff,
-	0xff, 0x18, 0x6a, 0x79, 0xe7, 0x46, 0x06, 0x00, 0x00,
+	// 838 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x95, 0xdf, 0x6e, 0xe2, 0x46,
+	0x14, 0xc6, 0x97, 0x7f, 0xc1, 0x1c, 0x12, 0x98, 0x9d, 0x6c, 0x53, 0x2f, 0xd2, 0xb6, 0x14, 0xf5,
+	0x82, 0xde, 0x40, 0x4b, 0x7b, 0xd1, 0x8b, 0x95, 0x2a, 0x02, 0x13, 0x62, 0x85, 0xd8, 0xec, 0xd8,
+	0x59, 0x2d, 0xbd, 0xb1, 0x1c, 0x3c, 0x0b, 0x56, 0x0d, 0xe3, 0x7a, 0x86, 0xd5, 0xfa, 0x19, 0x2a,
+	0xf5, 0xb5, 0xfa, 0x5a, 0xd5, 0xd8, 0x98, 0xb0, 0x44, 0x51, 0xa4, 0xbd, 0x8a, 0xbf, 0x73, 0x7e,
+	0xe7, 0x9c, 0xef, 0x8c, 0x26, 0x03, 0xbc, 0x8e, 0x18, 0x8b, 0xfb, 0x8b, 0x95, 0x17, 0x6c, 0x16,
+	0xdc, 0x67, 0xae, 0x58, 0x05, 0xeb, 0x5e, 0x14, 0x73, 0xc9, 0xf1, 0x49, 0xfa, 0x47, 0xb4, 0x5a,
+	0x47, 0x08, 0xfb, 0xc4, 0x36, 0x32, 0x63, 0x5a, 0xe7, 0x69, 0x2e, 0x8a, 0x79, 0xc4, 0x85, 0x17,
+	0xee, 0x82, 0xdf, 0x2f, 0x39, 0x5f, 0x86, 0xac, 0x9f, 0xaa, 0xfb, 0xed, 0xc7, 0xbe, 0x0c, 0xd6,
+	0x4c, 0x48, 0x6f, 0x1d, 0x65, 0x40, 0xe7, 0x9f, 0x0a, 0xa0, 0x51, 0xde, 0xef, 0x96, 0x09, 0xe1,
+	0x2d, 0x19, 0xfe, 0x05, 0xca, 0x32, 0x89, 0x98, 0x5e, 0x68, 0x17, 0xba, 0x8d, 0xc1, 0x9b, 0x0c,
+	0x15, 0xbd, 0x63, 0xae, 0xe7, 0x24, 0x11, 0xa3, 0x29, 0x8a, 0x7f, 0x87, 0xda, 0xbe, 0xb5, 0x5e,
+	0x6c, 0x17, 0xba, 0xf5, 0x41, 0xab, 0x97, 0x0d, 0xef, 0xe5, 0xc3, 0x7b, 0x4e, 0x4e, 0xd0, 0x07,
+	0x18, 0xeb, 0x50, 0x8d, 0xbc, 0x24, 0xe4, 0x9e, 0xaf, 0x97, 0xda, 0x85, 0xee, 0x29, 0xcd, 0x25,
+	0xc6, 0x50, 0x96, 0x9f, 0x03, 0x5f, 0x2f, 0xb7, 0x0b, 0xdd, 0x1a, 0x4d, 0xbf, 0xf1, 0x00, 0xb4,
+	0x7c, 0x45, 0xbd, 0x92, 0x8e, 0xb9, 0xc8, 0xed, 0xd9, 0xc1, 0x72, 0xc3, 0xfc, 0xd9, 0x2e, 0x4b,
+	0xf7, 0x1c, 0xfe, 0x03, 0x9a, 0x47, 0x47, 0xa6, 0x9f, 0x7c, 0x59, 0xba, 0xdf, 0x8c, 0xa8, 0x2c,
+	0x6d, 0x2c, 0xbe, 0xd0, 0xf8, 0x0d, 0xc0, 0x62, 0xe5, 0x6d, 0x36, 0x2c, 0x74, 0x03, 0x5f, 0xaf,
+	0xa6, 0x76, 0x6a, 0xbb, 0x88, 0xe1, 0x77, 0xfe, 0x2b, 0x42, 0x59, 0x1d, 0x05, 0x3e, 0x83, 0xda,
+	0x9d, 0x39, 0x26, 0x57, 0x86, 0x49, 0xc6, 0xe8, 0x05, 0x3e, 0x05, 0x8d, 0x92, 0x89, 0x61, 0x3b,
+	0x84, 0xa2, 0x02, 0x6e, 0x00, 0xe4, 0x8a, 0x8c, 0x51, 0x11, 0x6b, 0x50, 0x36, 0x4c, 0xc3, 0x41,
+	0x25, 0x5c, 0x83, 0x0a, 0x25, 0xc3, 0xf1, 0x1c, 0x95, 0x71, 0x13, 0xea, 0x0e, 0x1d, 0x9a, 0xf6,
+	0x70, 0xe4, 0x18, 0x96, 0x89, 0x2a, 0xaa, 0xe5, 0xc8, 0xba, 0x9d, 0x4d, 0x89, 0x43, 0xc6, 0xe8,
+	0x44, 0xa1, 0x84, 0x52, 0x8b, 0xa2, 0xaa, 0xca, 0x4c, 0x88, 0xe3, 0xda, 0xce, 0xd0, 0x21, 0x48,
+	0x53, 0x72, 0x76, 0x97, 0xcb, 0x9a, 0x92, 0x63, 0x32, 0xdd, 0x49, 0xc0, 0xaf, 0x00, 0x19, 0xe6,
+	0x7b, 0xeb, 0x86, 0xb8, 0xa3, 0xeb, 0xa1, 0x61, 0x8e, 0xac, 0x31, 0x41, 0xf5, 0xcc, 0xa0, 0x3d,
+	0xb3, 0x4c, 0x9b, 0xa0, 0x33, 0x7c, 0x01, 0x78, 0xdf, 0xd0, 0xbd, 0x9c, 0xbb, 0x74, 0x68, 0x4e,
+	0x08, 0x6a, 0xa8, 0x5a, 0x15, 0x7f, 0x77, 0x47, 0xe8, 0xdc, 0xa5, 0xc4, 0xbe, 0x9b, 0x3a, 0xa8,
+	0xa9, 0xa2, 0x59, 0x24, 0xe3, 0x4d, 0xf2, 0xc1, 0x41, 0x08, 0x7f, 0x03, 0x2f, 0x0f, 0xa3, 0xa3,
+	0xa9, 0x65, 0x13, 0xf4, 0x52, 0xb9, 0xb9, 0x21, 0x64, 0x36, 0x9c, 0x1a, 0xef, 0x09, 0xc2, 0xf8,
+	0x5b, 0x38, 0x57, 0x1d, 0xaf, 0x0d, 0xdb, 0xb1, 0xe8, 0xdc, 0xbd, 0xb2, 0xa8, 0x7b, 0x43, 0xe6,
+	0xe8, 0xbc, 0xf3, 0x16, 0xb4, 0x09, 0x93, 0xb6, 0xf4, 0x24, 0xc3, 0x08, 0x4a, 0x7f, 0xb1, 0x24,
+	0xbd, 0x83, 0x35, 0xaa, 0x3e, 0xf1, 0x77, 0x00, 0x0b, 0x1e, 0x86, 0x6c, 0x21, 0x03, 0xbe, 0x49,
+	0x2f, 0x59, 0x8d, 0x1e, 0x44, 0x3a, 0x14, 0xb4, 0xd9, 0xf6, 0xc9, 0xea, 0x57, 0x50, 0xf9, 0xe4,
+	0x85, 0x5b, 0x96, 0x16, 0x9e, 0xd2, 0x4c, 0x1c, 0xf5, 0x2c, 0x3d, 0xea, 0xf9, 0x16, 0xb4, 0x31,
+	0x0b, 0xbf, 0xd6, 0x11, 0x83, 0x66, 0xbe, 0xcf, 0x65, 0x42, 0xbd, 0xcd, 0x92, 0xe1, 0x16, 0x68,
+	0x42, 0x7a, 0xb1, 0xbc, 0xd9, 0x77, 0xda, 0x6b, 0x7c, 0x01, 0x27, 0x6c, 0xe3, 0xab, 0x4c, 0xd6,
+	0x6a, 0xa7, 0x9e, 0x35, 0x79, 0x05, 0x8d, 0x09, 0x93, 0xef, 0xb6, 0x2c, 0x4e, 0x28, 0x13, 0xdb,
+	0x50, 0xaa, 0x65, 0xff, 0x56, 0x72, 0x37, 0x22, 0x13, 0xcf, 0xda, 0xfd, 0x11, 0xd0, 0x84, 0xc9,
+	0xeb, 0x40, 0x48, 0x1e, 0x27, 0x57, 0x3c, 0x56, 0xb3, 0x1f, 0x2d, 0xdd, 0x69, 0x43, 0x23, 0x1d,
+	0x95, 0xae, 0x65, 0xb2, 0xcf, 0x12, 0x37, 0xa0, 0x18, 0xf8, 0x3b, 0xa4, 0x18, 0xf8, 0x9d, 0x1f,
+	0xa0, 0xf9, 0x40, 0x8c, 0x42, 0x2e, 0xd8, 0x23, 0xe4, 0x37, 0x40, 0x07, 0x7e, 0x2f, 0x13, 0xc9,
+	0x04, 0x6e, 0x43, 0x3d, 0x7e, 0x90, 0x29, 0x7c, 0x4a, 0x0f, 0x43, 0x9d, 0x7f, 0x0b, 0x70, 0x96,
+	0x97, 0x45, 0x7c, 0x23, 0x18, 0x1e, 0x40, 0x35, 0x03, 0x14, 0x5f, 0xea, 0xd6, 0x07, 0x7a, 0xfe,
+	0x3f, 0x7d, 0xdc, 0x9e, 0xe6, 0x20, 0x7e, 0x0d, 0xda, 0xca, 0x13, 0xee, 0x9a, 0xc7, 0xd9, 0x65,
+	0xd0, 0x68, 0x75, 0xe5, 0x89, 0x5b, 0x1e, 0xe7, 0x36, 0x4b, 0xb9, 0xcd, 0xec, 0xc4, 0xd6, 0x51,
+	0xcc, 0x84, 0x60, 0xd9, 0x43, 0xa4, 0xd1, 0x83, 0xc8, 0xe0, 0xc3, 0xc1, 0xeb, 0x69, 0x6f, 0xa3,
+	0x88, 0xc7, 0x12, 0x8f, 0x41, 0xa3, 0x6c, 0x19, 0x08, 0xc9, 0x62, 0xac, 0x3f, 0xf5, 0x76, 0xb6,
+	0x9e, 0xcc, 0x74, 0x5e, 0x74, 0x0b, 0x3f, 0x17, 0x2e, 0x2d, 0xe8, 0xf0, 0x78, 0xd9, 0x5b, 0x25,
+	0x11, 0x8b, 0x43, 0xe6, 0x2f, 0x59, 0xdc, 0xfb, 0xe8, 0xdd, 0xc7, 0xc1, 0x22, 0xaf, 0x53, 0xcf,
+	0xfd, 0x9f, 0x3f, 0x2d, 0x03, 0xb9, 0xda, 0xde, 0xf7, 0x16, 0x7c, 0xdd, 0x3f, 0x40, 0xfb, 0x19,
+	0x9a, 0x3d, 0xfb, 0xa2, 0xaf, 0xd0, 0xfb, 0xec, 0x37, 0xe4, 0xd7, 0xff, 0x07, 0x00, 0x6b, 0xc0,
+	0x46, 0xd8, 0x67, 0x06, 0x00, 0x00,
 }